@@ -4,26 +4,57 @@ package main
 // - https://dev.to/vearutop/benchmarking-correctness-and-performance-of-go-json-schema-validators-3247
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/syslog"
+	"math"
+	"math/rand"
+	"net"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
+	"runtime/trace"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/sourcegraph/conc/pool"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
+	_ "modernc.org/sqlite"
 )
 
 func panic_on_err(err error, action string) {
@@ -32,10 +63,144 @@ func panic_on_err(err error, action string) {
 	}
 }
 
+// returned (wrapped) when an article-json file's sha256 doesn't match the one in the --checksums manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// returned (wrapped) when an article's 'article.status' has no matching schema.
+var ErrUnknownStatus = errors.New("no schema for article status")
+
+// returned when an article-json file is empty or only whitespace.
+var ErrEmptyArticle = errors.New("empty article file")
+
+// returned (wrapped) when an article-json file is nested deeper than --max-depth.
+var ErrMaxDepth = errors.New("maximum json depth exceeded")
+
+// returned (wrapped) when an article-json file has more than whitespace after its json document,
+// like a file that was written twice or two documents concatenated.
+var ErrTrailingData = errors.New("trailing data after json document")
+
+// returned (wrapped) when an article-json file is larger than --max-file-size, it's failed without being read.
+var ErrFileTooLarge = errors.New("file too large")
+
+// returned (wrapped) with --check-utf8 when an article-json file isn't valid UTF-8.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
 type Schema struct {
 	Label  string
 	Path   string
 	Schema *jsonschema.Schema
+	// hex digest of the schema file as it was compiled, after any patching.
+	Sha256 string
+	// the '$schema' declared in the schema file, empty if it has none.
+	Declared string
+	// the changes made to the schema file before it was compiled, see --print-schema-info.
+	Patches []SchemaPatch
+	// true if 'format' is validated rather than just an annotation, see `asserts_format`.
+	AssertFormat bool
+	// true if 'contentEncoding' and 'contentMediaType' are validated, see `asserts_content`.
+	AssertContent bool
+}
+
+// a change made to a schema file before it was compiled, so the validator isn't checking exactly what the file says.
+type SchemaPatch struct {
+	Label string `json:"label"`
+	// gjson path to the value changed within the schema file.
+	Path string `json:"path"`
+	// the value as json before and after the change, empty if there was no value or it was removed.
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	Reason string `json:"reason"`
+	// true for the patches always made to the schemas, like the ISBN pattern, rather than those asked for.
+	Default bool `json:"default"`
+}
+
+// "VOR allOf.2.properties.references.items.definitions.book.properties.isbn.pattern: "^(...)$" => "^.+$" (reason)"
+func (p SchemaPatch) String() string {
+	old, new := p.Old, p.New
+	if old == "" {
+		old = "(none)"
+	}
+	if new == "" {
+		new = "(removed)"
+	}
+	return fmt.Sprintf("%s %s: %s => %s (%s)", p.Label, p.Path, old, new, p.Reason)
+}
+
+// returns the patches made to the schemas in `schema_map` that were asked for, like a --schema-overlay,
+// ordered by schema label. see --fail-on-modification.
+func requested_patches(schema_map map[string]Schema) []SchemaPatch {
+	label_list := []string{}
+	for label := range schema_map {
+		label_list = append(label_list, label)
+	}
+	slices.Sort(label_list)
+	patch_list := []SchemaPatch{}
+	for _, label := range label_list {
+		for _, patch := range schema_map[label].Patches {
+			if !patch.Default {
+				patch_list = append(patch_list, patch)
+			}
+		}
+	}
+	return patch_list
+}
+
+// returns true if the 'format' keyword of a schema compiled as `draft` is validated.
+// drafts before 2019-09 always validate it. later drafts only validate it when `assert_format` is set,
+// unless the schema doesn't `declare` a '$schema', then the compiler validates it regardless.
+func asserts_format(draft *jsonschema.Draft, declared string, assert_format bool) bool {
+	if draft == jsonschema.Draft4 || draft == jsonschema.Draft6 || draft == jsonschema.Draft7 {
+		return true
+	}
+	return assert_format || declared == ""
+}
+
+// returns true if the 'contentEncoding' and 'contentMediaType' keywords of a schema compiled as `draft` are validated.
+// they don't exist before draft 7, draft 7 always validates them, later drafts only when `assert_content` is set.
+func asserts_content(draft *jsonschema.Draft, assert_content bool) bool {
+	if draft == jsonschema.Draft4 || draft == jsonschema.Draft6 {
+		return false
+	}
+	return draft == jsonschema.Draft7 || assert_content
+}
+
+// "format assertion:on, content assertion:off" for the schemas in `schema_map`.
+// "partial" lists the schemas an assertion is on for when it isn't on for all of them.
+func assertion_summary(schema_map map[string]Schema) string {
+	state := func(is_on func(Schema) bool) string {
+		on_list := []string{}
+		for label, schema := range schema_map {
+			if is_on(schema) {
+				on_list = append(on_list, label)
+			}
+		}
+		slices.Sort(on_list)
+		switch len(on_list) {
+		case 0:
+			return "off"
+		case len(schema_map):
+			return "on"
+		}
+		return "partial (" + strings.Join(on_list, ", ") + ")"
+	}
+	return fmt.Sprintf("format assertion:%s, content assertion:%s",
+		state(func(s Schema) bool { return s.AssertFormat }), state(func(s Schema) bool { return s.AssertContent }))
+}
+
+// returns a single hex digest of all the schemas in `schema_map`, see --schema-fingerprint.
+// the fingerprint changes when any schema file changes, including when it's patched differently.
+// schemas loaded through a `$ref` are not included.
+func schema_fingerprint(schema_map map[string]Schema) string {
+	label_list := []string{}
+	for label := range schema_map {
+		label_list = append(label_list, label)
+	}
+	slices.Sort(label_list)
+	fingerprint := ""
+	for _, label := range label_list {
+		fingerprint += label + ":" + schema_map[label].Sha256 + "\n"
+	}
+	return content_hash("sha256", []byte(fingerprint))
 }
 
 type Result struct {
@@ -45,372 +210,6806 @@ type Result struct {
 	Success  bool
 	// these can get large. I recommend not accumulating them for large jobs with many problems.
 	Error error
+	// advisory problems found with the article that don't affect `Success`.
+	Warnings []string
+	// the article wasn't validated, for example an empty file with --allow-empty.
+	Skipped bool
+	// size of the article-json file on disk.
+	Bytes int
+	// number of leaf validation errors, available even when `Error` isn't captured.
+	ErrorCount int
+	// hex digest of the article-json file on disk, see --hash.
+	Hash string
+	// the 'article' section of an invalid article-json file as json, see --include-raw.
+	Raw json.RawMessage
+	// number of leaf validation errors ignored, see --ignore-keyword.
+	// a successful result with ignored errors passed only because they were ignored.
+	IgnoredErrors int
+	// the highest severity of a failure's errors, "high", "medium" or "low", see `error_severity`.
+	Severity string
+	// `Elapsed` before it was truncated to whole milliseconds, see `Result.elapsed_time`.
+	elapsed time.Duration
+}
+
+// returns how long the result took to validate, as precisely as it's known.
+func (r Result) elapsed_time() time.Duration {
+	if r.elapsed > 0 {
+		return r.elapsed
+	}
+	return time.Duration(r.Elapsed) * time.Millisecond
 }
 
 // "VOR valid in      2.6ms: elife-09560-v1.xml.json"
 // "POA invalid in  123.4ms: elife-09560-v1.xml.json"
 func (r Result) String() string {
+	return r.format(false)
+}
+
+// same as `String`, but 'valid' and 'invalid' are colored green and red when `color` is true.
+func (r Result) format(color bool) string {
 	msg := "%s %s in\t%4dms: %s"
+	if r.Skipped {
+		return fmt.Sprintf(msg, "---", colorise("skipped", ansi_yellow, color), r.Elapsed, r.FileName)
+	}
+	label := r.Type
+	if label == "" {
+		// article failed before its type could be determined
+		label = "---"
+	}
 	if r.Success {
-		return fmt.Sprintf(msg, r.Type, "valid", r.Elapsed, r.FileName)
+		return fmt.Sprintf(msg, label, colorise("valid", ansi_green, color), r.Elapsed, r.FileName)
 	}
-	return fmt.Sprintf(msg, r.Type, "invalid", r.Elapsed, r.FileName)
+	return fmt.Sprintf(msg, label, colorise("invalid", ansi_red, color), r.Elapsed, r.FileName)
 }
 
-type Article struct {
-	Type     string // POA or VOR
-	FileName string
-	Data     interface{} // unmarshalled json data
+// parses the --format-template `text`, a Go text/template given each `Result`, "{{.Type}} {{.FileName}} {{.Elapsed}}".
+// the template is executed against an empty result so an unknown field fails here rather than on the first article.
+func parse_format_template(text string) (*template.Template, error) {
+	tmpl, err := template.New("format-template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	err = tmpl.Execute(io.Discard, Result{})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
 }
 
-// given a globbed path `pattern`, return the latest version of any matches.
-// for example, if `/path/to/vor.v*.json` matches a `vor.v1.json` and `vor.v2.json`,
-// then `/path/to/vor.v2.json` will be returned.
-func find_first_schema(pattern string) (string, error) {
-	empty_response := ""
-	path_list, err := filepath.Glob(pattern)
+// returns the short valid/invalid message for `result`, or `tmpl` executed with the `result` when it's set.
+// a template that fails for this result falls back to the valid/invalid message.
+func format_result(result Result, color bool, tmpl *template.Template) string {
+	if tmpl == nil {
+		return result.format(color)
+	}
+	buf := strings.Builder{}
+	err := tmpl.Execute(&buf, result)
 	if err != nil {
-		return empty_response, fmt.Errorf("no path to POA schema found: %w", err)
+		return result.format(color)
 	}
-	slices.Sort(path_list)              // sorts ASC, lowest version to highest version
-	path := path_list[len(path_list)-1] // use highest version available
-	return path, nil
+	return buf.String()
 }
 
-// creates a json-schema validator,
-// adds the latest POA and VOR schemas it can find to it,
-// compiles them,
-// returning a map of labels => compiled-schemas
-func configure_validator(schema_root string) (map[string]Schema, error) {
-	var empty_response map[string]Schema
+// a serializable representation of a validation error.
+// `Causes` are the nested errors that explain this error.
+type ErrorDetail struct {
+	InstanceLocation        string        `json:"instance_location"`
+	KeywordLocation         string        `json:"keyword_location"`
+	AbsoluteKeywordLocation string        `json:"absolute_keyword_location"`
+	Message                 string        `json:"message"`
+	Causes                  []ErrorDetail `json:"causes,omitempty"`
+}
 
-	compiler := jsonschema.NewCompiler()
-	compiler.Draft = jsonschema.Draft4
+// converts `err` into a serializable tree of `ErrorDetail`.
+// a `*jsonschema.ValidationError` becomes a single root with nested causes,
+// any other error becomes a single detail with just a message.
+func DetailedError(err error) []ErrorDetail {
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []ErrorDetail{{Message: err.Error()}}
+	}
+	var walk func(ve *jsonschema.ValidationError) ErrorDetail
+	walk = func(ve *jsonschema.ValidationError) ErrorDetail {
+		detail := ErrorDetail{
+			InstanceLocation:        ve.InstanceLocation,
+			KeywordLocation:         ve.KeywordLocation,
+			AbsoluteKeywordLocation: ve.AbsoluteKeywordLocation,
+			Message:                 ve.Message,
+		}
+		for _, cause := range ve.Causes {
+			detail.Causes = append(detail.Causes, walk(cause))
+		}
+		return detail
+	}
+	return []ErrorDetail{walk(ve)}
+}
 
-	poa_schema, err := find_first_schema(path.Join(schema_root, "/dist/model/article-poa.v*.json"))
-	if err != nil {
-		return empty_response, errors.New("failed to find a POA schema")
+func (r Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type          string          `json:"type"`
+		FileName      string          `json:"file_name"`
+		ElapsedMs     int64           `json:"elapsed_ms"`
+		Success       bool            `json:"success"`
+		Bytes         int             `json:"bytes"`
+		ErrorCount    int             `json:"error_count"`
+		Hash          string          `json:"hash,omitempty"`
+		Errors        []ErrorDetail   `json:"errors,omitempty"`
+		Warnings      []string        `json:"warnings,omitempty"`
+		Skipped       bool            `json:"skipped,omitempty"`
+		Raw           json.RawMessage `json:"raw,omitempty"`
+		IgnoredErrors int             `json:"ignored_errors,omitempty"`
+		Severity      string          `json:"severity,omitempty"`
+	}{
+		Type:          r.Type,
+		FileName:      r.FileName,
+		ElapsedMs:     r.Elapsed,
+		Success:       r.Success,
+		Bytes:         r.Bytes,
+		ErrorCount:    r.ErrorCount,
+		Hash:          r.Hash,
+		Errors:        DetailedError(r.Error),
+		Warnings:      r.Warnings,
+		Skipped:       r.Skipped,
+		Raw:           r.Raw,
+		IgnoredErrors: r.IgnoredErrors,
+		Severity:      r.Severity,
+	})
+}
+
+// totals for a batch of results.
+type Summary struct {
+	Articles int `json:"articles"`
+	Failures int `json:"failures"`
+	Warnings int `json:"warnings"`
+	Skipped  int `json:"skipped"`
+	// articles that passed only because their errors were ignored, see --ignore-keyword.
+	PassedWithIgnored int `json:"passed_with_ignored"`
+	// results returned from the --result-cache-size cache rather than validated.
+	CacheHits int `json:"cache_hits,omitempty"`
+	// failures that weren't read as they were over the --max-file-size.
+	TooLarge int `json:"too_large,omitempty"`
+	// number of failures of each severity, see `error_severity`.
+	Severity   map[string]int `json:"severity,omitempty"`
+	Workers    int            `json:"workers"`
+	WallTimeMs int64          `json:"wall_time_ms"`
+	CpuTimeMs  int64          `json:"cpu_time_ms"`
+	// the json report has these in its `Meta`.
+	StartTime time.Time `json:"-"`
+	EndTime   time.Time `json:"-"`
+}
+
+// returns the average validation time of an article, or zero when no articles were validated.
+// a run can end before any articles are validated, see --deadline.
+func (s Summary) CpuTimePerArticleMs() int64 {
+	if s.Articles == 0 {
+		return 0
 	}
+	return s.CpuTimeMs / int64(s.Articles)
+}
 
-	vor_schema, err := find_first_schema(path.Join(schema_root, "/dist/model/article-vor.v*.json"))
-	if err != nil {
-		return empty_response, errors.New("failed to find a VOR schema")
+// returns how many articles were validated at once on average, the cpu-time over the wall-time.
+// for example, 12 workers may only achieve a parallelism of 6.7 if they are waiting on files to be read.
+func (s Summary) Parallelism() float64 {
+	if s.WallTimeMs == 0 {
+		return 0
 	}
+	return float64(s.CpuTimeMs) / float64(s.WallTimeMs)
+}
 
-	schema_file_list := map[string]string{
-		"POA": poa_schema,
-		"VOR": vor_schema,
+// "articles:10, failures:0, warnings:0, workers:12, wall-time:680ms, cpu-time:4s, cpu-time-per-article:457ms, parallelism:6.7"
+// "articles:10, failures:0, warnings:0, skipped:2, workers:12, ..." when articles were skipped.
+func (s Summary) String() string {
+	skipped := ""
+	if s.Skipped > 0 {
+		skipped = fmt.Sprintf(", skipped:%d", s.Skipped)
+	}
+	if s.PassedWithIgnored > 0 {
+		skipped += fmt.Sprintf(", passed-with-ignored:%d", s.PassedWithIgnored)
+	}
+	if s.CacheHits > 0 {
+		skipped += fmt.Sprintf(", cache-hits:%d", s.CacheHits)
+	}
+	if s.TooLarge > 0 {
+		skipped += fmt.Sprintf(", too-large:%d", s.TooLarge)
 	}
+	timestamps := ""
+	if !s.StartTime.IsZero() {
+		// ISO-8601 with the UTC offset, for correlating with other logs.
+		timestamps = fmt.Sprintf(", start:%s, end:%s", s.StartTime.Format(time.RFC3339), s.EndTime.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("articles:%d, failures:%d, warnings:%d%s, workers:%d, wall-time:%s, cpu-time:%s, cpu-time-per-article:%dms, parallelism:%.1f%s",
+		s.Articles, s.Failures, s.Warnings, skipped, s.Workers, format_ms(s.WallTimeMs), format_ms(s.CpuTimeMs), s.CpuTimePerArticleMs(), s.Parallelism(), timestamps)
+}
 
-	schema_map := map[string]Schema{}
-	for label, path := range schema_file_list {
-		file_bytes, err := os.ReadFile(path)
-		if err != nil {
-			return empty_response, fmt.Errorf("failed to read %s schema: %w", label, err)
+// returns true if the batch should exit with a failure.
+// warnings are advisory unless `fail_on_warnings` is true.
+func (s Summary) Failed(fail_on_warnings bool) bool {
+	return s.Failures > 0 || (fail_on_warnings && s.Warnings > 0)
+}
+
+// returns the number of valid and invalid articles separated by a space, "8 2", see --count.
+// skipped articles are counted as valid.
+func (s Summary) CountString() string {
+	return fmt.Sprintf("%d %d", s.Articles-s.Failures, s.Failures)
+}
+
+// adds a single `result` to the totals.
+func (s *Summary) add(result Result) {
+	s.Articles++
+	s.CpuTimeMs = s.CpuTimeMs + result.Elapsed
+	s.Warnings = s.Warnings + len(result.Warnings)
+	if result.Skipped {
+		s.Skipped++
+	}
+	if result.Success && result.IgnoredErrors > 0 {
+		s.PassedWithIgnored++
+	}
+	if !result.Success {
+		s.Failures++
+	}
+	if errors.Is(result.Error, ErrFileTooLarge) {
+		s.TooLarge++
+	}
+	if !result.Success && result.Severity != "" {
+		if s.Severity == nil {
+			s.Severity = map[string]int{}
 		}
-		if label == "VOR" {
-			// patch ISBN regex as it can't be compiled in Go.
-			// todo: this needs a fix upstream in api-raml.
-			// - https://json-schema.org/understanding-json-schema/reference/regular_expressions.html
-			// - https://github.com/santhosh-tekuri/jsonschema/issues/113
-			// - https://github.com/elifesciences/api-raml/blob/8e2ffb573b2c3d2e173c38cd8b9625cf2d5740ad/src/misc/isbn.v1.yaml#L6
-			find := "allOf.2.properties.references.items.definitions.book.properties.isbn.pattern"
-			replace := "^.+$"
-			file_bytes, err = sjson.SetBytes(file_bytes, find, replace)
-			if err != nil {
-				return empty_response, fmt.Errorf("failed to patch ISBN in %s schema: %w", label, err)
-			}
+		s.Severity[result.Severity]++
+	}
+}
+
+// "failures by severity: high:3, medium:12, low:0"
+func severity_summary(summary Summary) string {
+	count_list := []string{}
+	for _, severity := range severity_list {
+		count_list = append(count_list, fmt.Sprintf("%s:%d", severity, summary.Severity[severity]))
+	}
+	return "failures by severity: " + strings.Join(count_list, ", ")
+}
+
+func summarise(result_list []Result, num_workers int, start_time time.Time, end_time time.Time) Summary {
+	summary := Summary{
+		Workers:    num_workers,
+		WallTimeMs: end_time.Sub(start_time).Milliseconds(),
+		StartTime:  start_time,
+		EndTime:    end_time,
+	}
+	for _, result := range result_list {
+		summary.add(result)
+	}
+	return summary
+}
+
+// totals for the articles within a single directory, see --group-by-dir.
+type DirSummary struct {
+	Dir       string `json:"dir"`
+	Articles  int    `json:"articles"`
+	Failures  int    `json:"failures"`
+	Warnings  int    `json:"warnings"`
+	AvgTimeMs int64  `json:"avg_time_ms"`
+}
+
+// "path/to/issue-1: articles:10, failures:1, warnings:0, avg-time:457ms"
+func (d DirSummary) String() string {
+	return fmt.Sprintf("%s: articles:%d, failures:%d, warnings:%d, avg-time:%s", d.Dir, d.Articles, d.Failures, d.Warnings, format_ms(d.AvgTimeMs))
+}
+
+// totals the results in `result_list` by the immediate parent directory of each file, sorted by directory.
+// documents within a list of documents are counted in the directory of the file containing them.
+func summarise_by_dir(result_list []Result) []DirSummary {
+	summary_map := map[string]*Summary{}
+	for _, result := range result_list {
+		file_name, _, _ := split_document_index(result.FileName)
+		dir := filepath.Dir(file_name)
+		if summary_map[dir] == nil {
+			summary_map[dir] = &Summary{}
 		}
+		summary_map[dir].add(result)
+	}
+	dir_list := []DirSummary{}
+	for dir, summary := range summary_map {
+		dir_list = append(dir_list, DirSummary{
+			Dir:       dir,
+			Articles:  summary.Articles,
+			Failures:  summary.Failures,
+			Warnings:  summary.Warnings,
+			AvgTimeMs: summary.CpuTimePerArticleMs(),
+		})
+	}
+	sort.Slice(dir_list, func(a, b int) bool {
+		return dir_list[a].Dir < dir_list[b].Dir
+	})
+	return dir_list
+}
 
-		err = compiler.AddResource(label, bytes.NewReader(file_bytes))
-		if err != nil {
-			return empty_response, fmt.Errorf("failed to add %s schema to compiler: %w", label, err)
+// upper bounds (in seconds) of the per-file validation time histogram buckets.
+var metrics_bucket_list = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// writes Prometheus text-format metrics for a batch of results to `w`.
+// - https://prometheus.io/docs/instrumenting/exposition_formats/
+func write_metrics(w io.Writer, summary Summary, result_list []Result) error {
+	type_articles := map[string]int{}
+	type_failures := map[string]int{}
+	bucket_counts := make([]int, len(metrics_bucket_list))
+	var seconds_sum float64
+	for _, result := range result_list {
+		type_articles[result.Type]++
+		if !result.Success {
+			type_failures[result.Type]++
 		}
+		seconds := float64(result.Elapsed) / 1000
+		seconds_sum += seconds
+		for i, upper_bound := range metrics_bucket_list {
+			if seconds <= upper_bound {
+				bucket_counts[i]++
+			}
+		}
+	}
+	type_list := []string{}
+	for type_ := range type_articles {
+		type_list = append(type_list, type_)
+	}
+	slices.Sort(type_list)
 
-		schema, err := compiler.Compile(label)
-		if err != nil {
-			return empty_response, fmt.Errorf("failed to compile %s schema: %w", label, err)
+	buf := strings.Builder{}
+	metric := func(name string, metric_type string, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metric_type)
+	}
+
+	metric("vaj_articles_total", "counter", "Number of article-json files validated.")
+	fmt.Fprintf(&buf, "vaj_articles_total %d\n", summary.Articles)
+	metric("vaj_failures_total", "counter", "Number of article-json files that failed validation.")
+	fmt.Fprintf(&buf, "vaj_failures_total %d\n", summary.Failures)
+	metric("vaj_warnings_total", "counter", "Number of warnings across all article-json files.")
+	fmt.Fprintf(&buf, "vaj_warnings_total %d\n", summary.Warnings)
+	metric("vaj_cache_hits_total", "counter", "Number of article-json files given a cached result rather than being validated, see --result-cache-size.")
+	fmt.Fprintf(&buf, "vaj_cache_hits_total %d\n", summary.CacheHits)
+	metric("vaj_type_articles_total", "counter", "Number of article-json files validated by article type.")
+	for _, type_ := range type_list {
+		fmt.Fprintf(&buf, "vaj_type_articles_total{type=%q} %d\n", type_, type_articles[type_])
+	}
+	metric("vaj_type_failures_total", "counter", "Number of article-json files that failed validation by article type.")
+	for _, type_ := range type_list {
+		fmt.Fprintf(&buf, "vaj_type_failures_total{type=%q} %d\n", type_, type_failures[type_])
+	}
+	metric("vaj_wall_time_seconds", "gauge", "Time taken to validate all article-json files.")
+	fmt.Fprintf(&buf, "vaj_wall_time_seconds %g\n", float64(summary.WallTimeMs)/1000)
+	metric("vaj_validation_seconds", "histogram", "Time taken to validate each article-json file.")
+	for i, upper_bound := range metrics_bucket_list {
+		fmt.Fprintf(&buf, "vaj_validation_seconds_bucket{le=\"%g\"} %d\n", upper_bound, bucket_counts[i])
+	}
+	fmt.Fprintf(&buf, "vaj_validation_seconds_bucket{le=\"+Inf\"} %d\n", len(result_list))
+	fmt.Fprintf(&buf, "vaj_validation_seconds_sum %g\n", seconds_sum)
+	fmt.Fprintf(&buf, "vaj_validation_seconds_count %d\n", len(result_list))
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// writes metrics for a batch of results to the file at `metrics_path`.
+func write_metrics_file(metrics_path string, summary Summary, result_list []Result) error {
+	fh, err := os.Create(metrics_path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return write_metrics(fh, summary, result_list)
+}
+
+// returns the sorted names of the files that failed validation in `result_list`.
+// a file with a list of documents is returned once no matter how many of its documents failed.
+func failed_files(result_list []Result) []string {
+	file_list := []string{}
+	for _, result := range result_list {
+		if result.Success {
+			continue
 		}
+		file, _, _ := split_document_index(result.FileName)
+		if !slices.Contains(file_list, file) {
+			file_list = append(file_list, file)
+		}
+	}
+	slices.Sort(file_list)
+	return file_list
+}
 
-		schema_map[label] = Schema{
-			Label:  label,
-			Path:   path,
-			Schema: schema,
+// writes the path to each file that failed validation to `w`, one per line, see `failed_files`.
+// names relative to `relative_to` are written as paths that can be read from the working directory.
+func write_failures(w io.Writer, result_list []Result, relative_to string) error {
+	buf := bytes.Buffer{}
+	for _, file := range failed_files(result_list) {
+		buf.WriteString(absolute_file_name(relative_to, file) + "\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writes the path to each file that failed validation to `w`, each terminated by a NUL byte, see --print0-failures.
+// names relative to `relative_to` are written as paths that can be read from the working directory.
+func write_failures0(w io.Writer, result_list []Result, relative_to string) error {
+	buf := bytes.Buffer{}
+	for _, file := range failed_files(result_list) {
+		buf.WriteString(absolute_file_name(relative_to, file) + "\x00")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writes the path to each file that failed validation to the file at `failures_path`, see `write_failures`.
+func write_failures_file(failures_path string, result_list []Result, relative_to string) error {
+	fh, err := os.Create(failures_path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return write_failures(fh, result_list, relative_to)
+}
+
+// a result read back from a json report.
+type ReportEntry struct {
+	FileName   string        `json:"file_name"`
+	Success    bool          `json:"success"`
+	ErrorCount int           `json:"error_count"`
+	Errors     []ErrorDetail `json:"errors"`
+}
+
+// returns the number of leaf errors for the entry,
+// counting them in the error tree for reports that predate 'error_count'.
+func (e ReportEntry) error_count() int {
+	if e.ErrorCount > 0 || e.Success {
+		return e.ErrorCount
+	}
+	var count func(detail_list []ErrorDetail) int
+	count = func(detail_list []ErrorDetail) int {
+		total := 0
+		for _, detail := range detail_list {
+			if len(detail.Causes) == 0 {
+				total++
+			} else {
+				total += count(detail.Causes)
+			}
 		}
+		return total
 	}
-	return schema_map, nil
+	return count(e.Errors)
 }
 
-// ---
+// reads the results from the json report at `report_path`.
+func read_json_report(report_path string) ([]ReportEntry, error) {
+	report_bytes, err := os.ReadFile(report_path)
+	if err != nil {
+		return nil, err
+	}
+	report := struct {
+		Results []ReportEntry `json:"results"`
+	}{}
+	err = json.Unmarshal(report_bytes, &report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json report: %w", err)
+	}
+	return report.Results, nil
+}
 
-func read_article_data(article_json_path string) Article {
-	article_json_bytes, err := os.ReadFile(article_json_path)
-	panic_on_err(err, "reading bytes from path: "+article_json_path)
+type ErrorCountChange struct {
+	FileName string `json:"file_name"`
+	Old      int    `json:"old"`
+	New      int    `json:"new"`
+}
 
-	article_status := gjson.GetBytes(article_json_bytes, "article.status") // "poa", "vor"
-	if !article_status.Exists() {
-		panic("'article.status' field in article data not found: " + article_json_path)
+// the differences between two json reports, each list sorted by filename.
+type ReportDiff struct {
+	NewlyPassing      []string           `json:"newly_passing"`
+	NewlyFailing      []string           `json:"newly_failing"`
+	ErrorCountChanged []ErrorCountChange `json:"error_count_changed"`
+	OnlyInOld         []string           `json:"only_in_old"`
+	OnlyInNew         []string           `json:"only_in_new"`
+}
+
+// compares the results of an `old_report` to a `new_report`, matching results by filename.
+func compare_reports(old_report []ReportEntry, new_report []ReportEntry) ReportDiff {
+	diff := ReportDiff{
+		NewlyPassing:      []string{},
+		NewlyFailing:      []string{},
+		ErrorCountChanged: []ErrorCountChange{},
+		OnlyInOld:         []string{},
+		OnlyInNew:         []string{},
+	}
+	old_map := map[string]ReportEntry{}
+	for _, entry := range old_report {
+		old_map[entry.FileName] = entry
+	}
+	new_map := map[string]ReportEntry{}
+	for _, entry := range new_report {
+		new_map[entry.FileName] = entry
+		old_entry, present := old_map[entry.FileName]
+		if !present {
+			diff.OnlyInNew = append(diff.OnlyInNew, entry.FileName)
+			continue
+		}
+		if !old_entry.Success && entry.Success {
+			diff.NewlyPassing = append(diff.NewlyPassing, entry.FileName)
+		} else if old_entry.Success && !entry.Success {
+			diff.NewlyFailing = append(diff.NewlyFailing, entry.FileName)
+		} else if old_entry.error_count() != entry.error_count() {
+			diff.ErrorCountChanged = append(diff.ErrorCountChanged, ErrorCountChange{entry.FileName, old_entry.error_count(), entry.error_count()})
+		}
+	}
+	for _, entry := range old_report {
+		if _, present := new_map[entry.FileName]; !present {
+			diff.OnlyInOld = append(diff.OnlyInOld, entry.FileName)
+		}
 	}
-	schema_key := strings.ToUpper(article_status.String()) // "poa" => "POA"
+	slices.Sort(diff.NewlyPassing)
+	slices.Sort(diff.NewlyFailing)
+	slices.Sort(diff.OnlyInOld)
+	slices.Sort(diff.OnlyInNew)
+	sort.Slice(diff.ErrorCountChanged, func(a, b int) bool {
+		return diff.ErrorCountChanged[a].FileName < diff.ErrorCountChanged[b].FileName
+	})
+	return diff
+}
 
-	// article-json contains 'journal', 'snippet' and 'article' sections.
-	// extract just the 'article' from the article data.
-	result := gjson.GetBytes(article_json_bytes, "article")
-	if !result.Exists() {
-		panic("'article' field in article data not found: " + article_json_path)
+// prints the differences between two reports in `diff` as text.
+func print_report_diff(diff ReportDiff) {
+	print_list := func(label string, file_list []string) {
+		fmt.Printf("%s (%d):\n", label, len(file_list))
+		for _, file := range file_list {
+			fmt.Printf("  %s\n", file)
+		}
 	}
+	print_list("newly passing", diff.NewlyPassing)
+	print_list("newly failing", diff.NewlyFailing)
+	fmt.Printf("error count changed (%d):\n", len(diff.ErrorCountChanged))
+	for _, change := range diff.ErrorCountChanged {
+		fmt.Printf("  %s: %d -> %d\n", change.FileName, change.Old, change.New)
+	}
+	print_list("only in old report", diff.OnlyInOld)
+	print_list("only in new report", diff.OnlyInNew)
+}
 
-	// what is happening here?? the slice of matching bytes are extracted from
-	// the article-json, skipping a conversion of `result` to a string then back
-	// to bytes for unmarshalling. if only a `result.Bytes()` existed :(
-	// - https://github.com/tidwall/gjson#user-content-working-with-bytes
-	var raw []byte
-	if result.Index > 0 {
-		raw = article_json_bytes[result.Index : result.Index+len(result.Raw)]
+// compares the json reports at `old_report_path` and `new_report_path`, printing the differences.
+// exits with a failure if any article is newly failing.
+func do_compare_reports(old_report_path string, new_report_path string, output_format string, pretty bool) {
+	old_report, err := read_json_report(old_report_path)
+	die(err != nil, fmt.Sprintf("failed to read old report: %v", err))
+	new_report, err := read_json_report(new_report_path)
+	die(err != nil, fmt.Sprintf("failed to read new report: %v", err))
+
+	diff := compare_reports(old_report, new_report)
+	if output_format == "json" {
+		err = new_json_encoder(os.Stdout, pretty).Encode(diff)
+		panic_on_err(err, "writing json report diff")
 	} else {
-		raw = []byte(result.Raw)
+		print_report_diff(diff)
 	}
+	if len(diff.NewlyFailing) > 0 {
+		exit(1)
+	}
+}
 
-	// convert the article-json data into a simple go datatype
-	var article interface{}
-	err = json.Unmarshal(raw, &article)
-	panic_on_err(err, "unmarshalling article section bytes")
-
-	return Article{
-		FileName: article_json_path,
-		Data:     article,
-		Type:     schema_key,
+// returns the version of this tool from the Go build info, including the vcs revision when available.
+func tool_version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version = version + " " + setting.Value
+		}
 	}
+	return version
 }
 
-func validate(schema Schema, article interface{}) (time.Duration, error) {
-	start := time.Now()
-	err := schema.Schema.Validate(article)
-	end := time.Now()
-	elapsed := end.Sub(start)
-	return elapsed, err
+// describes a compiled schema in the json report.
+type SchemaMeta struct {
+	Path    string `json:"path"`
+	Version int    `json:"version"`
+	Draft   string `json:"draft"`
+	Sha256  string `json:"sha256"`
+	// see `Schema.AssertFormat` and `Schema.AssertContent`.
+	AssertFormat  bool `json:"assert_format"`
+	AssertContent bool `json:"assert_content"`
+	// the changes made to the schema file before it was compiled.
+	Patches []SchemaPatch `json:"patches"`
 }
 
-func path_exists(path string) bool {
-	_, err := os.Stat(path)
-	return !errors.Is(err, os.ErrNotExist)
+// describes how a json report was generated.
+type Meta struct {
+	Version    string                `json:"version"`
+	SchemaRoot string                `json:"schema_root"`
+	Schemas    map[string]SchemaMeta `json:"schemas"`
+	// see `schema_fingerprint`.
+	SchemaFingerprint string    `json:"schema_fingerprint"`
+	NumWorkers        int       `json:"num_workers"`
+	SampleSize        int       `json:"sample_size"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+	Host              string    `json:"host"`
 }
 
-func path_is_dir(path string) bool {
-	fi, err := os.Lstat(path)
-	panic_on_err(err, "reading path: "+path)
-	return fi.Mode().IsDir()
+func new_meta(schema_root string, schema_map map[string]Schema, num_workers int, sample_size int, start_time time.Time, end_time time.Time) Meta {
+	schemas := map[string]SchemaMeta{}
+	for label, schema := range schema_map {
+		version, _ := parse_schema_version(schema.Path)
+		schemas[label] = SchemaMeta{
+			Path:          schema.Path,
+			Version:       version,
+			Draft:         schema.Schema.Draft.String(),
+			Sha256:        schema.Sha256,
+			AssertFormat:  schema.AssertFormat,
+			AssertContent: schema.AssertContent,
+			Patches:       schema.Patches,
+		}
+	}
+	host, _ := os.Hostname()
+	return Meta{
+		Version:           tool_version(),
+		SchemaRoot:        schema_root,
+		Schemas:           schemas,
+		SchemaFingerprint: schema_fingerprint(schema_map),
+		NumWorkers:        num_workers,
+		SampleSize:        sample_size,
+		StartTime:         start_time,
+		EndTime:           end_time,
+		Host:              host,
+	}
 }
 
-func validate_article(schema_map map[string]Schema, article Article, capture_error bool) Result {
-	// read article data and determine schema to use
-	schema, present := schema_map[article.Type]
-	if !present {
-		panic("schema not found: " + article.Type)
+// the most results inserted into the --sqlite database in a single transaction.
+const sqlite_batch_size = 1000
+
+// starts a single goroutine inserting each result sent to the returned channel into a 'results' table
+// in the SQLite database at `db_path`, creating both as necessary.
+// results are inserted with a prepared statement and committed in batches as soon as no more are waiting,
+// so they're visible as they complete without a transaction per result.
+// the returned function closes the channel and waits for all results to be written.
+func start_sqlite_writer(db_path string, schema_map map[string]Schema, buffer_size int) (chan<- Result, func() error, error) {
+	db, err := sql.Open("sqlite", db_path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the writer goroutine is the only user of the database.
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"CREATE TABLE IF NOT EXISTS results (filename TEXT, type TEXT, elapsed_ms INTEGER, bytes INTEGER, success INTEGER, error_count INTEGER, schema_version INTEGER, timestamp TEXT, hash TEXT)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+	insert_stmt, err := db.Prepare("INSERT INTO results VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	schema_version_map := map[string]int{}
+	for label, schema := range schema_map {
+		schema_version_map[label], _ = parse_schema_version(schema.Path)
+	}
+
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan error, 1)
+	go func() {
+		var write_err error
+		var tx *sql.Tx
+		var tx_stmt *sql.Stmt
+		pending := 0
+		for result := range result_chan {
+			if write_err != nil {
+				// the database has failed, drain the remaining results so workers don't block.
+				continue
+			}
+			if tx == nil {
+				tx, write_err = db.Begin()
+				if write_err != nil {
+					tx = nil
+					continue
+				}
+				tx_stmt = tx.Stmt(insert_stmt)
+			}
+			success := 0
+			if result.Success {
+				success = 1
+			}
+			var hash any
+			if result.Hash != "" {
+				hash = result.Hash
+			}
+			_, write_err = tx_stmt.Exec(result.FileName, result.Type, result.Elapsed, result.Bytes, success,
+				result.ErrorCount, schema_version_map[result.Type], time.Now().UTC().Format(time.RFC3339), hash)
+			pending++
+			if write_err == nil && (len(result_chan) == 0 || pending >= sqlite_batch_size) {
+				write_err = tx.Commit()
+				tx = nil
+				pending = 0
+			}
+		}
+		if tx != nil {
+			if write_err == nil {
+				write_err = tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+		}
+		insert_stmt.Close()
+		err := db.Close()
+		if write_err != nil {
+			err = write_err
+		}
+		done_chan <- err
+	}()
+
+	close_fn := func() error {
+		close(result_chan)
+		return <-done_chan
 	}
+	return result_chan, close_fn, nil
+}
+
+// the columns of the --manifest-file csv.
+var manifest_header = []string{"filename", "type", "success", "error_count", "bytes", "hash"}
+
+// starts a single goroutine writing each result sent to the returned channel as a row of csv to `output_path`,
+// creating or truncating it. the hash column is empty unless --hash is used.
+// the returned function closes the channel, waits for all results to be written and closes the file.
+func start_manifest_writer(output_path string, buffer_size int) (chan<- Result, func() error, error) {
+	fh, err := os.Create(output_path)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := csv.NewWriter(fh)
+	err = w.Write(manifest_header)
+	if err != nil {
+		fh.Close()
+		return nil, nil, err
+	}
+
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan error, 1)
+	go func() {
+		var write_err error
+		for result := range result_chan {
+			if write_err != nil {
+				// the file has failed, drain the remaining results so workers don't block.
+				continue
+			}
+			write_err = w.Write([]string{
+				result.FileName,
+				result.Type,
+				strconv.FormatBool(result.Success),
+				strconv.Itoa(result.ErrorCount),
+				strconv.Itoa(result.Bytes),
+				result.Hash,
+			})
+		}
+		w.Flush()
+		if write_err == nil {
+			write_err = w.Error()
+		}
+		err := fh.Close()
+		if write_err != nil {
+			err = write_err
+		}
+		done_chan <- err
+	}()
+
+	close_fn := func() error {
+		close(result_chan)
+		return <-done_chan
+	}
+	return result_chan, close_fn, nil
+}
+
+// how long to wait before connecting to the --result-socket again after failing to.
+const result_socket_retry = time.Second
+
+// starts a single goroutine writing each result sent to the returned channel as a line of json
+// to whatever is listening on the Unix domain socket at `socket_path`.
+// nothing listening is not an error, results are dropped until a connection can be made.
+// a connection is attempted no more than once every `result_socket_retry` and again if the listener goes away.
+// the returned function closes the channel, waits for all results to be written and
+// returns an error if any results were dropped.
+func start_socket_writer(socket_path string, buffer_size int) (chan<- Result, func() error) {
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan error, 1)
+	go func() {
+		var conn net.Conn
+		var encoder *json.Encoder
+		var last_dial time.Time
+		dropped := 0
+		for result := range result_chan {
+			if conn == nil && time.Since(last_dial) >= result_socket_retry {
+				last_dial = time.Now()
+				c, err := net.DialTimeout("unix", socket_path, result_socket_retry)
+				if err == nil {
+					conn = c
+					encoder = json.NewEncoder(conn)
+				}
+			}
+			if conn == nil {
+				dropped++
+				continue
+			}
+			err := encoder.Encode(result)
+			if err != nil {
+				// the listener went away.
+				conn.Close()
+				conn = nil
+				dropped++
+			}
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		if dropped > 0 {
+			done_chan <- fmt.Errorf("%d results were not written, nothing was listening", dropped)
+			return
+		}
+		done_chan <- nil
+	}()
+
+	close_fn := func() error {
+		close(result_chan)
+		return <-done_chan
+	}
+	return result_chan, close_fn
+}
+
+// how often --progress writes the totals so far.
+const progress_interval = 5 * time.Second
+
+// starts a single goroutine totalling each result sent to the returned channel and writing the totals so far to `w`
+// no more than once every `interval`, "progress: articles:1200, failures:3, 251.2/s".
+// the returned function closes the channel and writes the final totals.
+func start_progress_writer(w io.Writer, interval time.Duration, buffer_size int) (chan<- Result, func() error) {
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan error, 1)
+	go func() {
+		start_time := time.Now()
+		last_write := start_time
+		summary := Summary{}
+		write := func() error {
+			rate := float64(summary.Articles) / time.Since(start_time).Seconds()
+			_, err := fmt.Fprintf(w, "progress: articles:%d, failures:%d, %.1f/s\n", summary.Articles, summary.Failures, rate)
+			return err
+		}
+		for result := range result_chan {
+			summary.add(result)
+			if time.Since(last_write) >= interval {
+				last_write = time.Now()
+				write()
+			}
+		}
+		done_chan <- write()
+	}()
+	close_fn := func() error {
+		close(result_chan)
+		return <-done_chan
+	}
+	return result_chan, close_fn
+}
+
+// starts a single goroutine collecting each result sent to the returned channel for a report that can only be
+// written once every result is in, like --output json.
+// the returned function closes the channel and calls `write` with the results in the order they were sent.
+func start_report_writer(write func(result_list []Result) error, buffer_size int) (chan<- Result, func() error) {
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan []Result, 1)
+	go func() {
+		result_list := []Result{}
+		for result := range result_chan {
+			result_list = append(result_list, result)
+		}
+		done_chan <- result_list
+	}()
+	close_fn := func() error {
+		close(result_chan)
+		return write(<-done_chan)
+	}
+	return result_chan, close_fn
+}
+
+// the destinations each result is sent to as it's validated, like --sqlite, --result-socket, --progress and the json report.
+// every sink is sent every result, see `fan_out_results`.
+type result_sinks struct {
+	chan_list  []chan<- Result
+	close_list []func()
+	// closes the fan-out, set once there is more than one sink.
+	close_fan_out func()
+}
+
+// adds the sink `result_chan`. `close_fn` is called to close it once validation is done and
+// must wait for any results still being written.
+func (s *result_sinks) add(result_chan chan<- Result, close_fn func()) {
+	s.chan_list = append(s.chan_list, result_chan)
+	s.close_list = append(s.close_list, close_fn)
+}
+
+// returns the channel to send each result to, a fan-out when there is more than one sink.
+// nil when there are no sinks.
+func (s *result_sinks) start(buffer_size int) chan<- Result {
+	switch len(s.chan_list) {
+	case 0:
+		return nil
+	case 1:
+		return s.chan_list[0]
+	}
+	var result_chan chan<- Result
+	result_chan, s.close_fan_out = fan_out_results(s.chan_list, buffer_size)
+	return result_chan
+}
+
+// stops sending results to the sinks and closes each one in the order they were added.
+func (s *result_sinks) close() {
+	if s.close_fan_out != nil {
+		s.close_fan_out()
+		s.close_fan_out = nil
+	}
+	for _, close_fn := range s.close_list {
+		close_fn()
+	}
+	s.close_list = nil
+}
+
+// starts a single goroutine sending each result sent to the returned channel to each channel in `chan_list`.
+// the returned function closes the channel and waits for all results to be sent.
+// the channels in `chan_list` are not closed.
+func fan_out_results(chan_list []chan<- Result, buffer_size int) (chan<- Result, func()) {
+	result_chan := make(chan Result, buffer_size)
+	done_chan := make(chan bool)
+	go func() {
+		for result := range result_chan {
+			for _, c := range chan_list {
+				c <- result
+			}
+		}
+		close(done_chan)
+	}()
+	return result_chan, func() {
+		close(result_chan)
+		<-done_chan
+	}
+}
+
+// returns true if `path` is an S3 url, "s3://bucket/key".
+func is_s3_url(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+// returns the bucket and key of the S3 `url`, "s3://bucket/key".
+func parse_s3_url(url string) (string, string) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(url, "s3://"), "/")
+	return bucket, key
+}
+
+// the S3 client shared by every S3 read, see `get_s3_client`.
+var s3_client_cache *s3.Client
+var s3_client_mu sync.Mutex
+
+// returns a client for S3 with credentials and region from the standard AWS chain, creating it on first use.
+// a custom endpoint, AWS_ENDPOINT_URL, is addressed path-style as S3 compatible stores like MinIO expect.
+func get_s3_client() (*s3.Client, error) {
+	s3_client_mu.Lock()
+	defer s3_client_mu.Unlock()
+	if s3_client_cache != nil {
+		return s3_client_cache, nil
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	s3_client_cache = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = o.BaseEndpoint != nil
+	})
+	return s3_client_cache, nil
+}
+
+// returns the names of the objects under the S3 `prefix`, "s3://bucket/prefix/", relative to it.
+// "sub-prefixes" aren't descended into, like sub-directories of a directory.
+func list_s3_objects(prefix string) ([]string, error) {
+	bucket, key_prefix := parse_s3_url(prefix)
+	return list_s3_keys(&s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(key_prefix), Delimiter: aws.String("/")})
+}
+
+// same as `list_s3_objects` but the objects under every sub-prefix are included, "sub/elife-09560-v1.xml.json".
+func list_s3_tree(prefix string) ([]string, error) {
+	bucket, key_prefix := parse_s3_url(prefix)
+	return list_s3_keys(&s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(key_prefix)})
+}
+
+// returns the names of the objects in every page of the listing `input`, relative to its prefix.
+func list_s3_keys(input *s3.ListObjectsV2Input) ([]string, error) {
+	client, err := get_s3_client()
+	if err != nil {
+		return nil, err
+	}
+	key_prefix := aws.ToString(input.Prefix)
+	name_list := []string{}
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(object.Key), key_prefix)
+			// the prefix itself and "directory" placeholders aren't objects to read.
+			if name != "" && !strings.HasSuffix(name, "/") {
+				name_list = append(name_list, name)
+			}
+		}
+	}
+	return name_list, nil
+}
+
+// returns the contents of the S3 object at `url`, "s3://bucket/key".
+func read_s3_object(url string) ([]byte, error) {
+	client, err := get_s3_client()
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := parse_s3_url(url)
+	out, err := client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 object: %w", err)
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 object: %w", err)
+	}
+	return body, nil
+}
+
+// copies the api-raml at the S3 `url`, a prefix or a zip archive, to a temporary directory that is removed on exit.
+// returns the path to the local copy.
+func fetch_s3_schema_root(url string) (string, error) {
+	tmp_dir, err := os.MkdirTemp("", "vaj-schema-root")
+	if err != nil {
+		return "", err
+	}
+	add_exit_hook(func() {
+		os.RemoveAll(tmp_dir)
+	})
+	if !strings.HasSuffix(url, "/") {
+		local_path := filepath.Join(tmp_dir, filepath.Base(url))
+		body, err := read_s3_object(url)
+		if err != nil {
+			return "", fmt.Errorf("failed to copy schema root from S3: %w", err)
+		}
+		return local_path, os.WriteFile(local_path, body, 0644)
+	}
+	name_list, err := list_s3_tree(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy schema root from S3: %w", err)
+	}
+	for _, name := range name_list {
+		// a key like "../x" would be written outside of the copy.
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("failed to copy schema root from S3: unsafe object name: %s", name)
+		}
+		body, err := read_s3_object(url + name)
+		if err != nil {
+			return "", fmt.Errorf("failed to copy schema root from S3: %w", err)
+		}
+		local_path := filepath.Join(tmp_dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(local_path), 0755)
+		if err == nil {
+			err = os.WriteFile(local_path, body, 0644)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return tmp_dir, nil
+}
+
+// returns the contents of the local file or S3 object at `file_path`.
+func read_file(file_path string) ([]byte, error) {
+	if is_s3_url(file_path) {
+		return read_s3_object(file_path)
+	}
+	return os.ReadFile(file_path)
+}
+
+// the output of `--output json`.
+type Report struct {
+	Meta    Meta     `json:"meta"`
+	Summary Summary  `json:"summary"`
+	Results []Result `json:"results"`
+	// totals by directory, see --group-by-dir.
+	Dirs []DirSummary `json:"dirs,omitempty"`
+}
+
+// returns a json encoder writing to `w`.
+// output is compact unless `pretty` is true, when it's indented for reading.
+func new_json_encoder(w io.Writer, pretty bool) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
+
+// writes `result_list` and its `summary` as a single json document to stdout, with any `dir_list` totals.
+// results are sorted by filename so reports are stable between runs.
+func write_json_report(w io.Writer, meta Meta, summary Summary, result_list []Result, dir_list []DirSummary, pretty bool) error {
+	result_list = slices.Clone(result_list)
+	sort.Slice(result_list, func(a, b int) bool {
+		return result_list[a].FileName < result_list[b].FileName
+	})
+	return new_json_encoder(w, pretty).Encode(Report{Meta: meta, Summary: summary, Results: result_list, Dirs: dir_list})
+}
+
+type Article struct {
+	Type     string // POA or VOR
+	FileName string
+	Data     interface{} // unmarshalled json data
+	// set when the article couldn't be prepared for validation.
+	// the article is not validated and fails with this error instead.
+	Error error
+	// set when the article should not be validated and not fail either.
+	Skipped bool
+	// size of the article-json file on disk.
+	Bytes int
+	// hex digest of the article-json file on disk, see --hash.
+	Hash string
+	// advisory problems found while reading the article, added to its `Result`.
+	Warnings []string
+	// the 'article' section of the article-json, `Data` before it was unmarshalled.
+	// common fields are read from here on demand rather than walking `Data`, see `Article.ID`.
+	raw []byte
+	// sha256 of the article-json, set when results are cached, see `result_cache`.
+	cache_key string
+}
+
+// returns the 'id' of the article, "09560", or an empty string.
+func (a Article) ID() string {
+	return gjson.GetBytes(a.raw, "id").String()
+}
+
+// returns the 'version' of the article, or 0.
+func (a Article) Version() int {
+	return int(gjson.GetBytes(a.raw, "version").Int())
+}
+
+// returns the 'status' of the article as it's written, "vor", or an empty string.
+func (a Article) Status() string {
+	return gjson.GetBytes(a.raw, "status").String()
+}
+
+// returns the 'title' of the article, or an empty string.
+func (a Article) Title() string {
+	return gjson.GetBytes(a.raw, "title").String()
+}
+
+// SchemaInfo describes the schema for an article type found in the api-raml, without compiling it.
+type SchemaInfo struct {
+	Label string // POA or VOR
+	// path to the latest version of the schema, the one that would be compiled.
+	Path    string
+	Version int
+	// every version of the schema available, lowest first.
+	Versions []int
+}
+
+// finds the latest POA and VOR schemas within `fsys`, see `select_schema`.
+// paths are relative to `fsys`.
+func discover_schemas(fsys fs.FS) (map[string]SchemaInfo, error) {
+	info_map := map[string]SchemaInfo{}
+	for _, label := range []string{"POA", "VOR"} {
+		selection, err := select_schema(fsys, schema_pattern_map[label])
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a %s schema: %w", label, err)
+		}
+		info := SchemaInfo{Label: label, Path: selection.Selected, Versions: []int{}}
+		for _, candidate := range selection.Candidates {
+			if candidate.Path == selection.Selected {
+				info.Version = candidate.Version
+			}
+			if candidate.Version > 0 {
+				info.Versions = append(info.Versions, candidate.Version)
+			}
+		}
+		slices.Sort(info.Versions)
+		info_map[label] = info
+	}
+	return info_map, nil
+}
+
+// DiscoverSchemas finds the latest POA and VOR schemas in the api-raml `schema_root`, a directory or a zip archive of it,
+// and the versions available without compiling them. this is much cheaper than `NewValidator`.
+// returns a map of labels => schema info.
+func DiscoverSchemas(schema_root string) (map[string]SchemaInfo, error) {
+	fsys, close_schema_root, err := open_schema_root(schema_root)
+	if err != nil {
+		return nil, err
+	}
+	defer close_schema_root()
+	info_map, err := discover_schemas(fsys)
+	if err != nil {
+		return nil, err
+	}
+	for label, info := range info_map {
+		info.Path = filepath.Join(schema_root, info.Path)
+		info_map[label] = info
+	}
+	return info_map, nil
+}
+
+// paths to the api-raml's RAML, the compiled one is preferred, see `resolve_raml_schemas`.
+var raml_path_list = []string{"dist/api.raml", "src/api.raml"}
+
+// matches an article media type declared in the RAML, "application/vnd.elife.article-vor+json; version=7:"
+var raml_media_type_regex = regexp.MustCompile(`^(\s*)application/vnd\.elife\.article-(poa|vor)\+json;\s*version=(\d+)\s*:`)
+
+// matches the schema included for a media type, "type: !include model/article-vor.v7.json".
+// examples are included too but with an 'example' key.
+var raml_include_regex = regexp.MustCompile(`^\s*(?:type|schema)\s*:\s*!include\s+(\S+\.(?:json|yaml))\s*$`)
+
+// returns the path within the api-raml of a schema `include`d by the RAML at `raml_path`.
+// includes are relative to the RAML file. api-raml's own tooling compiles the yaml schemas
+// in 'src/model' to json in 'dist/model', so a yaml include resolves to its compiled json.
+func resolve_raml_include(raml_path string, include string) string {
+	include_path := path.Join(path.Dir(raml_path), include)
+	if strings.HasSuffix(include_path, ".yaml") {
+		name := strings.TrimSuffix(path.Base(include_path), ".yaml") + ".json"
+		return path.Join("dist", "model", name)
+	}
+	return include_path
+}
+
+// finds the POA and VOR schemas within `fsys` the way the api-raml does, from the article media types
+// declared in its RAML rather than by globbing its 'dist/model' directory, see `discover_schemas`.
+// the schema for the highest version declared is selected. paths are relative to `fsys`.
+func resolve_raml_schemas(fsys fs.FS) (map[string]SchemaInfo, error) {
+	var raml_path string
+	var raml_bytes []byte
+	for _, candidate := range raml_path_list {
+		file_bytes, err := fs.ReadFile(fsys, candidate)
+		if err == nil {
+			raml_path, raml_bytes = candidate, file_bytes
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read RAML: %w", err)
+		}
+	}
+	if raml_path == "" {
+		return nil, fmt.Errorf("no RAML found, looked for: %s", strings.Join(raml_path_list, ", "))
+	}
+
+	// label => version => schema path
+	declared_map := map[string]map[int]string{}
+	label, version, indent := "", 0, ""
+	for _, line := range strings.Split(string(raml_bytes), "\n") {
+		if match := raml_media_type_regex.FindStringSubmatch(line); match != nil {
+			indent, label = match[1], strings.ToUpper(match[2])
+			version, _ = strconv.Atoi(match[3])
+			continue
+		}
+		if label == "" || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, indent+" ") {
+			// dedented past the media type, its body ended without including a schema.
+			label = ""
+			continue
+		}
+		if match := raml_include_regex.FindStringSubmatch(line); match != nil {
+			if declared_map[label] == nil {
+				declared_map[label] = map[int]string{}
+			}
+			declared_map[label][version] = resolve_raml_include(raml_path, match[1])
+			label = ""
+		}
+	}
+
+	info_map := map[string]SchemaInfo{}
+	for _, label := range []string{"POA", "VOR"} {
+		version_map := declared_map[label]
+		if len(version_map) == 0 {
+			return nil, fmt.Errorf("failed to find a %s schema: no article-%s media type with a schema declared in %s", label, strings.ToLower(label), raml_path)
+		}
+		info := SchemaInfo{Label: label, Versions: []int{}}
+		for version := range version_map {
+			info.Versions = append(info.Versions, version)
+		}
+		slices.Sort(info.Versions)
+		info.Version = info.Versions[len(info.Versions)-1]
+		info.Path = version_map[info.Version]
+		if _, err := fs.Stat(fsys, info.Path); err != nil {
+			return nil, fmt.Errorf("failed to find a %s schema: %s declares %s: %w", label, raml_path, info.Path, err)
+		}
+		info_map[label] = info
+	}
+	return info_map, nil
+}
+
+// ResolveRamlSchemas finds the POA and VOR schemas in the api-raml `schema_root` from the media types declared
+// in its RAML, as the api-raml itself does, rather than the latest schema files found, see `DiscoverSchemas`.
+// returns a map of labels => schema info.
+func ResolveRamlSchemas(schema_root string) (map[string]SchemaInfo, error) {
+	fsys, close_schema_root, err := open_schema_root(schema_root)
+	if err != nil {
+		return nil, err
+	}
+	defer close_schema_root()
+	info_map, err := resolve_raml_schemas(fsys)
+	if err != nil {
+		return nil, err
+	}
+	for label, info := range info_map {
+		info.Path = filepath.Join(schema_root, info.Path)
+		info_map[label] = info
+	}
+	return info_map, nil
+}
+
+// a schema file matched by a glob pattern, see `select_schema`.
+type SchemaCandidate struct {
+	Path string `json:"path"`
+	// version parsed from the filename, 0 if it couldn't be parsed.
+	Version int `json:"version"`
+}
+
+// how a schema was selected from the files matching a glob pattern, see --explain-schema-selection.
+type SchemaSelection struct {
+	Label      string            `json:"label,omitempty"`
+	Pattern    string            `json:"pattern"`
+	Candidates []SchemaCandidate `json:"candidates"`
+	Selected   string            `json:"selected"`
+	Reason     string            `json:"reason"`
+}
+
+// given a globbed path `pattern` within `fsys`, selects the latest version of any matches.
+// for example, if `path/to/vor.v*.json` matches a `vor.v9.json` and `vor.v10.json`,
+// then `path/to/vor.v10.json` will be selected.
+// the matches are sorted by the version parsed from their filename and the last one is selected.
+// matches with the same version, or without one, are sorted by filename.
+func select_schema(fsys fs.FS, pattern string) (SchemaSelection, error) {
+	selection := SchemaSelection{Pattern: pattern, Candidates: []SchemaCandidate{}}
+	path_list, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return selection, fmt.Errorf("bad schema pattern: %w", err)
+	}
+	if len(path_list) == 0 {
+		return selection, fmt.Errorf("no schema found matching: %s", pattern)
+	}
+	for _, path := range path_list {
+		version, _ := parse_schema_version(path)
+		selection.Candidates = append(selection.Candidates, SchemaCandidate{Path: path, Version: version})
+	}
+	// sorts ASC, lowest version to highest version
+	sort.Slice(selection.Candidates, func(a, b int) bool {
+		candidate_a, candidate_b := selection.Candidates[a], selection.Candidates[b]
+		if candidate_a.Version != candidate_b.Version {
+			return candidate_a.Version < candidate_b.Version
+		}
+		return candidate_a.Path < candidate_b.Path
+	})
+	highest := selection.Candidates[len(selection.Candidates)-1]
+	selection.Selected = highest.Path // use highest version available
+
+	tied_list := []string{}
+	for _, candidate := range selection.Candidates {
+		if candidate.Version == highest.Version {
+			tied_list = append(tied_list, candidate.Path)
+		}
+	}
+
+	switch {
+	case len(path_list) == 1:
+		selection.Reason = "only match"
+	case highest.Version == 0:
+		selection.Reason = fmt.Sprintf("last of %d matches sorted by filename, no versions could be parsed", len(path_list))
+	case len(tied_list) > 1:
+		selection.Reason = fmt.Sprintf("highest version of %d matches, version %d is shared by: %s, the last sorted by filename is selected",
+			len(path_list), highest.Version, strings.Join(tied_list, ", "))
+	default:
+		selection.Reason = fmt.Sprintf("highest version of %d matches", len(path_list))
+	}
+	return selection, nil
+}
+
+// returns the path to the highest version of the `label` schema in `fsys` that is lower than the version
+// `select_schema` selects, "article-vor.v7.json" => "article-vor.v6.json".
+// schemas without a version in their filename are never previous to anything.
+func previous_schema(fsys fs.FS, label string) (string, error) {
+	selection, err := select_schema(fsys, schema_pattern_map[label])
+	if err != nil {
+		return "", fmt.Errorf("failed to find a %s schema: %w", label, err)
+	}
+	latest, _ := parse_schema_version(selection.Selected)
+	previous := SchemaCandidate{}
+	for _, candidate := range selection.Candidates {
+		if candidate.Version < latest && candidate.Version > previous.Version {
+			previous = candidate
+		}
+	}
+	if previous.Path == "" {
+		return "", fmt.Errorf("no version of the %s schema found lower than %s", label, selection.Selected)
+	}
+	return previous.Path, nil
+}
+
+// glob patterns of the schemas to validate each article type with, see `configure_validator`.
+var schema_pattern_map = map[string]string{
+	"POA": "dist/model/article-poa.v*.json",
+	"VOR": "dist/model/article-vor.v*.json",
+}
+
+// returns how the POA and VOR schemas are selected from `schema_root`, a directory or zip archive of the api-raml.
+func explain_schema_selection(schema_root string) ([]SchemaSelection, error) {
+	fsys, close_schema_root, err := open_schema_root(schema_root)
+	if err != nil {
+		return nil, err
+	}
+	defer close_schema_root()
+	selection_list := []SchemaSelection{}
+	for _, label := range []string{"POA", "VOR"} {
+		selection, err := select_schema(fsys, schema_pattern_map[label])
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a %s schema: %w", label, err)
+		}
+		selection.Label = label
+		selection_list = append(selection_list, selection)
+	}
+	return selection_list, nil
+}
+
+// prints each schema selection in `selection_list` as text.
+func print_schema_selection(selection_list []SchemaSelection) {
+	for _, selection := range selection_list {
+		fmt.Printf("%s: %s\n", selection.Label, selection.Pattern)
+		for _, candidate := range selection.Candidates {
+			version := "unknown"
+			if candidate.Version > 0 {
+				version = strconv.Itoa(candidate.Version)
+			}
+			marker := ""
+			if candidate.Path == selection.Selected {
+				marker = " (selected)"
+			}
+			fmt.Printf("  %s, version %s%s\n", candidate.Path, version, marker)
+		}
+		fmt.Printf("  reason: %s\n", selection.Reason)
+	}
+}
+
+// matches the version in a schema filename, "article-vor.v7.json" => "7"
+var schema_version_regex = regexp.MustCompile(`\.v(\d+)\.json$`)
+
+// returns the version of the schema at `schema_path` parsed from its filename.
+// the json pointer to a definition, "path/to/article-vor.v7.json#/definitions/book", is ignored.
+func parse_schema_version(schema_path string) (int, bool) {
+	schema_path, _, _ = strings.Cut(schema_path, "#")
+	match := schema_version_regex.FindStringSubmatch(schema_path)
+	if match == nil {
+		return 0, false
+	}
+	version, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// schemas loaded from a zip archive are added to the compiler with this prefix,
+// so relative `$ref`s resolve to other files within the archive.
+const zip_url_prefix = "zip:///"
+
+// returns the api-raml `schema_root` as a filesystem, whether it's a directory or a zip archive,
+// and a function to close it with.
+func open_schema_root(schema_root string) (fs.FS, func() error, error) {
+	if !is_zip_schema_root(schema_root) {
+		return os.DirFS(schema_root), func() error { return nil }, nil
+	}
+	archive, err := zip.OpenReader(schema_root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open schema zip archive: %w", err)
+	}
+	fsys, err := zip_schema_root(&archive.Reader)
+	if err != nil {
+		archive.Close()
+		return nil, nil, err
+	}
+	return fsys, archive.Close, nil
+}
+
+// returns true if the api-raml `schema_root` is a zip archive rather than a directory.
+func is_zip_schema_root(schema_root string) bool {
+	return strings.HasSuffix(strings.ToLower(schema_root), ".zip") && !path_is_dir(schema_root)
+}
+
+// returns the contents of the zip `archive`, descending into the archive's single top-level directory if
+// the 'dist' directory isn't found at the root. Github archives are zipped as 'api-raml-master/dist/...'.
+func zip_schema_root(archive *zip.Reader) (fs.FS, error) {
+	if _, err := fs.Stat(archive, "dist"); err == nil {
+		return archive, nil
+	}
+	entry_list, err := fs.ReadDir(archive, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entry_list) == 1 && entry_list[0].IsDir() {
+		return fs.Sub(archive, entry_list[0].Name())
+	}
+	return nil, errors.New("'dist' directory not found in zip archive")
+}
+
+// returns a jsonschema url loader that reads `zip_url_prefix` urls from `fsys`,
+// deferring to the default loader for everything else.
+func zip_loader(fsys fs.FS) func(string) (io.ReadCloser, error) {
+	return func(url string) (io.ReadCloser, error) {
+		if !strings.HasPrefix(url, zip_url_prefix) {
+			return jsonschema.LoadURL(url)
+		}
+		return fsys.Open(strings.TrimPrefix(url, zip_url_prefix))
+	}
+}
+
+// returns a jsonschema url loader that reads urls starting with a prefix in `ref_map` from the local directory
+// it is mapped to, deferring to `next` for everything else.
+// when more than one prefix matches the longest prefix is used.
+// "https://api.elifesciences.org/schemas/common.v1.json" => "/path/to/schemas/common.v1.json"
+func ref_map_loader(ref_map map[string]string, next func(string) (io.ReadCloser, error)) func(string) (io.ReadCloser, error) {
+	return func(url string) (io.ReadCloser, error) {
+		match := ""
+		for prefix := range ref_map {
+			if strings.HasPrefix(url, prefix) && len(prefix) > len(match) {
+				match = prefix
+			}
+		}
+		if match == "" {
+			return next(url)
+		}
+		// any fragment is resolved by the compiler after loading.
+		rel_path, _, _ := strings.Cut(strings.TrimPrefix(url, match), "#")
+		return os.Open(filepath.Join(ref_map[match], filepath.FromSlash(rel_path)))
+	}
+}
+
+// a repeatable flag of 'prefix=localdir' pairs.
+type ref_map_flag map[string]string
+
+func (f ref_map_flag) String() string {
+	pair_list := []string{}
+	for prefix, local_dir := range f {
+		pair_list = append(pair_list, prefix+"="+local_dir)
+	}
+	slices.Sort(pair_list)
+	return strings.Join(pair_list, ",")
+}
+
+func (f ref_map_flag) Set(val string) error {
+	prefix, local_dir, found := strings.Cut(val, "=")
+	if !found || prefix == "" || local_dir == "" {
+		return fmt.Errorf("expected 'prefix=localdir', got: %s", val)
+	}
+	f[prefix] = local_dir
+	return nil
+}
+
+// a repeatable flag of values, "--ignore-keyword required --ignore-keyword format".
+type string_list_flag []string
+
+func (f *string_list_flag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *string_list_flag) Set(val string) error {
+	if val == "" {
+		return errors.New("expected a value")
+	}
+	*f = append(*f, val)
+	return nil
+}
+
+// escapes a json object key for use in a json pointer.
+// - https://datatracker.ietf.org/doc/html/rfc6901#section-3
+func escape_json_pointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// returns the json pointer to the definition `name` within the schema `schema_bytes`,
+// looking in both 'definitions' and '$defs' at any depth.
+// when a definition is found at more than one location the shallowest is returned.
+func find_definition(schema_bytes []byte, name string) (string, error) {
+	var schema interface{}
+	err := json.Unmarshal(schema_bytes, &schema)
+	if err != nil {
+		return "", err
+	}
+	pointer_list := []string{}
+	var walk func(val interface{}, pointer string)
+	walk = func(val interface{}, pointer string) {
+		switch val := val.(type) {
+		case map[string]interface{}:
+			for key, child := range val {
+				child_pointer := pointer + "/" + escape_json_pointer(key)
+				if key == "definitions" || key == "$defs" {
+					if definition_map, ok := child.(map[string]interface{}); ok {
+						if _, present := definition_map[name]; present {
+							pointer_list = append(pointer_list, child_pointer+"/"+escape_json_pointer(name))
+						}
+					}
+				}
+				walk(child, child_pointer)
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(child, fmt.Sprintf("%s/%d", pointer, i))
+			}
+		}
+	}
+	walk(schema, "")
+	if len(pointer_list) == 0 {
+		return "", fmt.Errorf("definition not found: %s", name)
+	}
+	sort.Slice(pointer_list, func(a, b int) bool {
+		depth_a, depth_b := strings.Count(pointer_list[a], "/"), strings.Count(pointer_list[b], "/")
+		if depth_a != depth_b {
+			return depth_a < depth_b
+		}
+		return pointer_list[a] < pointer_list[b]
+	})
+	return pointer_list[0], nil
+}
+
+// settings for finding and compiling schemas.
+type SchemaOptions struct {
+	// when set, articles are validated against this named definition within the schema instead of the whole schema.
+	Definition string
+	// schema urls starting with a prefix are loaded from the local directory it is mapped to.
+	RefMap map[string]string
+	// the draft of schemas without a '$schema', Draft 4 when nil.
+	// newer drafts are needed for keywords like '$defs', '$dynamicRef' and '$dynamicAnchor'.
+	Draft *jsonschema.Draft
+	// when set, this json schema is added to the 'allOf' of the POA and VOR schemas, see `apply_schema_overlay`.
+	Overlay []byte
+	// when true, the POA and VOR schemas are compiled as `Draft` even if they have their own '$schema'.
+	// schemas they refer to keep theirs.
+	ForceDraft bool
+	// when true, schemas are selected from the media types declared in the api-raml's RAML, see `resolve_raml_schemas`.
+	Raml bool
+	// when true, 'format' is validated by drafts 2019-09 and later, see `asserts_format`.
+	AssertFormat bool
+	// when true, 'contentEncoding' and 'contentMediaType' are validated by drafts 2019-09 and later.
+	AssertContent bool
+	// when true, the second highest version of the POA and VOR schemas is compiled instead of the highest,
+	// see `previous_schema`.
+	Previous bool
+	// when set, the time spent finding and compiling the schemas is added to it.
+	Timer *phase_timer
+}
+
+// adds the json schema `overlay_bytes` to the top-level 'allOf' of the schema `schema_bytes`,
+// creating the 'allOf' if it doesn't exist. an article must then be valid against both,
+// so an overlay can only add constraints, never remove them.
+// refs within the overlay, "#/definitions/book", resolve against the schema it's added to.
+func apply_schema_overlay(schema_bytes []byte, overlay_bytes []byte) ([]byte, error) {
+	if !gjson.ValidBytes(overlay_bytes) || !gjson.ParseBytes(overlay_bytes).IsObject() {
+		return nil, errors.New("schema overlay must be a json object")
+	}
+	all_of := gjson.GetBytes(schema_bytes, "allOf")
+	if all_of.Exists() && !all_of.IsArray() {
+		return nil, errors.New("schema 'allOf' is not a list")
+	}
+	return sjson.SetRawBytes(schema_bytes, "allOf.-1", overlay_bytes)
+}
+
+// supported --draft values, oldest first.
+var draft_name_list = []string{"4", "6", "7", "2019-09", "2020-12"}
+
+// supported --draft values.
+var draft_map = map[string]*jsonschema.Draft{
+	"4":       jsonschema.Draft4,
+	"6":       jsonschema.Draft6,
+	"7":       jsonschema.Draft7,
+	"2019-09": jsonschema.Draft2019,
+	"2020-12": jsonschema.Draft2020,
+}
+
+// returns the --draft name of `draft`, "2019-09", or its own name if it isn't supported.
+func draft_name(draft *jsonschema.Draft) string {
+	for _, name := range draft_name_list {
+		if draft_map[name] == draft {
+			return name
+		}
+	}
+	return draft.String()
+}
+
+// how a schema was compiled, see --print-schema-info.
+type CompiledSchemaInfo struct {
+	Label string `json:"label"`
+	Path  string `json:"path"`
+	// the '$schema' declared in the schema file, empty if it has none.
+	Declared string `json:"declared"`
+	// the draft the schema was actually compiled as, "4".
+	Draft         string        `json:"draft"`
+	Patches       []SchemaPatch `json:"patches"`
+	AssertFormat  bool          `json:"assert_format"`
+	AssertContent bool          `json:"assert_content"`
+}
+
+// returns how each schema in `schema_map` was compiled, ordered by label.
+func compiled_schema_info(schema_map map[string]Schema) []CompiledSchemaInfo {
+	label_list := []string{}
+	for label := range schema_map {
+		label_list = append(label_list, label)
+	}
+	slices.Sort(label_list)
+	info_list := []CompiledSchemaInfo{}
+	for _, label := range label_list {
+		schema := schema_map[label]
+		info_list = append(info_list, CompiledSchemaInfo{
+			Label:         label,
+			Path:          schema.Path,
+			Declared:      schema.Declared,
+			Draft:         draft_name(schema.Schema.Draft),
+			Patches:       schema.Patches,
+			AssertFormat:  schema.AssertFormat,
+			AssertContent: schema.AssertContent,
+		})
+	}
+	return info_list
+}
+
+// "on" or "off".
+func on_off(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// prints each schema in `info_list` as text.
+func print_compiled_schema_info(info_list []CompiledSchemaInfo) {
+	for _, info := range info_list {
+		declared := info.Declared
+		if declared == "" {
+			declared = "none"
+		}
+		fmt.Printf("%s: %s\n", info.Label, info.Path)
+		fmt.Printf("  $schema: %s\n", declared)
+		fmt.Printf("  draft: %s\n", info.Draft)
+		if len(info.Patches) == 0 {
+			fmt.Printf("  patches: none\n")
+		}
+		for _, patch := range info.Patches {
+			fmt.Printf("  patch: %s\n", patch)
+		}
+		fmt.Printf("  format assertion: %s\n", on_off(info.AssertFormat))
+		fmt.Printf("  content assertion: %s\n", on_off(info.AssertContent))
+	}
+}
+
+// creates a json-schema validator,
+// adds the latest POA and VOR schemas it can find to it,
+// compiles them,
+// returning a map of labels => compiled-schemas
+// `schema_root` may be a directory or a zip archive of the api-raml.
+// when `schema_opts.Definition` is set, only schemas having that definition are returned.
+func configure_validator(schema_root string, schema_opts SchemaOptions) (map[string]Schema, error) {
+	var empty_response map[string]Schema
+
+	compiler := jsonschema.NewCompiler()
+	// api-raml schemas are draft 4, a schema's own '$schema' takes precedence.
+	compiler.Draft = jsonschema.Draft4
+	if schema_opts.Draft != nil {
+		compiler.Draft = schema_opts.Draft
+	}
+	compiler.AssertFormat = schema_opts.AssertFormat
+	compiler.AssertContent = schema_opts.AssertContent
+	compiler.RegisterExtension(x_deprecated, x_deprecated_meta, x_deprecated_compiler{})
+
+	is_zip := is_zip_schema_root(schema_root)
+	fsys, close_schema_root, err := open_schema_root(schema_root)
+	if err != nil {
+		return empty_response, err
+	}
+	defer close_schema_root()
+	if is_zip {
+		compiler.LoadURL = zip_loader(fsys)
+	}
+	if len(schema_opts.RefMap) > 0 {
+		next := jsonschema.LoadURL
+		if compiler.LoadURL != nil {
+			next = compiler.LoadURL
+		}
+		compiler.LoadURL = ref_map_loader(schema_opts.RefMap, next)
+	}
+
+	stop_timer := schema_opts.Timer.start("schema discovery")
+	schema_finder := discover_schemas
+	if schema_opts.Raml {
+		schema_finder = resolve_raml_schemas
+	}
+	info_map, err := schema_finder(fsys)
+	if err != nil {
+		return empty_response, err
+	}
+	if schema_opts.Previous {
+		for label, info := range info_map {
+			info.Path, err = previous_schema(fsys, label)
+			if err != nil {
+				return empty_response, err
+			}
+			info_map[label] = info
+		}
+	}
+	stop_timer()
+	defer schema_opts.Timer.start("schema compilation")()
+
+	schema_file_list := map[string]string{}
+	for label, info := range info_map {
+		schema_file_list[label] = info.Path
+	}
+
+	schema_map := map[string]Schema{}
+	for label, path := range schema_file_list {
+		file_bytes, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return empty_response, fmt.Errorf("failed to read %s schema: %w", label, err)
+		}
+		declared := gjson.GetBytes(file_bytes, "$schema").String()
+		patch_list := []SchemaPatch{}
+		if label == "VOR" {
+			// patch ISBN regex as it can't be compiled in Go.
+			// todo: this needs a fix upstream in api-raml.
+			// - https://json-schema.org/understanding-json-schema/reference/regular_expressions.html
+			// - https://github.com/santhosh-tekuri/jsonschema/issues/113
+			// - https://github.com/elifesciences/api-raml/blob/8e2ffb573b2c3d2e173c38cd8b9625cf2d5740ad/src/misc/isbn.v1.yaml#L6
+			find := "allOf.2.properties.references.items.definitions.book.properties.isbn.pattern"
+			replace := "^.+$"
+			patch := SchemaPatch{Label: label, Path: find, Old: gjson.GetBytes(file_bytes, find).Raw, New: strconv.Quote(replace), Default: true}
+			patch.Reason = "ISBN pattern can't be compiled in Go"
+			if patch.Old == "" {
+				// the pattern has moved or been fixed upstream, it's added regardless.
+				patch.Reason = "ISBN pattern can't be compiled in Go, but it wasn't found"
+			}
+			file_bytes, err = sjson.SetBytes(file_bytes, find, replace)
+			if err != nil {
+				return empty_response, fmt.Errorf("failed to patch ISBN in %s schema: %w", label, err)
+			}
+			patch_list = append(patch_list, patch)
+		}
+
+		if schema_opts.ForceDraft {
+			file_bytes, err = sjson.DeleteBytes(file_bytes, "$schema")
+			if err != nil {
+				return empty_response, fmt.Errorf("failed to remove '$schema' from %s schema: %w", label, err)
+			}
+			if declared != "" {
+				patch_list = append(patch_list, SchemaPatch{Label: label, Path: "$schema", Old: strconv.Quote(declared), Reason: "compiled as the given draft"})
+			}
+		}
+
+		if len(schema_opts.Overlay) > 0 {
+			file_bytes, err = apply_schema_overlay(file_bytes, schema_opts.Overlay)
+			if err != nil {
+				return empty_response, fmt.Errorf("failed to apply schema overlay to %s schema: %w", label, err)
+			}
+			overlay := bytes.Buffer{}
+			json.Compact(&overlay, schema_opts.Overlay)
+			patch_list = append(patch_list, SchemaPatch{Label: label, Path: "allOf.-1", New: overlay.String(), Reason: "schema overlay"})
+		}
+
+		// schemas in a zip are addressed by their path in the archive so their refs can be resolved.
+		url := label
+		if is_zip {
+			url = zip_url_prefix + path
+		}
+
+		err = compiler.AddResource(url, bytes.NewReader(file_bytes))
+		if err != nil {
+			return empty_response, fmt.Errorf("failed to add %s schema to compiler: %w", label, err)
+		}
+
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return empty_response, fmt.Errorf("failed to compile %s schema: %w", label, err)
+		}
+
+		schema_path := filepath.Join(schema_root, path)
+
+		if schema_opts.Definition != "" {
+			pointer, err := find_definition(file_bytes, schema_opts.Definition)
+			if err != nil {
+				// not every schema has every definition.
+				continue
+			}
+			schema, err = compiler.Compile(url + "#" + pointer)
+			if err != nil {
+				return empty_response, fmt.Errorf("failed to compile definition %s in %s schema: %w", schema_opts.Definition, label, err)
+			}
+			schema_path = schema_path + "#" + pointer
+		}
+
+		schema_map[label] = Schema{
+			Label:    label,
+			Path:     schema_path,
+			Schema:   schema,
+			Sha256:   content_hash("sha256", file_bytes),
+			Declared: declared,
+			Patches:  patch_list,
+			// a definition is compiled as the draft of the schema it's within.
+			AssertFormat:  asserts_format(schema.Draft, declared, schema_opts.AssertFormat),
+			AssertContent: asserts_content(schema.Draft, schema_opts.AssertContent),
+		}
+	}
+	if len(schema_map) == 0 {
+		return empty_response, fmt.Errorf("definition not found in any schema: %s", schema_opts.Definition)
+	}
+	return schema_map, nil
+}
+
+// validates a trivial empty article against each schema in `schema_map`,
+// returning an error if any schema fails with anything other than a validation error.
+// catches a misconfigured compiler, like a broken schema patch, before any files are read.
+func sanity_check_schemas(schema_map map[string]Schema) (err error) {
+	label_list := []string{}
+	for label := range schema_map {
+		label_list = append(label_list, label)
+	}
+	slices.Sort(label_list)
+	for _, label := range label_list {
+		schema := schema_map[label]
+		if schema.Schema == nil {
+			return fmt.Errorf("%s schema was not compiled", label)
+		}
+		err = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%s schema panicked during sanity validation: %v", label, r)
+				}
+			}()
+			validation_err := schema.Schema.Validate(map[string]interface{}{})
+			var ve *jsonschema.ValidationError
+			if validation_err != nil && !errors.As(validation_err, &ve) {
+				return fmt.Errorf("%s schema failed sanity validation: %w", label, validation_err)
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads a manifest of sha256 checksums in the format written by `sha256sum`:
+// "<hex digest>  <filename>", one per line.
+// returns a map of filename => lowercased hex digest.
+func read_checksum_manifest(manifest_path string) (map[string]string, error) {
+	empty_response := map[string]string{}
+	fh, err := os.Open(manifest_path)
+	if err != nil {
+		return empty_response, err
+	}
+	defer fh.Close()
+
+	checksum_map := map[string]string{}
+	scanner := bufio.NewScanner(fh)
+	line_num := 0
+	for scanner.Scan() {
+		line_num++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		digest, filename, found := strings.Cut(line, " ")
+		if !found {
+			return empty_response, fmt.Errorf("malformed checksum on line %d: %s", line_num, line)
+		}
+		// "*filename" is written by `sha256sum --binary`
+		filename = strings.TrimPrefix(strings.TrimSpace(filename), "*")
+		checksum_map[filename] = strings.ToLower(digest)
+	}
+	return checksum_map, scanner.Err()
+}
+
+// compares the sha256 digest of `file_bytes` to the expected digest for `file_path` in `checksum_map`.
+// the manifest may list a file by the path given or just its filename.
+// a file missing from the manifest is considered a mismatch.
+func verify_checksum(checksum_map map[string]string, file_path string, file_bytes []byte) error {
+	expected, present := checksum_map[file_path]
+	if !present {
+		expected, present = checksum_map[filepath.Base(file_path)]
+	}
+	if !present {
+		return fmt.Errorf("%w: no expected checksum found for: %s", ErrChecksumMismatch, file_path)
+	}
+	digest := sha256.Sum256(file_bytes)
+	actual := hex.EncodeToString(digest[:])
+	if actual != expected {
+		return fmt.Errorf("%w: expected %s but got %s: %s", ErrChecksumMismatch, expected, actual, file_path)
+	}
+	return nil
+}
+
+// filename extensions of article-json files, compressed or not, see --ext.
+var default_ext_list = []string{".json", ".json.gz"}
+
+// returns true if `filename` ends with one of the extensions in `ext_list`.
+// "elife-09560-v1.xml.json.bak" is not an article-json file unless ".json.bak" is in `ext_list`.
+func is_article_json_file(filename string, ext_list []string) bool {
+	for _, ext := range ext_list {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// the first two bytes of any gzip stream.
+var gzip_magic = []byte{0x1f, 0x8b}
+
+// returns a reader that decompresses `r` if it's gzipped or if `force` is true.
+// gzip is detected by peeking at the first bytes of `r`, nothing is consumed.
+func maybe_gunzip_reader(r io.Reader, force bool) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(gzip_magic))
+	if !force && !bytes.Equal(magic, gzip_magic) {
+		return buffered, nil
+	}
+	reader, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("failed decompressing: %w", err)
+	}
+	return reader, nil
+}
+
+// returns the deepest nesting of objects and arrays in the json `data`,
+// without parsing it. brackets within strings are ignored.
+// scanning stops early once the depth exceeds `limit`.
+func json_depth(data []byte, limit int) int {
+	depth := 0
+	max_depth := 0
+	in_string := false
+	escaped := false
+	for _, b := range data {
+		if in_string {
+			if escaped {
+				escaped = false
+			} else if b == '\\' {
+				escaped = true
+			} else if b == '"' {
+				in_string = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			in_string = true
+		case '{', '[':
+			depth++
+			if depth > max_depth {
+				max_depth = depth
+				if max_depth > limit {
+					return max_depth
+				}
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return max_depth
+}
+
+// ---
+
+// returns an `ErrFileTooLarge` error and the size of the file at `file_path` if it's larger than `max_size` bytes.
+// the size of an S3 object isn't known until it's read so it's never too large.
+func check_file_size(file_path string, max_size int64) (int64, error) {
+	if max_size <= 0 || is_s3_url(file_path) {
+		return 0, nil
+	}
+	info, err := os.Stat(file_path)
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > max_size {
+		return info.Size(), fmt.Errorf("%w: %d bytes, over the --max-file-size of %d bytes", ErrFileTooLarge, info.Size(), max_size)
+	}
+	return info.Size(), nil
+}
+
+// reads the article-json at `article_json_path`, extracting the 'article' section.
+// a file over `opts.MaxFileSize` fails without being read.
+// a file that can't be read fails when `opts.KeepGoing` is set, otherwise it panics.
+// an S3 object that can't be read always fails.
+func read_article_data(article_json_path string, schema_map map[string]Schema, opts Options) Article {
+	size, err := check_file_size(article_json_path, opts.MaxFileSize)
+	if errors.Is(err, ErrFileTooLarge) {
+		return Article{FileName: article_json_path, Error: err, Bytes: int(size)}
+	}
+	article_json_bytes, err := read_file(article_json_path)
+	if err != nil && (opts.KeepGoing || is_s3_url(article_json_path)) {
+		return Article{FileName: article_json_path, Error: fmt.Errorf("failed reading file: %w", err)}
+	}
+	panic_on_err(err, "reading bytes from path: "+article_json_path)
+
+	article := prepare_article(article_json_path, article_json_bytes, schema_map, opts)
+	article.Bytes = len(article_json_bytes)
+	if opts.Hash != "" {
+		article.Hash = content_hash(opts.Hash, article_json_bytes)
+	}
+	return article
+}
+
+// same as `read_article_data` but a file containing a list of article-json documents is read as an article per document,
+// see `prepare_articles`.
+func read_articles(article_json_path string, schema_map map[string]Schema, opts Options) []Article {
+	size, err := check_file_size(article_json_path, opts.MaxFileSize)
+	if errors.Is(err, ErrFileTooLarge) {
+		return []Article{{FileName: article_json_path, Error: err, Bytes: int(size)}}
+	}
+	article_json_bytes, err := read_file(article_json_path)
+	if err != nil && (opts.KeepGoing || is_s3_url(article_json_path)) {
+		return []Article{{FileName: article_json_path, Error: fmt.Errorf("failed reading file: %w", err)}}
+	}
+	panic_on_err(err, "reading bytes from path: "+article_json_path)
+
+	article_list := prepare_articles(article_json_path, article_json_bytes, schema_map, opts)
+	if len(article_list) == 1 && article_list[0].FileName == article_json_path {
+		article_list[0].Bytes = len(article_json_bytes)
+		if opts.Hash != "" {
+			article_list[0].Hash = content_hash(opts.Hash, article_json_bytes)
+		}
+	}
+	return article_list
+}
+
+// returns true if the article-json file at `article_json_path` contains a list of article-json documents.
+func is_document_list_file(article_json_path string, opts Options) bool {
+	if _, err := check_file_size(article_json_path, opts.MaxFileSize); err != nil {
+		return false
+	}
+	article_json_bytes, err := read_file(article_json_path)
+	if err != nil {
+		return false
+	}
+	opts.Checksums = nil
+	article_json_bytes, err = decode_article_json(article_json_path, article_json_bytes, opts)
+	return err == nil && is_document_list(article_json_bytes)
+}
+
+// the filename given to article-json read from stdin.
+const stdin_file_name = "stdin"
+
+// reads a single article-json document from `r`, see `read_article_data`.
+func read_stdin_article(r io.Reader, schema_map map[string]Schema, opts Options) Article {
+	article_json_bytes, err := io.ReadAll(r)
+	panic_on_err(err, "reading bytes from stdin")
+
+	article := prepare_article(stdin_file_name, article_json_bytes, schema_map, opts)
+	article.Bytes = len(article_json_bytes)
+	if opts.Hash != "" {
+		article.Hash = content_hash(opts.Hash, article_json_bytes)
+	}
+	return article
+}
+
+// supported --hash algorithms.
+var hash_algorithm_list = []string{"md5", "sha256"}
+
+// returns the hex digest of `data` using the hash `algorithm`, either 'md5' or 'sha256'.
+func content_hash(algorithm string, data []byte) string {
+	switch algorithm {
+	case "md5":
+		digest := md5.Sum(data)
+		return hex.EncodeToString(digest[:])
+	case "sha256":
+		digest := sha256.Sum256(data)
+		return hex.EncodeToString(digest[:])
+	}
+	panic("unsupported hash algorithm: " + algorithm)
+}
+
+// returns the article-json within the file `article_json_bytes` read from `article_json_path`.
+// when `opts.Checksums` is non-empty the file bytes are verified against it before anything else.
+// gzipped article-json ('.gz') is decompressed after checksum verification.
+func decode_article_json(article_json_path string, article_json_bytes []byte, opts Options) ([]byte, error) {
+	var err error
+	if len(opts.Checksums) > 0 {
+		err = verify_checksum(opts.Checksums, article_json_path, article_json_bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.HasSuffix(article_json_path, ".gz") {
+		// decompression happens in the single feeder goroutine and costs CPU,
+		// a gzipped corpus may see the feeder become the bottleneck.
+		article_json_bytes, err = gunzip(article_json_bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress article-json: %w", err)
+		}
+	}
+
+	if opts.Base64Field != "" {
+		article_json_bytes, err = decode_base64_field(article_json_bytes, opts.Base64Field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CheckUTF8 {
+		err = check_utf8(article_json_bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return article_json_bytes, nil
+}
+
+// returns an `ErrInvalidUTF8` error with the offset of the first invalid byte sequence in `article_json_bytes`.
+// encoding/json replaces invalid sequences with U+FFFD, so the schemas never see them.
+func check_utf8(article_json_bytes []byte) error {
+	if utf8.Valid(article_json_bytes) {
+		return nil
+	}
+	offset := 0
+	for offset < len(article_json_bytes) {
+		r, size := utf8.DecodeRune(article_json_bytes[offset:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		offset += size
+	}
+	return fmt.Errorf("%w: at byte %d", ErrInvalidUTF8, offset)
+}
+
+// prepares the article-json `article_json_bytes` read from `article_json_path` for validation, see `decode_article_json`.
+// an article whose 'article.status' has no schema in `schema_map` fails with `ErrUnknownStatus`.
+func prepare_article(article_json_path string, article_json_bytes []byte, schema_map map[string]Schema, opts Options) Article {
+	article_json_bytes, err := decode_article_json(article_json_path, article_json_bytes, opts)
+	if err != nil {
+		return Article{
+			FileName: article_json_path,
+			Error:    err,
+		}
+	}
+	return prepare_document(article_json_path, article_json_bytes, schema_map, opts)
+}
+
+// returns true if the article-json `article_json_bytes` is a list of article-json documents rather than a single document.
+func is_document_list(article_json_bytes []byte) bool {
+	trimmed := bytes.TrimSpace(article_json_bytes)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// matches the index of a document within a list of documents at the end of a filename, "dump.json[12]" => "dump.json", "12"
+var document_index_regex = regexp.MustCompile(`^(.+)\[(\d+)\]$`)
+
+// returns the path to the file containing the document `file_name` and the index of the document within it,
+// "/path/to/dump.json[12]" => "/path/to/dump.json", 12.
+// returns false if `file_name` isn't a document within a list of documents.
+func split_document_index(file_name string) (string, int, bool) {
+	match := document_index_regex.FindStringSubmatch(file_name)
+	if match == nil {
+		return file_name, 0, false
+	}
+	index, err := strconv.Atoi(match[2])
+	if err != nil {
+		return file_name, 0, false
+	}
+	return match[1], index, true
+}
+
+// same as `prepare_article` but a file containing a list of article-json documents is prepared as a separate article
+// for each document, named after the file and the index of the document, "dump.json[0]", "dump.json[1]", etc.
+// each document selects its own schema.
+func prepare_articles(article_json_path string, article_json_bytes []byte, schema_map map[string]Schema, opts Options) []Article {
+	article_json_bytes, err := decode_article_json(article_json_path, article_json_bytes, opts)
+	if err != nil {
+		return []Article{{FileName: article_json_path, Error: err}}
+	}
+	if !is_document_list(article_json_bytes) {
+		return []Article{prepare_document(article_json_path, article_json_bytes, schema_map, opts)}
+	}
+	err = check_trailing_data(article_json_bytes)
+	if err != nil {
+		return []Article{{FileName: article_json_path, Error: err}}
+	}
+	article_list := []Article{}
+	gjson.ParseBytes(article_json_bytes).ForEach(func(_, document gjson.Result) bool {
+		document_bytes := []byte(document.Raw)
+		article := prepare_document(fmt.Sprintf("%s[%d]", article_json_path, len(article_list)), document_bytes, schema_map, opts)
+		article.Bytes = len(document_bytes)
+		if opts.Hash != "" {
+			article.Hash = content_hash(opts.Hash, document_bytes)
+		}
+		article_list = append(article_list, article)
+		return true
+	})
+	if len(article_list) == 0 {
+		return []Article{{FileName: article_json_path, Error: ErrEmptyArticle}}
+	}
+	return article_list
+}
+
+// returns an `ErrTrailingData` error if anything but whitespace follows the first json value in `article_json_bytes`.
+// only the 'article' section is unmarshalled for validation and everything after the document would otherwise be ignored.
+// the json itself being invalid isn't reported here.
+func check_trailing_data(article_json_bytes []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(article_json_bytes))
+	// the whole value is read before it's unmarshalled, an empty struct skips building it.
+	var value struct{}
+	err := decoder.Decode(&value)
+	var type_err *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &type_err) {
+		return nil
+	}
+	offset := decoder.InputOffset()
+	if len(bytes.TrimSpace(article_json_bytes[offset:])) > 0 {
+		return fmt.Errorf("%w: at byte %d", ErrTrailingData, offset)
+	}
+	return nil
+}
+
+// prepares a single decoded article-json document `article_json_bytes` read from `article_json_path` for validation.
+func prepare_document(article_json_path string, article_json_bytes []byte, schema_map map[string]Schema, opts Options) Article {
+	if len(bytes.TrimSpace(article_json_bytes)) == 0 {
+		if opts.AllowEmpty {
+			return Article{
+				FileName: article_json_path,
+				Skipped:  true,
+			}
+		}
+		return Article{
+			FileName: article_json_path,
+			Error:    ErrEmptyArticle,
+		}
+	}
+
+	if opts.MaxDepth > 0 && json_depth(article_json_bytes, opts.MaxDepth) > opts.MaxDepth {
+		return Article{
+			FileName: article_json_path,
+			Error:    fmt.Errorf("%w: deeper than %d", ErrMaxDepth, opts.MaxDepth),
+		}
+	}
+
+	err := check_trailing_data(article_json_bytes)
+	if err != nil {
+		return Article{
+			FileName: article_json_path,
+			Error:    err,
+		}
+	}
+
+	var article Article
+	if opts.ForceType != "" {
+		// the article's status isn't looked for at all, it may not have one.
+		extract := extract_article_data
+		if opts.NoExtract {
+			extract = extract_document_data
+		}
+		article, err = extract(article_json_path, article_json_bytes)
+		article.Type = opts.ForceType
+	} else {
+		parse := parse_article_data
+		if opts.NoExtract {
+			parse = parse_document_data
+		}
+		article, err = parse(article_json_path, article_json_bytes, opts.StatusField)
+	}
+	if err != nil {
+		// a malformed file fails rather than stopping a batch or a long-running worker.
+		if !gjson.ValidBytes(article_json_bytes) {
+			err = errors.New("not valid json")
+		}
+		return Article{
+			FileName: article_json_path,
+			Error:    fmt.Errorf("failed parsing article-json: %w", err),
+		}
+	}
+
+	// catch unknown statuses here rather than panicking in a worker mid-batch.
+	if _, present := schema_map[article.Type]; !present {
+		article.Error = fmt.Errorf("%w: %s", ErrUnknownStatus, article.Type)
+		article.Data = nil
+	}
+	return article
+}
+
+// returns the base64 decoded contents of the string at the gjson `field_path` in the json `envelope_bytes`.
+// "message.body" => {"message": {"body": "eyJhcnRpY2xlIjoge319"}} => {"article": {}}
+func decode_base64_field(envelope_bytes []byte, field_path string) ([]byte, error) {
+	field := gjson.GetBytes(envelope_bytes, field_path)
+	if !field.Exists() {
+		return nil, fmt.Errorf("base64 field not found: %s", field_path)
+	}
+	if field.Type != gjson.String {
+		return nil, fmt.Errorf("base64 field is not a string: %s", field_path)
+	}
+	decoded_bytes, err := base64.StdEncoding.DecodeString(field.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 field %s: %w", field_path, err)
+	}
+	return decoded_bytes, nil
+}
+
+// the gjson path to the field whose value, uppercased, selects the schema to validate an article with.
+const default_status_field = "article.status"
+
+// returns the label of the schema to validate the article-json `article_json_bytes` with, "POA" or "VOR",
+// from the value of its `status_field` and any warnings about that value.
+// an empty `status_field` is the `default_status_field`.
+func parse_article_status(article_json_bytes []byte, status_field string) (string, []string, error) {
+	if status_field == "" {
+		status_field = default_status_field
+	}
+	article_status := gjson.GetBytes(article_json_bytes, status_field) // "poa", "vor"
+	if !article_status.Exists() {
+		return "", nil, fmt.Errorf("'%s' field in article data not found", status_field)
+	}
+	// whitespace is trimmed so the schema can still be found, the schema decides if the status is valid.
+	status := strings.TrimSpace(article_status.String())
+	warning_list := []string{}
+	if status != article_status.String() {
+		warning_list = append(warning_list, fmt.Sprintf("whitespace trimmed from '%s': %q", status_field, article_status.String()))
+	}
+	return strings.ToUpper(status), warning_list, nil // "poa" => "POA"
+}
+
+// parses the whole article-json document `article_json_bytes` rather than its 'article' section,
+// for schemas that describe the entire document, see --no-extract.
+// returns an error if the article-json is missing the `status_field`.
+func parse_document_data(article_json_path string, article_json_bytes []byte, status_field string) (Article, error) {
+	schema_key, warning_list, err := parse_article_status(article_json_bytes, status_field)
+	if err != nil {
+		return Article{}, err
+	}
+	article, err := extract_document_data(article_json_path, article_json_bytes)
+	if err != nil {
+		return Article{}, err
+	}
+	article.Type = schema_key
+	article.Warnings = warning_list
+	return article, nil
+}
+
+// same as `parse_document_data` but without a type, the status of the article isn't looked for.
+func extract_document_data(article_json_path string, article_json_bytes []byte) (Article, error) {
+	var document interface{}
+	err := json.Unmarshal(article_json_bytes, &document)
+	if err != nil {
+		return Article{}, fmt.Errorf("failed unmarshalling article-json bytes: %w", err)
+	}
+	return Article{
+		FileName: article_json_path,
+		Data:     document,
+		raw:      []byte(gjson.GetBytes(article_json_bytes, "article").Raw),
+	}, nil
+}
+
+// extracts the 'article' section from the article-json `article_json_bytes`,
+// returning an error if the article-json is missing an 'article' or the `status_field`.
+// an empty `status_field` is the `default_status_field`.
+func parse_article_data(article_json_path string, article_json_bytes []byte, status_field string) (Article, error) {
+	schema_key, warning_list, err := parse_article_status(article_json_bytes, status_field)
+	if err != nil {
+		return Article{}, err
+	}
+	article, err := extract_article_data(article_json_path, article_json_bytes)
+	if err != nil {
+		return Article{}, err
+	}
+	article.Type = schema_key
+	article.Warnings = warning_list
+	return article, nil
+}
+
+// same as `parse_article_data` but without a type, the status of the article isn't looked for.
+func extract_article_data(article_json_path string, article_json_bytes []byte) (Article, error) {
+	// article-json contains 'journal', 'snippet' and 'article' sections.
+	// extract just the 'article' from the article data.
+	result := gjson.GetBytes(article_json_bytes, "article")
+	if !result.Exists() {
+		return Article{}, errors.New("'article' field in article data not found")
+	}
+
+	// what is happening here?? the slice of matching bytes are extracted from
+	// the article-json, skipping a conversion of `result` to a string then back
+	// to bytes for unmarshalling. if only a `result.Bytes()` existed :(
+	// - https://github.com/tidwall/gjson#user-content-working-with-bytes
+	var raw []byte
+	if result.Index > 0 {
+		raw = article_json_bytes[result.Index : result.Index+len(result.Raw)]
+	} else {
+		raw = []byte(result.Raw)
+	}
+
+	// convert the article-json data into a simple go datatype
+	var article interface{}
+	err := json.Unmarshal(raw, &article)
+	if err != nil {
+		return Article{}, fmt.Errorf("failed unmarshalling article section bytes: %w", err)
+	}
+
+	return Article{
+		FileName: article_json_path,
+		Data:     article,
+		raw:      raw,
+	}, nil
+}
+
+func validate(schema Schema, article interface{}) (time.Duration, error) {
+	start := time.Now()
+	err := schema.Schema.Validate(article)
+	end := time.Now()
+	elapsed := end.Sub(start)
+	return elapsed, err
+}
+
+// S3 urls are assumed to exist, they are only checked once they are read.
+func path_exists(path string) bool {
+	if is_s3_url(path) {
+		return true
+	}
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// an S3 url is a directory if it's a prefix ending with a slash, "s3://bucket/prefix/".
+func path_is_dir(path string) bool {
+	if is_s3_url(path) {
+		return strings.HasSuffix(path, "/")
+	}
+	fi, err := os.Lstat(path)
+	panic_on_err(err, "reading path: "+path)
+	return fi.Mode().IsDir()
+}
+
+func validate_article(schema_map map[string]Schema, article Article, capture_error bool) Result {
+	if article.Skipped {
+		return Result{
+			FileName: article.FileName,
+			Success:  true,
+			Skipped:  true,
+			Bytes:    article.Bytes,
+			Hash:     article.Hash,
+		}
+	}
+
+	if article.Error != nil {
+		// article couldn't be read, nothing to validate.
+		// these errors are small so they are always captured.
+		return Result{
+			Type:       article.Type,
+			FileName:   article.FileName,
+			Success:    false,
+			Error:      article.Error,
+			Bytes:      article.Bytes,
+			ErrorCount: 1,
+			Hash:       article.Hash,
+			Severity:   error_severity(article.Error),
+		}
+	}
+
+	// read article data and determine schema to use
+	schema, present := schema_map[article.Type]
+	if !present {
+		panic("schema not found: " + article.Type)
+	}
+
+	// validate!
+	elapsed, err := validate(schema, article.Data)
+
+	r := Result{
+		Type:     article.Type, // POA or VOR
+		FileName: article.FileName,
+		Elapsed:  elapsed.Milliseconds(),
+		elapsed:  elapsed,
+		Success:  err == nil,
+		Bytes:    article.Bytes,
+		Hash:     article.Hash,
+	}
+
+	if err != nil {
+		r.ErrorCount = len(flatten_validation_error(err))
+		r.Severity = error_severity(err)
+	}
+
+	if capture_error && err != nil {
+		r.Error = err
+	}
+
+	return r
+}
+
+// returns `file_name` relative to the directory `base_dir` for reporting.
+// `file_name` is returned unchanged when `base_dir` is empty or it can't be made relative.
+// "/path/to/article-json/elife-09560-v1.xml.json" => "elife-09560-v1.xml.json"
+func relative_file_name(base_dir string, file_name string) string {
+	if base_dir == "" || file_name == "" || is_s3_url(file_name) {
+		return file_name
+	}
+	abs_base_dir, err := filepath.Abs(base_dir)
+	if err != nil {
+		return file_name
+	}
+	abs_file_name, err := filepath.Abs(file_name)
+	if err != nil {
+		return file_name
+	}
+	rel_file_name, err := filepath.Rel(abs_base_dir, abs_file_name)
+	if err != nil {
+		return file_name
+	}
+	return rel_file_name
+}
+
+// the opposite of `relative_file_name`, returns `file_name` joined to the directory `base_dir` it's relative to.
+// S3 urls are never relative.
+func absolute_file_name(base_dir string, file_name string) string {
+	if is_s3_url(file_name) {
+		return file_name
+	}
+	return filepath.Join(base_dir, file_name)
+}
+
+// returns the keyword of a validation error from its keyword location, "/properties/title/minLength" => "minLength".
+func validation_keyword(ve *jsonschema.ValidationError) string {
+	return ve.KeywordLocation[strings.LastIndex(ve.KeywordLocation, "/")+1:]
+}
+
+// severities of failures, most severe first, see `error_severity`.
+var severity_list = []string{"high", "medium", "low"}
+
+// the severity of a validation error by its keyword.
+// structural errors, where the article isn't the shape the schema describes, are high.
+// content errors, where a value has the right shape but isn't allowed, are medium.
+// errors for any other keyword are low.
+var keyword_severity_map = map[string]string{
+	"required":              "high",
+	"type":                  "high",
+	"additionalProperties":  "high",
+	"additionalItems":       "high",
+	"unevaluatedProperties": "high",
+	"unevaluatedItems":      "high",
+	"dependencies":          "high",
+	"dependentRequired":     "high",
+	"minProperties":         "high",
+	"maxProperties":         "high",
+	"minItems":              "high",
+	"maxItems":              "high",
+
+	"format":           "medium",
+	"pattern":          "medium",
+	"minLength":        "medium",
+	"maxLength":        "medium",
+	"enum":             "medium",
+	"const":            "medium",
+	"minimum":          "medium",
+	"maximum":          "medium",
+	"exclusiveMinimum": "medium",
+	"exclusiveMaximum": "medium",
+	"multipleOf":       "medium",
+	"uniqueItems":      "medium",
+	"contentEncoding":  "medium",
+	"contentMediaType": "medium",
+}
+
+// returns the highest severity of the leaf validation errors in `err`, see `keyword_severity_map`.
+// an error that isn't a validation error, for an article that couldn't be validated at all, is high.
+func error_severity(err error) string {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return "high"
+	}
+	highest := len(severity_list) - 1
+	var walk func(ve *jsonschema.ValidationError)
+	walk = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			severity, present := keyword_severity_map[validation_keyword(ve)]
+			if present {
+				highest = min(highest, slices.Index(severity_list, severity))
+			}
+			return
+		}
+		for _, cause := range ve.Causes {
+			walk(cause)
+		}
+	}
+	walk(ve)
+	return severity_list[highest]
+}
+
+// returns a copy of the validation error `ve` without the leaf errors whose keyword is in `keyword_list`.
+// a branch is removed once all of its causes are removed, or once any of them is for an 'anyOf' or 'oneOf'
+// as that alternative would then be valid. nil is returned when nothing is left.
+func ignore_keywords(ve *jsonschema.ValidationError, keyword_list []string) *jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		if slices.Contains(keyword_list, validation_keyword(ve)) {
+			return nil
+		}
+		return ve
+	}
+	is_alternative := slices.Contains([]string{"anyOf", "oneOf"}, validation_keyword(ve))
+	cause_list := []*jsonschema.ValidationError{}
+	for _, cause := range ve.Causes {
+		kept := ignore_keywords(cause, keyword_list)
+		if kept == nil && is_alternative {
+			return nil
+		}
+		if kept != nil {
+			cause_list = append(cause_list, kept)
+		}
+	}
+	if len(cause_list) == 0 {
+		return nil
+	}
+	pruned := *ve
+	pruned.Causes = cause_list
+	return &pruned
+}
+
+// removes the validation errors with a keyword in `keyword_list` from the `result`, see `ignore_keywords`.
+// the result is successful if no errors are left.
+func ignore_result_keywords(result Result, keyword_list []string) Result {
+	var ve *jsonschema.ValidationError
+	if !errors.As(result.Error, &ve) {
+		return result
+	}
+	pruned := ignore_keywords(ve, keyword_list)
+	if pruned == nil {
+		result.IgnoredErrors = result.ErrorCount
+		result.ErrorCount = 0
+		result.Success = true
+		result.Error = nil
+		result.Severity = ""
+		return result
+	}
+	result.Severity = error_severity(pruned)
+	error_count := len(flatten_validation_error(pruned))
+	result.IgnoredErrors = result.ErrorCount - error_count
+	result.ErrorCount = error_count
+	result.Error = pruned
+	return result
+}
+
+// validates the `article` and runs any additional checks enabled in `opts`, adding their warnings to the result.
+func check_article(schema_map map[string]Schema, article Article, opts Options) Result {
+	// errors are needed to know which to ignore.
+	result := validate_article(schema_map, article, opts.CaptureError || len(opts.IgnoreKeywords) > 0)
+	if len(opts.IgnoreKeywords) > 0 {
+		result = ignore_result_keywords(result, opts.IgnoreKeywords)
+		if !opts.CaptureError {
+			result.Error = nil
+		}
+	}
+	result.FileName = relative_file_name(opts.RelativeTo, result.FileName)
+	result.Warnings = append(result.Warnings, article.Warnings...)
+	result.Warnings = append(result.Warnings, run_extra_checks(opts.ExtraChecks, article, schema_map[article.Type].Schema)...)
+	if opts.IncludeRaw && !result.Success && len(article.raw) > 0 {
+		// the 'article' as it was read, numbers and the order of keys are as they are in the file.
+		result.Raw = json.RawMessage(article.raw)
+	}
+	if opts.Coverage != nil && article.Error == nil && !article.Skipped && article.Data != nil {
+		opts.Coverage.record(article.Type, schema_map[article.Type].Schema, article.Data)
+	}
+	if opts.ReportExtraProperties && result.Success && !result.Skipped {
+		for _, location := range find_extra_properties(schema_map[article.Type].Schema, article.Data) {
+			result.Warnings = append(result.Warnings, "property not declared by the schema: "+location)
+		}
+	}
+	return result
+}
+
+// returns the schemas that apply to `value` starting from `schema`,
+// following refs and 'allOf' and only the 'anyOf', 'oneOf' and 'if' branches that `value` is valid against.
+func applicable_schemas(schema *jsonschema.Schema, value interface{}) []*jsonschema.Schema {
+	schema_list := []*jsonschema.Schema{}
+	seen := map[*jsonschema.Schema]bool{}
+	var walk func(schema *jsonschema.Schema)
+	walk = func(schema *jsonschema.Schema) {
+		if schema == nil || seen[schema] {
+			return
+		}
+		seen[schema] = true
+		schema_list = append(schema_list, schema)
+		walk(schema.Ref)
+		walk(schema.RecursiveRef)
+		walk(schema.DynamicRef)
+		for _, sub_schema := range schema.AllOf {
+			walk(sub_schema)
+		}
+		for _, sub_schema := range append(slices.Clone(schema.AnyOf), schema.OneOf...) {
+			if sub_schema.Validate(value) == nil {
+				walk(sub_schema)
+			}
+		}
+		if schema.If != nil {
+			if schema.If.Validate(value) == nil {
+				walk(schema.Then)
+			} else {
+				walk(schema.Else)
+			}
+		}
+		if object, is_object := value.(map[string]interface{}); is_object {
+			for key, sub_schema := range schema.DependentSchemas {
+				if _, present := object[key]; present {
+					walk(sub_schema)
+				}
+			}
+		}
+	}
+	walk(schema)
+	return schema_list
+}
+
+// returns the json pointers to the properties in `value` that no schema that applies to them declares,
+// either in 'properties' or 'patternProperties'.
+// properties of objects with an 'additionalProperties' schema are considered declared.
+// locations are sorted.
+func find_extra_properties(schema *jsonschema.Schema, value interface{}) []string {
+	location_list := []string{}
+	var walk func(schema_list []*jsonschema.Schema, value interface{}, location string)
+	walk = func(schema_list []*jsonschema.Schema, value interface{}, location string) {
+		applicable_list := []*jsonschema.Schema{}
+		for _, schema := range schema_list {
+			applicable_list = append(applicable_list, applicable_schemas(schema, value)...)
+		}
+		switch val := value.(type) {
+		case map[string]interface{}:
+			for key, child := range val {
+				child_schema_list, declared := property_schemas(applicable_list, key)
+				child_location := location + "/" + escape_json_pointer(key)
+				if !declared {
+					location_list = append(location_list, child_location)
+					continue
+				}
+				walk(child_schema_list, child, child_location)
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(item_schemas(applicable_list, i), child, location+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	walk([]*jsonschema.Schema{schema}, value, "")
+	slices.Sort(location_list)
+	return location_list
+}
+
+// returns the schemas in `schema_list` that apply to the property `key` of an object,
+// from 'properties', 'patternProperties' or 'additionalProperties',
+// and false if no schema declares the property.
+func property_schemas(schema_list []*jsonschema.Schema, key string) ([]*jsonschema.Schema, bool) {
+	child_schema_list := []*jsonschema.Schema{}
+	declared := false
+	for _, schema := range schema_list {
+		matched := false
+		if sub_schema, present := schema.Properties[key]; present {
+			child_schema_list = append(child_schema_list, sub_schema)
+			matched = true
+		}
+		for pattern, sub_schema := range schema.PatternProperties {
+			if pattern.MatchString(key) {
+				child_schema_list = append(child_schema_list, sub_schema)
+				matched = true
+			}
+		}
+		if sub_schema, is_schema := schema.AdditionalProperties.(*jsonschema.Schema); is_schema && !matched {
+			child_schema_list = append(child_schema_list, sub_schema)
+			matched = true
+		}
+		declared = declared || matched
+	}
+	return child_schema_list, declared
+}
+
+// returns the schemas in `schema_list` that apply to the item at index `i` of an array.
+func item_schemas(schema_list []*jsonschema.Schema, i int) []*jsonschema.Schema {
+	child_schema_list := []*jsonschema.Schema{}
+	for _, schema := range schema_list {
+		switch items := schema.Items.(type) {
+		case *jsonschema.Schema:
+			child_schema_list = append(child_schema_list, items)
+		case []*jsonschema.Schema:
+			if i < len(items) {
+				child_schema_list = append(child_schema_list, items[i])
+			} else if additional, is_schema := schema.AdditionalItems.(*jsonschema.Schema); is_schema {
+				child_schema_list = append(child_schema_list, additional)
+			}
+		}
+		if i < len(schema.PrefixItems) {
+			child_schema_list = append(child_schema_list, schema.PrefixItems[i])
+		} else if schema.Items2020 != nil {
+			child_schema_list = append(child_schema_list, schema.Items2020)
+		}
+	}
+	return child_schema_list
+}
+
+// returns the locations of the schemas within `schema` that apply to `value` or any value within it,
+// see `applicable_schemas`. each location is returned once.
+func evaluated_schema_locations(schema *jsonschema.Schema, value interface{}) map[string]bool {
+	location_map := map[string]bool{}
+	var walk func(schema_list []*jsonschema.Schema, value interface{})
+	walk = func(schema_list []*jsonschema.Schema, value interface{}) {
+		applicable_list := []*jsonschema.Schema{}
+		for _, schema := range schema_list {
+			applicable_list = append(applicable_list, applicable_schemas(schema, value)...)
+		}
+		for _, schema := range applicable_list {
+			location_map[schema.Location] = true
+		}
+		switch val := value.(type) {
+		case map[string]interface{}:
+			for key, child := range val {
+				child_schema_list, _ := property_schemas(applicable_list, key)
+				walk(child_schema_list, child)
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(item_schemas(applicable_list, i), child)
+			}
+		}
+	}
+	walk([]*jsonschema.Schema{schema}, value)
+	return location_map
+}
+
+// returns the locations of `schema` and every schema within it, following refs.
+func all_schema_locations(schema *jsonschema.Schema) []string {
+	location_list := []string{}
+	seen := map[*jsonschema.Schema]bool{}
+	var walk func(schema *jsonschema.Schema)
+	walk = func(schema *jsonschema.Schema) {
+		if schema == nil || seen[schema] {
+			return
+		}
+		seen[schema] = true
+		location_list = append(location_list, schema.Location)
+		sub_schema_list := []*jsonschema.Schema{
+			schema.Ref, schema.RecursiveRef, schema.DynamicRef, schema.Not, schema.If, schema.Then, schema.Else,
+			schema.PropertyNames, schema.UnevaluatedProperties, schema.Items2020, schema.Contains, schema.UnevaluatedItems,
+		}
+		sub_schema_list = append(sub_schema_list, schema.AllOf...)
+		sub_schema_list = append(sub_schema_list, schema.AnyOf...)
+		sub_schema_list = append(sub_schema_list, schema.OneOf...)
+		sub_schema_list = append(sub_schema_list, schema.PrefixItems...)
+		for _, sub_schema := range schema.Properties {
+			sub_schema_list = append(sub_schema_list, sub_schema)
+		}
+		for _, sub_schema := range schema.PatternProperties {
+			sub_schema_list = append(sub_schema_list, sub_schema)
+		}
+		for _, sub_schema := range schema.DependentSchemas {
+			sub_schema_list = append(sub_schema_list, sub_schema)
+		}
+		for _, dependency := range schema.Dependencies {
+			if sub_schema, is_schema := dependency.(*jsonschema.Schema); is_schema {
+				sub_schema_list = append(sub_schema_list, sub_schema)
+			}
+		}
+		for _, val := range []interface{}{schema.AdditionalProperties, schema.AdditionalItems, schema.Items} {
+			switch sub_schema := val.(type) {
+			case *jsonschema.Schema:
+				sub_schema_list = append(sub_schema_list, sub_schema)
+			case []*jsonschema.Schema:
+				sub_schema_list = append(sub_schema_list, sub_schema...)
+			}
+		}
+		for _, sub_schema := range sub_schema_list {
+			walk(sub_schema)
+		}
+	}
+	walk(schema)
+	slices.Sort(location_list)
+	return slices.Compact(location_list)
+}
+
+// counts how many articles each schema location was evaluated for, see --coverage-file.
+// safe for concurrent use by multiple workers.
+type schema_coverage struct {
+	mu sync.Mutex
+	// label => number of articles
+	article_map map[string]int
+	// label => schema location => number of articles
+	count_map map[string]map[string]int
+}
+
+func new_schema_coverage() *schema_coverage {
+	return &schema_coverage{
+		article_map: map[string]int{},
+		count_map:   map[string]map[string]int{},
+	}
+}
+
+// records the schema locations within the `schema` labelled `label` that were evaluated for the article `value`.
+func (c *schema_coverage) record(label string, schema *jsonschema.Schema, value interface{}) {
+	location_map := evaluated_schema_locations(schema, value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.article_map[label]++
+	if c.count_map[label] == nil {
+		c.count_map[label] = map[string]int{}
+	}
+	for location := range location_map {
+		c.count_map[label][location]++
+	}
+}
+
+// how much of a schema was evaluated by a corpus of articles.
+type SchemaCoverage struct {
+	Path     string `json:"path"`
+	Articles int    `json:"articles"`
+	// number of schemas within the schema, including itself.
+	Locations int `json:"locations"`
+	// number of those schemas evaluated for at least one article.
+	Evaluated int     `json:"evaluated"`
+	Percent   float64 `json:"percent"`
+	// schema location => number of articles it was evaluated for, including those never evaluated.
+	Counts map[string]int `json:"counts"`
+}
+
+// the output of --coverage-file.
+type CoverageReport struct {
+	Meta    Meta                      `json:"meta"`
+	Schemas map[string]SchemaCoverage `json:"schemas"`
+}
+
+// returns the coverage of each schema in `schema_map`.
+// schema locations are made relative to the current directory, like 'VOR#/allOf/1/properties/title'.
+func (c *schema_coverage) report(schema_map map[string]Schema) map[string]SchemaCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base_url := schema_base_url()
+	coverage_map := map[string]SchemaCoverage{}
+	for label, schema := range schema_map {
+		coverage := SchemaCoverage{
+			Path:     schema.Path,
+			Articles: c.article_map[label],
+			Counts:   map[string]int{},
+		}
+		for _, location := range all_schema_locations(schema.Schema) {
+			count := c.count_map[label][location]
+			coverage.Counts[strings.TrimPrefix(location, base_url)] = count
+			coverage.Locations++
+			if count > 0 {
+				coverage.Evaluated++
+			}
+		}
+		if coverage.Locations > 0 {
+			coverage.Percent = math.Round(float64(coverage.Evaluated)/float64(coverage.Locations)*1000) / 10
+		}
+		coverage_map[label] = coverage
+	}
+	return coverage_map
+}
+
+// writes a coverage report of the schemas in `schema_map` to the file at `coverage_path`.
+func write_coverage_file(coverage_path string, meta Meta, coverage *schema_coverage, schema_map map[string]Schema) error {
+	report := CoverageReport{
+		Meta:    meta,
+		Schemas: coverage.report(schema_map),
+	}
+	report_bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(coverage_path, append(report_bytes, '\n'), 0644)
+}
+
+// Validator validates article-json against the compiled POA and VOR schemas.
+// compiled schemas are read-only once compiled so a single Validator is safe
+// for concurrent use by multiple goroutines.
+type Validator struct {
+	schema_map map[string]Schema
+}
+
+// finds and compiles the latest POA and VOR schemas in the api-raml `schema_root`.
+func NewValidator(schema_root string) (*Validator, error) {
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{schema_map: schema_map}, nil
+}
+
+// validates the 'article' section of the article-json `article_json`.
+// an error is returned when the article-json can't be validated at all,
+// for example when it's not json or has no schema for its 'article.status'.
+// validation errors are captured in `Result.Error`.
+func (v *Validator) Validate(article_json []byte) (Result, error) {
+	article, err := parse_article_data("", article_json, default_status_field)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := v.ValidateValue(article.Type, article.Data)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Bytes = len(article_json)
+	return result, nil
+}
+
+// validates `data`, an already unmarshalled 'article' section, against the schema for `article_type`, "POA" or "VOR".
+// `data` must be made of the types `encoding/json` unmarshals into, like `map[string]interface{}` and `[]interface{}`.
+// an error is returned when there is no schema for `article_type` or `data` contains any other types.
+// validation errors are captured in `Result.Error`.
+func (v *Validator) ValidateValue(article_type string, data interface{}) (Result, error) {
+	article := Article{
+		Type: strings.ToUpper(article_type),
+		Data: data,
+	}
+	if _, present := v.schema_map[article.Type]; !present {
+		return Result{}, fmt.Errorf("%w: %s", ErrUnknownStatus, article.Type)
+	}
+	capture_error := true
+	result := validate_article(v.schema_map, article, capture_error)
+	var type_err jsonschema.InvalidJSONTypeError
+	if errors.As(result.Error, &type_err) {
+		return Result{}, fmt.Errorf("article data can't be validated: %w", type_err)
+	}
+	return result, nil
+}
+
+// reads article-json from `r` until EOF and validates its 'article' section,
+// for validating request bodies and other streams without writing them to disk first.
+// an error is returned when `r` can't be read or the article-json can't be validated at all,
+// see `Validate`.
+func (v *Validator) ValidateReader(r io.Reader) (Result, error) {
+	article_json, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed reading article-json: %w", err)
+	}
+	return v.Validate(article_json)
+}
+
+// validates each article-json file in the directory `dir` with a worker per cpu core, returning a result per article.
+// result filenames are relative to `dir` and validation errors are captured in `Result.Error`.
+// a file that can't be read or parsed fails with its error in `Result.Error`, it never stops the batch.
+// once `ctx` is done no more files are read or validated and the results of the articles already validated
+// are returned with the context's error.
+func (v *Validator) ValidateDirectory(ctx context.Context, dir string) ([]Result, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", dir)
+	}
+	opts := Options{
+		BufferSize:   1000,
+		NumWorkers:   runtime.NumCPU(),
+		CaptureError: true,
+		RelativeTo:   dir,
+		Context:      ctx,
+		KeepGoing:    true,
+	}
+	file_list, err := find_files(dir, -1, default_ext_list)
+	if err != nil {
+		return nil, err
+	}
+	_, _, result_list := process_files_with_feeder(file_list, v.schema_map, opts)
+	return result_list, ctx.Err()
+}
+
+func format_ms(ms int64) string {
+	elapsed_str := fmt.Sprintf("%dms", ms)
+	if ms >= 60000 {
+		// minutes
+		elapsed_str = fmt.Sprintf("%dm", (ms/1000)/60)
+
+	} else if ms >= 1000 {
+		// seconds
+		elapsed_str = fmt.Sprintf("%ds", ms/1000)
+	}
+	return elapsed_str
+}
+
+// a tiny article-json fixture for --self-test with its expected outcome.
+type self_test_fixture struct {
+	Name    string
+	Data    string
+	Success bool
+}
+
+var self_test_fixture_list = []self_test_fixture{
+	{
+		Name:    "known-good POA",
+		Success: true,
+		Data: `{"article": {
+			"id": "09560", "version": 1, "type": "research-article", "doi": "10.7554/eLife.09560",
+			"title": "A self-test article", "stage": "preview", "status": "poa", "volume": 4, "elocationId": "e09560",
+			"copyright": {"license": "CC-BY-4.0", "holder": "Self Test", "statement": "This article is distributed under the terms of the Creative Commons Attribution License."}
+		}}`,
+	},
+	{
+		Name:    "known-good VOR",
+		Success: true,
+		Data: `{"article": {
+			"id": "09560", "version": 1, "type": "research-article", "doi": "10.7554/eLife.09560",
+			"title": "A self-test article", "stage": "preview", "status": "vor", "volume": 4, "elocationId": "e09560",
+			"copyright": {"license": "CC-BY-4.0", "holder": "Self Test", "statement": "This article is distributed under the terms of the Creative Commons Attribution License."}
+		}}`,
+	},
+	{
+		Name:    "known-bad POA",
+		Success: false,
+		Data:    `{"article": {"id": 9560, "version": "one", "status": "poa"}}`,
+	},
+	{
+		Name:    "known-bad VOR",
+		Success: false,
+		Data:    `{"article": {"id": 9560, "version": "one", "status": "vor"}}`,
+	},
+}
+
+// validates each of the `self_test_fixture_list` against the schemas in `schema_map`,
+// returning a message for each fixture that didn't have its expected outcome.
+func self_test(schema_map map[string]Schema) []string {
+	failure_list := []string{}
+	for _, fixture := range self_test_fixture_list {
+		article, err := parse_article_data(fixture.Name, []byte(fixture.Data), default_status_field)
+		if err != nil {
+			failure_list = append(failure_list, fmt.Sprintf("%s: %v", fixture.Name, err))
+			continue
+		}
+		if _, present := schema_map[article.Type]; !present {
+			failure_list = append(failure_list, fmt.Sprintf("%s: %v", fixture.Name, ErrUnknownStatus))
+			continue
+		}
+		result := validate_article(schema_map, article, true)
+		if result.Success != fixture.Success {
+			msg := fmt.Sprintf("%s: expected success=%t, got success=%t", fixture.Name, fixture.Success, result.Success)
+			if result.Error != nil {
+				msg += "\n" + strings.Join(flatten_validation_error(result.Error), "\n")
+			}
+			failure_list = append(failure_list, msg)
+		}
+	}
+	return failure_list
+}
+
+// returns the results in `result_list` in the same order as the results with the same filename in `order_list`.
+// results in `result_list` without a matching filename in `order_list` are dropped.
+func order_results(result_list []Result, order_list []Result) []Result {
+	result_map := map[string]Result{}
+	for _, result := range result_list {
+		result_map[result.FileName] = result
+	}
+	ordered_list := []Result{}
+	for _, ordered := range order_list {
+		if result, present := result_map[ordered.FileName]; present {
+			ordered_list = append(ordered_list, result)
+		}
+	}
+	return ordered_list
+}
+
+// returns up to `n` results from `result_list` with the longest validation time, slowest first.
+func slowest_results(result_list []Result, n int) []Result {
+	sorted_list := slices.Clone(result_list)
+	sort.SliceStable(sorted_list, func(a, b int) bool {
+		if sorted_list[a].Elapsed != sorted_list[b].Elapsed {
+			return sorted_list[a].Elapsed > sorted_list[b].Elapsed
+		}
+		return sorted_list[a].FileName < sorted_list[b].FileName
+	})
+	if n < len(sorted_list) {
+		sorted_list = sorted_list[:n]
+	}
+	return sorted_list
+}
+
+// prints the type, validation time and size of each result in `result_list`.
+// "VOR	1s	2104511 bytes: path/to/elife-09560-v1.xml.json"
+func print_slowest(result_list []Result) {
+	for _, result := range result_list {
+		label := result.Type
+		if label == "" {
+			label = "---"
+		}
+		println(fmt.Sprintf("%s\t%4s\t%9d bytes: %s", label, format_ms(result.Elapsed), result.Bytes, result.FileName))
+	}
+}
+
+const (
+	ansi_red    = "\033[31m"
+	ansi_green  = "\033[32m"
+	ansi_yellow = "\033[33m"
+	ansi_cyan   = "\033[36m"
+	ansi_reset  = "\033[0m"
+)
+
+// returns true if output to `f` should be colored given a --color `mode` of 'auto', 'always' or 'never'.
+// 'auto' colors output to a terminal unless the NO_COLOR envvar is set.
+// - https://no-color.org/
+func use_color(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// wraps `s` in the ANSI escape `color` when `enabled` is true.
+func colorise(s string, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return color + s + ansi_reset
+}
+
+// matches the instance location in a long validation error, "[I#/path/to/value]",
+// and the quoted instance location in a short validation error, "jsonschema: '/path/to/value'".
+var instance_location_regex = regexp.MustCompile(`\[I#[^\]]*\]|^jsonschema: '[^']*'`)
+
+// colors the instance locations in the validation error message `msg` when `enabled` is true.
+func highlight_instance_locations(msg string, enabled bool) string {
+	if !enabled {
+		return msg
+	}
+	return instance_location_regex.ReplaceAllStringFunc(msg, func(match string) string {
+		return colorise(match, ansi_cyan, true)
+	})
+}
+
+func short_validation_error(err error, color bool) {
+	fmt.Printf("%s\n", highlight_instance_locations(err.Error(), color))
+}
+
+// prints the tree of validation errors in `err`, see `format_validation_tree`.
+// when `raw` is true the validator's own Go syntax representation is printed instead, useful when debugging the validator.
+func long_validation_error(err error, color bool, raw bool) {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		// not a validation error, the Go syntax representation isn't helpful.
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if raw {
+		fmt.Printf("%s\n", highlight_instance_locations(fmt.Sprintf("%#v", err), color))
+		return
+	}
+	fmt.Printf("%s\n", highlight_instance_locations(format_validation_tree(ve), color))
+}
+
+// returns the tree of validation errors in `ve` as indented lines of instance locations and messages,
+// each cause indented beneath the error it explains.
+// "[I#] doesn't validate with VOR#"
+// "  [I#/title] length must be >= 1, but got 0"
+func format_validation_tree(ve *jsonschema.ValidationError) string {
+	line_list := []string{}
+	var walk func(ve *jsonschema.ValidationError, depth int)
+	walk = func(ve *jsonschema.ValidationError, depth int) {
+		line_list = append(line_list, fmt.Sprintf("%s[I#%s] %s", strings.Repeat("  ", depth), ve.InstanceLocation, ve.Message))
+		for _, cause := range ve.Causes {
+			walk(cause, depth+1)
+		}
+	}
+	walk(ve, 0)
+	return strings.Join(line_list, "\n")
+}
+
+// prints the valid/invalid message for a single `result` followed by its full validation error and warnings.
+// returns true if the result failed.
+func explain_result(result Result, color_stderr bool, color_stdout bool, raw_errors bool) bool {
+	println(result.format(color_stderr))
+	if !result.Success {
+		long_validation_error(result.Error, color_stdout, raw_errors)
+	}
+	for _, warning := range result.Warnings {
+		println(colorise("warning:", ansi_yellow, color_stderr) + " " + warning)
+	}
+	return !result.Success
+}
+
+// walks the tree of validation errors in `err` and returns just the leaves, one line per leaf.
+// the leaves are the errors that actually describe the problem, the branches are 'allOf failed', etc.
+// "[I#/title] [S#/properties/title/minLength] length must be >= 1, but got 0"
+func flatten_validation_error(err error) []string {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []string{err.Error()}
+	}
+	var walk func(ve *jsonschema.ValidationError) []string
+	walk = func(ve *jsonschema.ValidationError) []string {
+		if len(ve.Causes) == 0 {
+			return []string{fmt.Sprintf("[I#%s] [S#%s] %s", ve.InstanceLocation, ve.KeywordLocation, ve.Message)}
+		}
+		line_list := []string{}
+		for _, cause := range ve.Causes {
+			line_list = append(line_list, walk(cause)...)
+		}
+		return line_list
+	}
+	return walk(ve)
+}
+
+// returns the gjson path to the value at the json `pointer`, "/authors/0/name" => "authors.0.name".
+func json_pointer_to_gjson_path(pointer string) string {
+	if pointer == "" {
+		return "@this"
+	}
+	part_list := []string{}
+	for _, part := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		part_list = append(part_list, gjson.Escape(part))
+	}
+	return strings.Join(part_list, ".")
+}
+
+// returns the line number, starting at 1, of the value at the json `pointer` within the json `data`.
+func json_pointer_line(data []byte, pointer string) (int, bool) {
+	result := gjson.GetBytes(data, json_pointer_to_gjson_path(pointer))
+	if !result.Exists() || result.Index <= 0 {
+		return 0, false
+	}
+	return bytes.Count(data[:result.Index], []byte("\n")) + 1, true
+}
+
+// escapes `s` for use in a github workflow command, "::error file={property}::{data}".
+// - https://github.com/actions/toolkit/blob/main/packages/core/src/command.ts
+func escape_github_command(s string, property bool) string {
+	s = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+	if property {
+		s = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(s)
+	}
+	return s
+}
+
+// returns a github workflow command for each problem in a failed `result`, shown as annotations on the file.
+// `article_json_path` is the path to the article-json file and `data` its contents, used to find the line of each problem.
+// the line is omitted when `data` is nil, for example for a gzipped file.
+// `whole_document` is true when the whole article-json was validated rather than its 'article' section, see --no-extract.
+// "::error file=path/to/elife-09560-v1.xml.json,line=12::/title: length must be >= 1, but got 0"
+func github_annotations(result Result, article_json_path string, data []byte, whole_document bool) []string {
+	// the 'article' section of the article-json is validated, not the whole file.
+	pointer_prefix := "/article"
+	if whole_document {
+		pointer_prefix = ""
+	}
+	// a document within a list of documents is annotated on the file with the list.
+	article_json_path, index, is_document := split_document_index(article_json_path)
+	if is_document {
+		pointer_prefix = fmt.Sprintf("/%d%s", index, pointer_prefix)
+	}
+	annotation := func(line int, message string) string {
+		property := "file=" + escape_github_command(article_json_path, true)
+		if line > 0 {
+			property += fmt.Sprintf(",line=%d", line)
+		}
+		return "::error " + property + "::" + escape_github_command(message, false)
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(result.Error, &ve) {
+		message := "invalid"
+		if result.Error != nil {
+			message = result.Error.Error()
+		}
+		return []string{annotation(0, message)}
+	}
+	annotation_list := []string{}
+	var walk func(ve *jsonschema.ValidationError)
+	walk = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) > 0 {
+			for _, cause := range ve.Causes {
+				walk(cause)
+			}
+			return
+		}
+		line, _ := json_pointer_line(data, pointer_prefix+ve.InstanceLocation)
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		annotation_list = append(annotation_list, annotation(line, location+": "+ve.Message))
+	}
+	walk(ve)
+	return annotation_list
+}
+
+// prints github workflow commands for each problem in the failed `result` to stdout, see `github_annotations`.
+// `article_json_path` is re-read to find the line of each problem, unless it's compressed or encoded.
+func print_github_annotations(result Result, article_json_path string, opts Options) {
+	var data []byte
+	file, _, _ := split_document_index(article_json_path)
+	if !strings.HasSuffix(file, ".gz") && opts.Base64Field == "" {
+		data, _ = os.ReadFile(file)
+	}
+	for _, annotation := range github_annotations(result, article_json_path, data, opts.NoExtract) {
+		fmt.Println(annotation)
+	}
+}
+
+// returns the path of the article-json `file_name` relative to the directory `input_dir` it was found in,
+// so the files written for it to an output directory mirror where it was found and same-named files don't collide.
+// a file outside of `input_dir` is named after just its base name.
+// "/path/to/article-json/elife-09560-v1.xml.json" => "elife-09560-v1.xml.json"
+func mirrored_name(input_dir string, file_name string) string {
+	if is_s3_url(file_name) {
+		if name, found := strings.CutPrefix(file_name, input_dir); found && name != "" {
+			return strings.TrimPrefix(name, "/")
+		}
+		return path.Base(file_name)
+	}
+	name, err := filepath.Rel(input_dir, file_name)
+	if err != nil || !filepath.IsLocal(name) {
+		return filepath.Base(file_name)
+	}
+	return name
+}
+
+// writes the flattened validation errors of a failed `result` to a file in `output_dir`,
+// named after the article-json file's `name` with an '.errors.txt' suffix, see `mirrored_name`.
+// "reports/elife-09560-v1.xml.json.errors.txt"
+func write_error_report(output_dir string, name string, result Result) error {
+	output_path := filepath.Join(output_dir, name+".errors.txt")
+	err := os.MkdirAll(filepath.Dir(output_path), 0755)
+	if err != nil {
+		return err
+	}
+	content := strings.Join(flatten_validation_error(result.Error), "\n") + "\n"
+	return os.WriteFile(output_path, []byte(content), 0644)
+}
+
+// a validation error stripped of anything that varies between runs or machines.
+type GoldenError struct {
+	InstanceLocation string        `json:"instance_location"`
+	KeywordLocation  string        `json:"keyword_location"`
+	Message          string        `json:"message"`
+	Causes           []GoldenError `json:"causes,omitempty"`
+}
+
+// a canonicalized `Result` that can be compared to the results of future runs.
+type GoldenResult struct {
+	FileName   string        `json:"file_name"`
+	Type       string        `json:"type"`
+	Success    bool          `json:"success"`
+	Skipped    bool          `json:"skipped"`
+	ErrorCount int           `json:"error_count"`
+	Errors     []GoldenError `json:"errors"`
+	Warnings   []string      `json:"warnings"`
+}
+
+// converts a `result` into a `GoldenResult`.
+// timings and file paths are dropped, the schema url prefix `base_url` is removed from messages and
+// errors are sorted as the order of some causes isn't stable between runs.
+func golden_result(result Result, base_url string) GoldenResult {
+	var walk func(detail_list []ErrorDetail) []GoldenError
+	walk = func(detail_list []ErrorDetail) []GoldenError {
+		golden_list := []GoldenError{}
+		for _, detail := range detail_list {
+			golden := GoldenError{
+				InstanceLocation: detail.InstanceLocation,
+				KeywordLocation:  detail.KeywordLocation,
+				Message:          strings.ReplaceAll(detail.Message, base_url, ""),
+			}
+			if len(detail.Causes) > 0 {
+				golden.Causes = walk(detail.Causes)
+			}
+			golden_list = append(golden_list, golden)
+		}
+		sort.SliceStable(golden_list, func(a, b int) bool {
+			x, y := golden_list[a], golden_list[b]
+			if x.InstanceLocation != y.InstanceLocation {
+				return x.InstanceLocation < y.InstanceLocation
+			}
+			if x.KeywordLocation != y.KeywordLocation {
+				return x.KeywordLocation < y.KeywordLocation
+			}
+			return x.Message < y.Message
+		})
+		return golden_list
+	}
+	warning_list := []string{}
+	warning_list = append(warning_list, result.Warnings...)
+	return GoldenResult{
+		FileName:   filepath.Base(result.FileName),
+		Type:       result.Type,
+		Success:    result.Success,
+		Skipped:    result.Skipped,
+		ErrorCount: result.ErrorCount,
+		Errors:     walk(DetailedError(result.Error)),
+		Warnings:   warning_list,
+	}
+}
+
+// returns the path of the golden file in `golden_dir` for the article-json file's `name`, see `mirrored_name`.
+// "testdata/golden/elife-09560-v1.xml.json.golden.json"
+func golden_path(golden_dir string, name string) string {
+	return filepath.Join(golden_dir, name+".golden.json")
+}
+
+// writes the canonicalized `result` to a file in `golden_dir`,
+// named after the article-json file's `name` with a '.golden.json' suffix, see `golden_path`.
+func write_golden(golden_dir string, name string, result Result, base_url string) error {
+	golden := golden_result(result, base_url)
+	golden.FileName = name
+	golden_bytes, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		return err
+	}
+	output_path := golden_path(golden_dir, name)
+	err = os.MkdirAll(filepath.Dir(output_path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output_path, append(golden_bytes, '\n'), 0644)
+}
+
+// returns a line for each of the innermost errors in `golden_list`, like `flatten_validation_error`.
+func flatten_golden_errors(golden_list []GoldenError) []string {
+	line_list := []string{}
+	for _, golden := range golden_list {
+		if len(golden.Causes) == 0 {
+			line_list = append(line_list, fmt.Sprintf("[I#%s] [S#%s] %s", golden.InstanceLocation, golden.KeywordLocation, golden.Message))
+			continue
+		}
+		line_list = append(line_list, flatten_golden_errors(golden.Causes)...)
+	}
+	return line_list
+}
+
+// compares the canonicalized `result` to its golden file in `golden_dir`, written by a previous run with `write_golden`.
+// returns a description of each difference, empty when the result matches.
+func compare_golden(golden_dir string, name string, result Result, base_url string) ([]string, error) {
+	golden_bytes, err := os.ReadFile(golden_path(golden_dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{"no golden result"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var expected GoldenResult
+	err = json.Unmarshal(golden_bytes, &expected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse golden result: %w", err)
+	}
+	actual := golden_result(result, base_url)
+
+	diff_list := []string{}
+	if expected.Type != actual.Type {
+		diff_list = append(diff_list, fmt.Sprintf("type: %s, was %s", actual.Type, expected.Type))
+	}
+	if expected.Success != actual.Success {
+		diff_list = append(diff_list, fmt.Sprintf("success: %t, was %t", actual.Success, expected.Success))
+	}
+	if expected.Skipped != actual.Skipped {
+		diff_list = append(diff_list, fmt.Sprintf("skipped: %t, was %t", actual.Skipped, expected.Skipped))
+	}
+	if expected.ErrorCount != actual.ErrorCount {
+		diff_list = append(diff_list, fmt.Sprintf("error count: %d, was %d", actual.ErrorCount, expected.ErrorCount))
+	}
+	expected_error_list := flatten_golden_errors(expected.Errors)
+	actual_error_list := flatten_golden_errors(actual.Errors)
+	for _, line := range expected_error_list {
+		if !slices.Contains(actual_error_list, line) {
+			diff_list = append(diff_list, "- error "+line)
+		}
+	}
+	for _, line := range actual_error_list {
+		if !slices.Contains(expected_error_list, line) {
+			diff_list = append(diff_list, "+ error "+line)
+		}
+	}
+	for _, warning := range expected.Warnings {
+		if !slices.Contains(actual.Warnings, warning) {
+			diff_list = append(diff_list, "- warning "+warning)
+		}
+	}
+	for _, warning := range actual.Warnings {
+		if !slices.Contains(expected.Warnings, warning) {
+			diff_list = append(diff_list, "+ warning "+warning)
+		}
+	}
+	return diff_list, nil
+}
+
+// compares each result in `result_list` to its golden file in `golden_dir`, see `compare_golden`,
+// printing the differences of each result that doesn't match.
+// `name` returns the name of the article-json file of a result, see `mirrored_name`.
+// returns the number of results that didn't match.
+func compare_goldens(golden_dir string, result_list []Result, name func(Result) string, base_url string) int {
+	num_mismatched := 0
+	for _, result := range result_list {
+		diff_list, err := compare_golden(golden_dir, name(result), result, base_url)
+		panic_on_err(err, "comparing golden result for: "+result.FileName)
+		if len(diff_list) == 0 {
+			continue
+		}
+		num_mismatched++
+		println("golden result differs: " + result.FileName)
+		for _, diff := range diff_list {
+			println("  " + diff)
+		}
+	}
+	return num_mismatched
+}
+
+// returns the json `data` in a canonical format: object keys sorted, indented with two spaces and a trailing newline.
+// numbers are kept exactly as they are written and characters like '<' and '&' are not escaped.
+func normalize_json(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the json")
+	}
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writes `data` to a temporary file next to `output_path` with the permissions of an existing `output_path`.
+// returns the name of the temporary file, to be renamed over `output_path` by the caller.
+func write_temp_file(output_path string, data []byte) (string, error) {
+	perm := fs.FileMode(0644)
+	if info, err := os.Stat(output_path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(output_path), "."+filepath.Base(output_path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// writes `data` to a temporary file next to `output_path` and then renames it over `output_path`,
+// so `output_path` is never left partially written. the permissions of an existing file are kept.
+func write_file_atomic(output_path string, data []byte) error {
+	tmp_path, err := write_temp_file(output_path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp_path) // fails harmlessly once renamed
+	return os.Rename(tmp_path, output_path)
+}
+
+// rewrites each article-json file in `path_list` with `normalize_json` if it isn't already normalized.
+// every file is normalized to a temporary file before any file is replaced,
+// so an error leaves all files as they were. the error is prefixed with the path of the file that failed.
+// returns the paths of the files that were rewritten. gzipped files are left as they are.
+func normalize_files(path_list []string) ([]string, error) {
+	tmp_map := map[string]string{} // {article-json-path: tmp-path, ...}
+	normalized_list := []string{}
+	remove_all := func() {
+		for _, tmp_path := range tmp_map {
+			os.Remove(tmp_path)
+		}
+	}
+	for _, article_json_path := range path_list {
+		if strings.HasSuffix(article_json_path, ".gz") {
+			continue
+		}
+		article_json_bytes, err := os.ReadFile(article_json_path)
+		if err == nil {
+			var normalized_bytes []byte
+			normalized_bytes, err = normalize_json(article_json_bytes)
+			if err == nil && !bytes.Equal(article_json_bytes, normalized_bytes) {
+				var tmp_path string
+				tmp_path, err = write_temp_file(article_json_path, normalized_bytes)
+				if err == nil {
+					tmp_map[article_json_path] = tmp_path
+					normalized_list = append(normalized_list, article_json_path)
+				}
+			}
+		}
+		if err != nil {
+			remove_all()
+			return nil, fmt.Errorf("%s: %w", article_json_path, err)
+		}
+	}
+	for i, article_json_path := range normalized_list {
+		err := os.Rename(tmp_map[article_json_path], article_json_path)
+		if err != nil {
+			for _, remaining_path := range normalized_list[i:] {
+				os.Remove(tmp_map[remaining_path])
+			}
+			return normalized_list[:i], fmt.Errorf("%s: %w", article_json_path, err)
+		}
+	}
+	return normalized_list, nil
+}
+
+// rewrites the article-json file at `article_json_path` with `normalize_json` if it isn't already normalized.
+// returns true if the file was rewritten. gzipped files are left as they are.
+func normalize_file(article_json_path string) (bool, error) {
+	normalized_list, err := normalize_files([]string{article_json_path})
+	return len(normalized_list) > 0, err
+}
+
+// returns the prefix of schema urls that are relative to the working directory.
+// "file:///home/user/validate-article-json/"
+func schema_base_url() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return "file://" + filepath.ToSlash(cwd) + "/"
+}
+
+// functions called before exiting, most recently added first, see `exit`.
+var exit_hook_list []func()
+
+// calls `hook` before exiting, including exits due to failures.
+func add_exit_hook(hook func()) {
+	exit_hook_list = append(exit_hook_list, hook)
+}
+
+// calls any exit hooks then exits with `code`.
+// profiles are only complete if they are written before exiting.
+func exit(code int) {
+	hook_list := exit_hook_list
+	exit_hook_list = nil
+	for i := len(hook_list) - 1; i >= 0; i-- {
+		hook_list[i]()
+	}
+	os.Exit(code)
+}
+
+func die(b bool, msg string) {
+	if b {
+		fmt.Println(msg)
+		exit(1)
+	}
+}
+
+// settings for reading and validating a batch of article-json files.
+type Options struct {
+	BufferSize int // maximum number of articles to keep in memory at once
+	NumWorkers int // maximum number of articles to validate at once, -1 for unbounded
+	// when true, the validation error is available in the `Result` struct.
+	CaptureError bool
+	// when true, a short valid/invalid message is printed as each article is validated.
+	PrintStatus bool
+	// when true, the valid/invalid message is colored.
+	Color bool
+	// when non-nil, each result is also sent here as soon as it's validated.
+	ResultChan chan<- Result
+	// when non-nil, the short valid/invalid message is this template executed with each `Result`, see --format-template.
+	FormatTemplate *template.Template
+	// when true, `NumWorkers` is ignored and the number of workers is adjusted as the batch progresses.
+	AutoWorkers bool
+	// when true, empty article-json files are skipped rather than failed.
+	AllowEmpty bool
+	// when greater than zero, articles nested deeper than this fail without being parsed.
+	MaxDepth int
+	// when true, articles that aren't valid UTF-8 fail without being parsed, see `check_utf8`.
+	CheckUTF8 bool
+	// when greater than zero, article-json files larger than this many bytes fail without being read.
+	MaxFileSize int64
+	// when non-empty, each file is verified against its expected checksum as it is read.
+	Checksums map[string]string
+	// names of checks in `extra_check_map` to run on each article after validation.
+	ExtraChecks []string
+	// when true, properties of valid articles not declared by the schema are reported as warnings.
+	ReportExtraProperties bool
+	// when non-nil, the valid/invalid messages are logged here instead of printed.
+	Logger StatusLogger
+	// when set, each file is hashed with this algorithm as it is read, see `content_hash`.
+	Hash string
+	// when set, the article-json is decoded from the base64 string at this gjson path within each file.
+	Base64Field string
+	// number of articles to buffer before validation starts and the start time is taken, see `wait_for_prefill`.
+	Prefill int
+	// when greater than zero, no more files are read or validated once this many articles have failed.
+	MaxFailures int
+	// when set, result filenames are made relative to this directory, see `relative_file_name`.
+	RelativeTo string
+	// gjson path to the field that selects the schema for each article, see `parse_article_data`.
+	StatusField string
+	// when set, every article is validated with the schema of this type, "POA" or "VOR", and `StatusField` is ignored.
+	ForceType string
+	// when true, the 'article' section of each invalid article is kept in its result, see `Result.Raw`.
+	IncludeRaw bool
+	// validation errors with these keywords are ignored, see `ignore_keywords`.
+	IgnoreKeywords []string
+	// when true, the whole article-json document is validated rather than its 'article' section.
+	NoExtract bool
+	// when true, a file that can't be read fails like an invalid article rather than stopping the batch.
+	KeepGoing bool
+	// when non-nil, the schema locations evaluated for each article are counted here.
+	Coverage *schema_coverage
+	// when greater than zero, a warning is printed for each article that takes this long or longer to validate.
+	// the warning is not one of the result's `Warnings` and never fails a run.
+	SlowThreshold time.Duration
+	// when non-nil, limits the number of buffered articles of each type within `BufferSize`.
+	TypeBuffer *type_buffer
+	// when non-nil, the results of streamed articles are cached by their content, see `process_article_stream`.
+	ResultCache *result_cache
+	// when non-nil, how long workers waited on the feeder is noted here, see --timing.
+	Timer *phase_timer
+	// when done, no more files are read or validated. articles already being validated are finished.
+	// nil is never done.
+	Context context.Context
+}
+
+// returns the `Context` of the options, or a context that is never done.
+func (o Options) ctx() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// limits the number of articles of each type (POA, VOR) waiting to be validated.
+// a VOR is many times larger than a POA so a single `BufferSize` over-commits memory when most buffered articles are VORs.
+// the feeder takes a slot for each article it reads and the slot is given back once the article is taken from the buffer.
+type type_buffer struct {
+	slot_map map[string]chan struct{}
+}
+
+// returns a buffer with `size_map[type]` slots for each type of article.
+// types without a size, or a size of 0, are not limited.
+func new_type_buffer(size_map map[string]int) *type_buffer {
+	slot_map := map[string]chan struct{}{}
+	for article_type, size := range size_map {
+		if size > 0 {
+			slot_map[article_type] = make(chan struct{}, size)
+		}
+	}
+	return &type_buffer{slot_map: slot_map}
+}
+
+// waits for a free slot for an article of `article_type`.
+// returns false if `ctx` was done first.
+func (b *type_buffer) acquire(ctx context.Context, article_type string) bool {
+	if b == nil || b.slot_map[article_type] == nil {
+		return true
+	}
+	select {
+	case b.slot_map[article_type] <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// gives back the slot taken for an article of `article_type`.
+func (b *type_buffer) release(article_type string) {
+	if b == nil || b.slot_map[article_type] == nil {
+		return
+	}
+	<-b.slot_map[article_type]
+}
+
+// sends `article` to `article_chan` once there is room for it in the buffer and a free slot in `opts.TypeBuffer`.
+// returns false if the context of `opts` was done first and the article was dropped.
+func send_article(article_chan chan Article, article Article, opts Options) bool {
+	ctx := opts.ctx()
+	if !opts.TypeBuffer.acquire(ctx, article.Type) {
+		return false
+	}
+	select {
+	case article_chan <- article:
+		return true
+	case <-ctx.Done():
+		opts.TypeBuffer.release(article.Type)
+		return false
+	}
+}
+
+// receives the next article from `article_chan`, giving back its slot in `opts.TypeBuffer`.
+// returns false once the channel is closed.
+func receive_article(article_chan chan Article, opts Options) (Article, bool) {
+	article, ok := <-article_chan
+	if ok {
+		opts.TypeBuffer.release(article.Type)
+	}
+	return article, ok
+}
+
+// reads `file_list` from disk into a buffered channel of up to `opts.BufferSize` articles.
+// the channel is closed once all files have been read.
+func start_feeder(file_list []string, schema_map map[string]Schema, opts Options) (chan Article, *sync.WaitGroup) {
+	buffer_size := opts.BufferSize
+	job_size := len(file_list)
+	if job_size < buffer_size {
+		buffer_size = job_size
+	}
+	article_chan := make(chan Article, buffer_size)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	ctx := opts.ctx()
+	go func(article_chan chan Article, wg *sync.WaitGroup) {
+		defer wg.Done()
+		for _, file := range file_list {
+			if ctx.Err() != nil {
+				break
+			}
+			for _, article := range read_articles(file, schema_map, opts) {
+				if !send_article(article_chan, article, opts) {
+					break
+				}
+			}
+		}
+		close(article_chan)
+		//println("(done reading files)")
+	}(article_chan, &wg)
+	return article_chan, &wg
+}
+
+// returns an error if a line of newline delimited json `line_bytes` can't be prepared as an article.
+// a bad line in a stream fails rather than stopping the stream, unlike a bad article-json file.
+// with `opts.Base64Field` the line is an envelope and the article-json decoded from it is checked.
+func check_ndjson_line(line_bytes []byte, opts Options) error {
+	if !gjson.ValidBytes(line_bytes) {
+		return errors.New("line is not valid json")
+	}
+	if opts.Base64Field != "" {
+		var err error
+		line_bytes, err = decode_base64_field(line_bytes, opts.Base64Field)
+		if err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(line_bytes) {
+			return errors.New("decoded base64 field is not valid json")
+		}
+	}
+	status_field := opts.StatusField
+	if status_field == "" {
+		status_field = default_status_field
+	}
+	if opts.ForceType == "" && !gjson.GetBytes(line_bytes, status_field).Exists() {
+		return fmt.Errorf("'%s' field in article data not found", status_field)
+	}
+	if !opts.NoExtract && !gjson.GetBytes(line_bytes, "article").Exists() {
+		return errors.New("'article' field in article data not found")
+	}
+	return nil
+}
+
+// reads newline delimited article-json documents from `r` into a buffered channel of up to `opts.BufferSize` articles.
+// blank lines are skipped and each article is named after its line number, "stdin:3".
+// the channel is closed once `r` is exhausted, the returned function waits for that and returns any read error.
+func start_ndjson_feeder(r io.Reader, schema_map map[string]Schema, opts Options) (chan Article, func() error) {
+	article_chan := make(chan Article, opts.BufferSize)
+	var read_err error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	ctx := opts.ctx()
+	go func() {
+		defer wg.Done()
+		defer close(article_chan)
+		reader := bufio.NewReader(r)
+		line_num := 0
+		for ctx.Err() == nil {
+			line_bytes, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line_bytes)) > 0 {
+				line_num++
+				file_name := fmt.Sprintf("%s:%d", stdin_file_name, line_num)
+				var article Article
+				if line_err := check_ndjson_line(line_bytes, opts); line_err != nil {
+					article = Article{FileName: file_name, Error: line_err}
+				} else {
+					article = prepare_article(file_name, line_bytes, schema_map, opts)
+				}
+				article.Bytes = len(line_bytes)
+				if opts.Hash != "" {
+					article.Hash = content_hash(opts.Hash, line_bytes)
+				}
+				if opts.ResultCache != nil {
+					article.cache_key = content_hash("sha256", line_bytes)
+				}
+				send_article(article_chan, article, opts)
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				read_err = err
+				return
+			}
+		}
+	}()
+	return article_chan, func() error {
+		wg.Wait()
+		return read_err
+	}
+}
+
+// the time spent in each phase of a run, in the order the phases started, see --timing.
+// time spent in a phase more than once is added together.
+// a nil timer times nothing.
+type phase_timer struct {
+	mu          sync.Mutex
+	phase_list  []string
+	elapsed_map map[string]time.Duration
+	note_list   []string
+}
+
+func new_phase_timer() *phase_timer {
+	return &phase_timer{elapsed_map: map[string]time.Duration{}}
+}
+
+// starts timing `phase`, returning a function that stops it.
+// `defer timer.start("schema compilation")()`
+func (t *phase_timer) start(phase string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start_time := time.Now()
+	return func() {
+		t.add(phase, time.Since(start_time))
+	}
+}
+
+// adds `elapsed` to the time spent in `phase`.
+func (t *phase_timer) add(phase string, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, present := t.elapsed_map[phase]; !present {
+		t.phase_list = append(t.phase_list, phase)
+	}
+	t.elapsed_map[phase] += elapsed
+}
+
+// adds a line to print after the phases, like how long workers waited on the feeder.
+func (t *phase_timer) note(line string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.note_list = append(t.note_list, line)
+}
+
+// returns a line per phase, "schema compilation: 41.2ms", followed by any notes.
+func (t *phase_timer) lines() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line_list := []string{}
+	for _, phase := range t.phase_list {
+		line_list = append(line_list, fmt.Sprintf("%s: %s", phase, t.elapsed_map[phase].Round(time.Microsecond)))
+	}
+	return append(line_list, t.note_list...)
+}
+
+// a bounded cache of the most recent results, keyed by the content of the article-json validated.
+// a retried article is then returned its earlier result without being validated again.
+// a nil cache caches nothing.
+type result_cache struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List // of *result_cache_entry, most recently used first
+	item_map map[string]*list.Element
+}
+
+type result_cache_entry struct {
+	key       string
+	file_name string // of the article the result was validated for
+	result    Result
+}
+
+// returns a cache of the `size` most recently used results.
+func new_result_cache(size int) *result_cache {
+	return &result_cache{
+		size:     size,
+		order:    list.New(),
+		item_map: map[string]*list.Element{},
+	}
+}
+
+// returns the cached result of an article with the same content as `article`.
+// the content is the same but the filename may not be, so the warnings of the checks in `check_list` that depend on the
+// filename are replaced with those for the filename of `article`, see `filename_check_list`.
+func (c *result_cache) get(article Article, check_list []string) (Result, bool) {
+	if c == nil || article.cache_key == "" {
+		return Result{}, false
+	}
+	c.mu.Lock()
+	element, present := c.item_map[article.cache_key]
+	if !present {
+		c.mu.Unlock()
+		return Result{}, false
+	}
+	c.order.MoveToFront(element)
+	entry := *element.Value.(*result_cache_entry)
+	c.mu.Unlock()
+
+	result := entry.result
+	filename_check_list := filename_checks(check_list)
+	if entry.file_name != article.FileName && len(filename_check_list) > 0 {
+		cached_article := article
+		cached_article.FileName = entry.file_name
+		stale_list := run_extra_checks(filename_check_list, cached_article, nil)
+		warning_list := []string{}
+		for _, warning := range result.Warnings {
+			if !slices.Contains(stale_list, warning) {
+				warning_list = append(warning_list, warning)
+			}
+		}
+		result.Warnings = append(warning_list, run_extra_checks(filename_check_list, article, nil)...)
+	}
+	return result, true
+}
+
+// caches the `result` of the `article`, evicting the least recently used result when the cache is full.
+func (c *result_cache) add(article Article, result Result) {
+	if c == nil || article.cache_key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, present := c.item_map[article.cache_key]; present {
+		element.Value.(*result_cache_entry).file_name = article.FileName
+		element.Value.(*result_cache_entry).result = result
+		c.order.MoveToFront(element)
+		return
+	}
+	c.item_map[article.cache_key] = c.order.PushFront(&result_cache_entry{key: article.cache_key, file_name: article.FileName, result: result})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.item_map, oldest.Value.(*result_cache_entry).key)
+	}
+}
+
+// validates newline delimited article-json documents read from `r` with a pool of `opts.NumWorkers` workers,
+// writing each result to `w` as a line of json as soon as it's validated.
+// results are not kept so an unbounded stream can be validated, only the summary is returned.
+func process_ndjson_stream(r io.Reader, w io.Writer, schema_map map[string]Schema, opts Options) (Summary, error) {
+	article_chan, wait_for_feeder := start_ndjson_feeder(r, schema_map, opts)
+	return process_article_stream("stdin", article_chan, wait_for_feeder, w, nil, schema_map, opts)
+}
+
+// validates the articles from `article_chan` with a pool of `opts.NumWorkers` workers,
+// writing each result to `w` as a line of json as soon as it's validated.
+// `wait_for_feeder` is called once `article_chan` is closed, its error is reported as failing to read `source`.
+// `done`, when given, is called with each article once its result has been written.
+func process_article_stream(source string, article_chan chan Article, wait_for_feeder func() error, w io.Writer, done func(Article) error, schema_map map[string]Schema, opts Options) (Summary, error) {
+	worker_pool := pool.New()
+	if opts.NumWorkers >= 1 {
+		worker_pool = worker_pool.WithMaxGoroutines(opts.NumWorkers)
+	}
+	ctx := opts.ctx()
+
+	mu := sync.Mutex{}
+	summary := Summary{Workers: opts.NumWorkers}
+	encoder := json.NewEncoder(w)
+	var write_err error
+	start_time := time.Now()
+	for {
+		article, ok := receive_article(article_chan, opts)
+		if !ok {
+			break
+		}
+		if ctx.Err() != nil {
+			// drain any buffered articles without validating them.
+			continue
+		}
+		worker_pool.Go(func() {
+			result, cached := opts.ResultCache.get(article, opts.ExtraChecks)
+			if cached {
+				// named after this article, it wasn't validated again so it took no time.
+				result.FileName = relative_file_name(opts.RelativeTo, article.FileName)
+				result.Elapsed = 0
+				result.elapsed = 0
+			} else {
+				result = check_article(schema_map, article, opts)
+				opts.ResultCache.add(article, result)
+			}
+			if opts.ResultChan != nil {
+				opts.ResultChan <- result
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			summary.add(result)
+			if cached {
+				summary.CacheHits++
+			}
+			err := encoder.Encode(result)
+			if err == nil && done != nil {
+				err = done(article)
+			}
+			if err != nil && write_err == nil {
+				write_err = err
+			}
+		})
+	}
+	worker_pool.Wait()
+	end_time := time.Now()
+	summary.WallTimeMs = end_time.Sub(start_time).Milliseconds()
+	summary.StartTime = start_time
+	summary.EndTime = end_time
+
+	err := wait_for_feeder()
+	if err != nil {
+		return summary, fmt.Errorf("failed reading %s: %w", source, err)
+	}
+	if write_err != nil {
+		return summary, fmt.Errorf("failed writing result: %w", write_err)
+	}
+	return summary, nil
+}
+
+// a minimal Redis client speaking just enough of its protocol (RESP) for --jobs-from-queue.
+// it isn't safe for concurrent use.
+type redis_client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connects to the Redis server at `addr`, "127.0.0.1:6379".
+func dial_redis(addr string) (*redis_client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &redis_client{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *redis_client) Close() error {
+	return c.conn.Close()
+}
+
+// sends the command `args`, ["RPUSH", "results", "{}"], and returns its reply.
+// replies are a string, an int64, nil or a list of replies. an error reply is returned as an error.
+func (c *redis_client) command(args ...string) (interface{}, error) {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return c.read_reply()
+}
+
+// reads a single reply, see `command`.
+func (c *redis_client) read_reply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("bad redis bulk string length: %s", rest)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		value := make([]byte, size+2) // trailing \r\n
+		_, err = io.ReadFull(c.reader, value)
+		if err != nil {
+			return nil, err
+		}
+		return string(value[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("bad redis array length: %s", rest)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		reply_list := make([]interface{}, size)
+		for i := range reply_list {
+			reply_list[i], err = c.read_reply()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return reply_list, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply: %s", line)
+}
+
+// how long a pop from the queue waits for a path before checking if it should stop.
+const redis_pop_timeout = "1"
+
+// returns the name of the Redis list the paths popped from `queue` are kept in until their results are written,
+// "articles:processing". a path is only lost from both if its result was written.
+func redis_processing_list(queue string) string {
+	return queue + ":processing"
+}
+
+// moves any paths left in the processing list of `queue` back onto `queue`, see `redis_processing_list`.
+// they were popped by a worker that stopped before their results were written, so they're validated again.
+func requeue_redis_processing(client *redis_client, queue string) (int, error) {
+	num_requeued := 0
+	for {
+		reply, err := client.command("LMOVE", redis_processing_list(queue), queue, "RIGHT", "RIGHT")
+		if err != nil {
+			return num_requeued, err
+		}
+		if reply == nil {
+			return num_requeued, nil
+		}
+		num_requeued++
+	}
+}
+
+// pops article-json paths from the Redis list `queue` and reads each path into an article,
+// until the options' context is done. paths are pushed with LPUSH and popped with BLMOVE, oldest first,
+// onto the processing list of `queue` where they stay until their result is written, see `redis_processing_list`.
+// a path that can't be read fails with that error rather than stopping the feeder.
+func start_redis_feeder(client *redis_client, queue string, schema_map map[string]Schema, opts Options) (chan Article, func() error) {
+	article_chan := make(chan Article, opts.BufferSize)
+	var pop_err error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	ctx := opts.ctx()
+	go func() {
+		defer wg.Done()
+		defer close(article_chan)
+		for ctx.Err() == nil {
+			reply, err := client.command("BLMOVE", queue, redis_processing_list(queue), "RIGHT", "LEFT", redis_pop_timeout)
+			if err != nil {
+				pop_err = err
+				return
+			}
+			if reply == nil {
+				continue // timed out, nothing queued.
+			}
+			article_json_path, is_string := reply.(string)
+			if !is_string {
+				pop_err = fmt.Errorf("unexpected BLMOVE reply: %v", reply)
+				return
+			}
+			var article Article
+			_, err = check_file_size(article_json_path, opts.MaxFileSize)
+			var article_json_bytes []byte
+			if err == nil {
+				article_json_bytes, err = read_file(article_json_path)
+			}
+			if errors.Is(err, ErrFileTooLarge) {
+				article = Article{FileName: article_json_path, Error: err}
+			} else if err != nil {
+				article = Article{FileName: article_json_path, Error: fmt.Errorf("failed reading file: %w", err)}
+			} else {
+				article = prepare_article(article_json_path, article_json_bytes, schema_map, opts)
+				article.Bytes = len(article_json_bytes)
+				if opts.Hash != "" {
+					article.Hash = content_hash(opts.Hash, article_json_bytes)
+				}
+				if opts.ResultCache != nil {
+					article.cache_key = content_hash("sha256", article_json_bytes)
+				}
+			}
+			send_article(article_chan, article, opts)
+		}
+	}()
+	return article_chan, func() error {
+		wg.Wait()
+		return pop_err
+	}
+}
+
+// an `io.Writer` that pushes each write onto the end of the Redis list `list` (RPUSH), less any trailing newline.
+// a `json.Encoder` writes each value it encodes in a single write.
+// unlike a published message a pushed result is kept until it's read, whether or not anything is listening.
+type redis_pusher struct {
+	client *redis_client
+	list   string
+}
+
+func (p redis_pusher) Write(b []byte) (int, error) {
+	_, err := p.client.command("RPUSH", p.list, string(bytes.TrimRight(b, "\n")))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// validates the article-json paths popped from the Redis list `queue` at `addr` as they're pushed,
+// pushing each result as json onto the Redis list `results_list`, until the options' context is done.
+// each path is removed from the processing list of `queue` once its result is pushed, see `start_redis_feeder`.
+// the schemas are compiled once, up front, and used for every article.
+func process_redis_queue(addr string, queue string, results_list string, schema_map map[string]Schema, opts Options) (Summary, error) {
+	queue_client, err := dial_redis(addr)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed connecting to redis: %w", err)
+	}
+	defer queue_client.Close()
+	// the queue client is blocked popping paths so results are pushed with another.
+	results_client, err := dial_redis(addr)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed connecting to redis: %w", err)
+	}
+	defer results_client.Close()
+
+	num_requeued, err := requeue_redis_processing(queue_client, queue)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed requeueing unfinished paths: %w", err)
+	}
+	if num_requeued > 0 {
+		println(fmt.Sprintf("requeued %d article-json paths left unfinished in %s", num_requeued, redis_processing_list(queue)))
+	}
+
+	article_chan, wait_for_feeder := start_redis_feeder(queue_client, queue, schema_map, opts)
+	w := redis_pusher{client: results_client, list: results_list}
+	done := func(article Article) error {
+		_, err := results_client.command("LREM", redis_processing_list(queue), "1", article.FileName)
+		return err
+	}
+	return process_article_stream("redis queue "+queue, article_chan, wait_for_feeder, w, done, schema_map, opts)
+}
+
+// the subset of `*syslog.Writer` used to log results and summaries with a priority.
+type StatusLogger interface {
+	Info(msg string) error
+	Warning(msg string) error
+	Err(msg string) error
+}
+
+// logs the short valid/invalid message for a `result` and any of its warnings to `logger`.
+// invalid results are logged as errors, warnings as warnings and everything else as info.
+func log_status(logger StatusLogger, result Result, tmpl *template.Template) {
+	if result.Success {
+		logger.Info(format_result(result, false, tmpl))
+	} else {
+		logger.Err(format_result(result, false, tmpl))
+	}
+	for _, warning := range result.Warnings {
+		// the priority already marks it as a warning.
+		logger.Warning(warning + ": " + result.FileName)
+	}
+}
+
+// logs the `summary` of a batch to `logger`, as an error if the batch failed.
+func log_summary(logger StatusLogger, summary Summary, fail_on_warnings bool) {
+	if summary.Failed(fail_on_warnings) {
+		logger.Err(summary.String())
+	} else {
+		logger.Info(summary.String())
+	}
+}
+
+// returns a warning if the `result` took `threshold` or longer to validate, valid or not.
+// a document approaching a timeout can be investigated before it reaches it.
+func slow_warning(result Result, threshold time.Duration) (string, bool) {
+	if threshold <= 0 || result.Skipped || result.elapsed_time() < threshold {
+		return "", false
+	}
+	took := format_ms(result.Elapsed)
+	if threshold < time.Millisecond {
+		took = result.elapsed_time().Round(time.Microsecond).String()
+	}
+	return fmt.Sprintf("slow: took %s to validate, over the --slow-threshold of %s", took, threshold), true
+}
+
+// prints the short valid/invalid message for a `result` and any of its warnings,
+// or logs them when `opts.Logger` is set.
+func report_status(result Result, opts Options) {
+	warning, slow := slow_warning(result, opts.SlowThreshold)
+	if opts.Logger != nil {
+		log_status(opts.Logger, result, opts.FormatTemplate)
+		if slow {
+			opts.Logger.Warning(warning + ": " + result.FileName)
+		}
+		return
+	}
+	print_status(result, opts.Color, opts.FormatTemplate)
+	if slow {
+		println("  " + colorise("warning:", ansi_yellow, opts.Color) + " " + warning)
+	}
+}
+
+// prints the short valid/invalid message for a `result` and any of its warnings.
+func print_status(result Result, color bool, tmpl *template.Template) {
+	println(format_result(result, color, tmpl))
+	for _, warning := range result.Warnings {
+		println("  " + colorise("warning:", ansi_yellow, color) + " " + warning)
+	}
+}
+
+// blocks until `prefill` articles are buffered in `article_chan` or the feeder has read every file.
+// a `prefill` of -1 waits for the buffer to fill, 0 doesn't wait at all.
+// returns the number of articles buffered.
+func wait_for_prefill(article_chan chan Article, feeder_wg *sync.WaitGroup, prefill int) int {
+	if prefill == -1 || prefill > cap(article_chan) {
+		prefill = cap(article_chan)
+	}
+	if prefill == 0 {
+		return len(article_chan)
+	}
+	feeder_done := make(chan bool)
+	go func() {
+		feeder_wg.Wait()
+		close(feeder_done)
+	}()
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for len(article_chan) < prefill {
+		select {
+		case <-feeder_done:
+			return len(article_chan)
+		case <-ticker.C:
+		}
+	}
+	return len(article_chan)
+}
+
+// returns `opts` with a context that is cancelled once `opts.MaxFailures` articles have failed,
+// a function to record each result with and a function to release the context with.
+// articles already being validated when the context is cancelled are finished so there may be more failures.
+func with_failure_limit(opts Options) (Options, func(Result), context.CancelFunc) {
+	ctx, cancel := context.WithCancel(opts.ctx())
+	opts.Context = ctx
+	var failures atomic.Int64
+	record_result := func(result Result) {
+		if opts.MaxFailures > 0 && !result.Success && failures.Add(1) >= int64(opts.MaxFailures) {
+			cancel()
+		}
+	}
+	return opts, record_result, cancel
+}
+
+// keep a buffer of `opts.BufferSize` files in memory at once to feed a pool of `opts.NumWorkers`.
+// ensures disk I/O is not a factor in keeping the CPU busy.
+// once `opts.Context` is done the feeder stops reading files, buffered articles are dropped and
+// the results of the articles validated so far are returned.
+func process_files_with_feeder(file_list []string, schema_map map[string]Schema, opts Options) (time.Time, time.Time, []Result) {
+	// process articles from `article_chan` until it's closed.
+
+	worker_pool := pool.NewWithResults[Result]()
+	if opts.NumWorkers >= 1 {
+		worker_pool = worker_pool.WithMaxGoroutines(opts.NumWorkers)
+	}
+	opts, record_result, cancel := with_failure_limit(opts)
+	defer cancel()
+	article_chan, wg := start_feeder(file_list, schema_map, opts)
+	prefilled := wait_for_prefill(article_chan, wg, opts.Prefill)
+	start_time := time.Now()
+	ctx := opts.ctx()
+	// time spent with a free worker but no article to give it.
+	var feeder_wait time.Duration
+	for {
+		wait_start := time.Now()
+		article, ok := receive_article(article_chan, opts)
+		feeder_wait += time.Since(wait_start)
+		if !ok {
+			break
+		}
+		if ctx.Err() != nil {
+			// drain any buffered articles without validating them.
+			continue
+		}
+		worker_pool.Go(func() Result {
+			result := check_article(schema_map, article, opts)
+			record_result(result)
+			if opts.PrintStatus {
+				report_status(result, opts)
+			}
+			if opts.ResultChan != nil {
+				opts.ResultChan <- result
+			}
+			return result
+		})
+	}
+
+	wg.Wait()
+	result_list := worker_pool.Wait()
+	end_time := time.Now()
+	// a large wait means the feeder is the bottleneck, not validation.
+	opts.Timer.note(fmt.Sprintf("feeder: %d articles buffered before validation started, workers waited %s for articles to be read",
+		prefilled, format_ms(feeder_wait.Milliseconds())))
+	return start_time, end_time, result_list
+}
+
+// returns the `failure_list` with their validation errors.
+// errors captured during the first pass (`opts.CaptureError`) are used as they are,
+// otherwise the failures are re-validated with their errors captured this time.
+// validation errors are immutable once returned so they are safely captured in parallel.
+func detailed_failures(failure_list []Result, schema_map map[string]Schema, opts Options) []Result {
+	if opts.CaptureError {
+		return failure_list
+	}
+	file_list := []string{}
+	for _, result := range failure_list {
+		// filenames are relative to `opts.RelativeTo` but files must be read from where they are.
+		// a document in a list of documents is re-validated with the rest of the list.
+		file, _, _ := split_document_index(result.FileName)
+		file = absolute_file_name(opts.RelativeTo, file)
+		if !slices.Contains(file_list, file) {
+			file_list = append(file_list, file)
+		}
+	}
+
+	opts.CaptureError = true
+	opts.PrintStatus = false
+	opts.MaxFailures = 0
+	// already counted during the first pass.
+	opts.Coverage = nil
+	_, _, revalidated_list := process_files_with_feeder(file_list, schema_map, opts)
+	// results complete in any order, show them in the order they first failed.
+	return order_results(revalidated_list, failure_list)
+}
+
+// how often the number of workers is reconsidered with --auto-workers.
+const auto_workers_interval = 250 * time.Millisecond
+
+// decides the next number of workers from the current number of `workers`,
+// the direction of the last change (+1, -1 or 0),
+// the number of articles waiting in the buffer (`queue_depth`) and
+// the throughput (articles validated) during this and the previous interval.
+// returns the new number of workers and the direction of the change.
+func next_worker_count(workers int, direction int, queue_depth int, throughput int, last_throughput int, max_workers int) (int, int) {
+	if queue_depth == 0 {
+		// workers are waiting on the feeder, more workers won't help.
+		direction = -1
+	} else if direction == 0 || float64(throughput) > float64(last_throughput)*1.05 {
+		// first change or the last change helped, keep going.
+		if direction == 0 {
+			direction = 1
+		}
+	} else if float64(throughput) < float64(last_throughput)*0.95 {
+		// the last change hurt, reverse it.
+		direction = -direction
+	} else {
+		// no real difference, hold.
+		return workers, 0
+	}
+	workers = min(max(workers+direction, 1), max_workers)
+	return workers, direction
+}
+
+// same as `process_files_with_feeder` but the number of workers starts at the number of CPUs and
+// is adjusted up and down as the batch progresses based on the queue depth and throughput.
+// returns the final number of workers as well.
+func process_files_with_auto_workers(file_list []string, schema_map map[string]Schema, opts Options) (time.Time, time.Time, []Result, int) {
+	opts, record_result, cancel := with_failure_limit(opts)
+	defer cancel()
+	article_chan, feeder_wg := start_feeder(file_list, schema_map, opts)
+	wait_for_prefill(article_chan, feeder_wg, opts.Prefill)
+
+	mu := sync.Mutex{}
+	result_list := []Result{}
+	target := runtime.NumCPU()
+	max_workers := runtime.NumCPU() * 4
+	active := 0
+	var completed atomic.Int64
+
+	ctx := opts.ctx()
+	worker_wg := sync.WaitGroup{}
+	// set once a worker finds there are no more articles to validate. no more workers are spawned after that,
+	// so `worker_wg.Add` is never called once the count may have reached zero and `worker_wg.Wait` returned.
+	stopped := false
+	stop := func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}
+	worker := func() {
+		defer worker_wg.Done()
+		for {
+			if ctx.Err() != nil {
+				stop()
+				return
+			}
+			mu.Lock()
+			if active > target {
+				// scaled down, this worker retires.
+				active--
+				mu.Unlock()
+				return
+			}
+			mu.Unlock()
+
+			article, ok := receive_article(article_chan, opts)
+			if !ok {
+				stop()
+				return
+			}
+			result := check_article(schema_map, article, opts)
+			record_result(result)
+			if opts.PrintStatus {
+				report_status(result, opts)
+			}
+			if opts.ResultChan != nil {
+				opts.ResultChan <- result
+			}
+			mu.Lock()
+			result_list = append(result_list, result)
+			mu.Unlock()
+			completed.Add(1)
+		}
+	}
+	// called with `mu` held.
+	spawn := func(n int) {
+		if stopped {
+			return
+		}
+		for i := 0; i < n; i++ {
+			worker_wg.Add(1)
+			active++
+			go worker()
+		}
+	}
+
+	start_time := time.Now()
+	mu.Lock()
+	spawn(target)
+	mu.Unlock()
+
+	done_chan := make(chan bool)
+	go func() {
+		worker_wg.Wait()
+		close(done_chan)
+	}()
+
+	ticker := time.NewTicker(auto_workers_interval)
+	defer ticker.Stop()
+	direction := 0
+	last_throughput := 0
+	for running := true; running; {
+		select {
+		case <-done_chan:
+			running = false
+		case <-ticker.C:
+			throughput := int(completed.Swap(0))
+			mu.Lock()
+			target, direction = next_worker_count(target, direction, len(article_chan), throughput, last_throughput, max_workers)
+			if active < target {
+				spawn(target - active)
+			}
+			mu.Unlock()
+			last_throughput = throughput
+		}
+	}
+
+	feeder_wg.Wait()
+	end_time := time.Now()
+	return start_time, end_time, result_list, target
+}
+
+// exit code when the --deadline is exceeded before all article-json files were validated.
+const exit_deadline_exceeded = 3
+
+// exit code when a batch is aborted after --max-errors-total failures.
+const exit_max_errors_total = 4
+
+// exit code when --fail-on-modification is given and a schema was patched or an article-json file was rewritten.
+const exit_modified = 5
+
+// returns a list of up to `sample_size` article-json files in the directory `input_path`.
+// `input_path` may also be an S3 prefix, "s3://bucket/prefix/".
+// a `sample_size` of -1 returns all article-json files.
+func list_files(input_path string, sample_size int, ext_list []string) []string {
+	file_list, err := find_files(input_path, sample_size, ext_list)
+	panic_on_err(err, "listing article-json files in: "+input_path)
+	return file_list
+}
+
+// same as `list_files` but an error listing `input_path` is returned rather than panicking.
+func find_files(input_path string, sample_size int, ext_list []string) ([]string, error) {
+	name_list := []string{}
+	if is_s3_url(input_path) {
+		var err error
+		name_list, err = list_s3_objects(input_path)
+		if err != nil {
+			return nil, fmt.Errorf("listing objects under prefix: %w", err)
+		}
+	} else {
+		path_list, err := os.ReadDir(input_path)
+		if err != nil {
+			return nil, fmt.Errorf("reading contents of directory: %w", err)
+		}
+		for _, path := range path_list {
+			// remove any directories
+			if !path.IsDir() {
+				name_list = append(name_list, path.Name())
+			}
+		}
+	}
+
+	// sort files by filename, numerically, lowest to highest (asc).
+	// order of file listings is never guaranteed so sort before we take a sample.
+	// note! filename output happens in parallel so progress may *appear* unordered.
+	slices.Sort(name_list)
+
+	file_list := []string{}
+	for _, name := range name_list {
+		// remove any non-json files
+		if !is_article_json_file(name, ext_list) {
+			continue
+		}
+
+		if is_s3_url(input_path) {
+			file_list = append(file_list, input_path+name)
+		} else {
+			file_list = append(file_list, filepath.Join(input_path, name))
+		}
+	}
+
+	// reverse the list (desc) so we do a natural 'count down' to the lowest article.
+	slices.Reverse(file_list)
+
+	// the sample is taken from the article-json files only, so other files don't reduce it.
+	return take_sample(file_list, sample_size), nil
+}
+
+// returns the `sample_size` lowest files of a `file_list` ordered like `find_files`, highest to lowest.
+// a `sample_size` of -1 returns all of them.
+func take_sample(file_list []string, sample_size int) []string {
+	if sample_size == -1 || sample_size >= len(file_list) {
+		return file_list
+	}
+	return file_list[len(file_list)-sample_size:]
+}
+
+// an editorial rule that can't be expressed in the schema.
+// returns a warning for each problem found in the `article`, validated against `schema`.
+type ExtraCheck func(article Article, schema *jsonschema.Schema) []string
+
+// returns an `ExtraCheck` for a `check` that only needs the unmarshalled article data.
+func data_check(check func(article interface{}) []string) ExtraCheck {
+	return func(article Article, _ *jsonschema.Schema) []string {
+		return check(article.Data)
+	}
+}
+
+// returns an `ExtraCheck` for a `check` that needs the article json as it was read, see `Article.raw`.
+func raw_check(check func(article_json []byte) []string) ExtraCheck {
+	return func(article Article, _ *jsonschema.Schema) []string {
+		return check(article.raw)
+	}
+}
+
+// returns an `ExtraCheck` for a `check` that doesn't need the schema.
+func article_check(check func(article Article) []string) ExtraCheck {
+	return func(article Article, _ *jsonschema.Schema) []string {
+		return check(article)
+	}
+}
+
+// checks that can be enabled with --extra-checks, by name.
+var extra_check_map = map[string]ExtraCheck{
+	"label-sequence": raw_check(check_label_sequence),
+	"affiliations":   data_check(check_affiliations),
+	"date-order":     data_check(check_date_order),
+	"filename-id":    article_check(check_filename_id),
+	"deprecated":     check_deprecated,
+}
+
+// names of the checks in `extra_check_map` whose warnings depend on the filename of an article rather than its content.
+var filename_check_list = []string{"filename-id"}
+
+// returns the checks in `check_list` that are in `filename_check_list`.
+func filename_checks(check_list []string) []string {
+	name_list := []string{}
+	for _, name := range check_list {
+		if slices.Contains(filename_check_list, name) {
+			name_list = append(name_list, name)
+		}
+	}
+	return name_list
+}
+
+// returns the sorted names of the checks in `extra_check_map`.
+func extra_check_names() []string {
+	name_list := []string{}
+	for name := range extra_check_map {
+		name_list = append(name_list, name)
+	}
+	slices.Sort(name_list)
+	return name_list
+}
+
+// runs each of the named checks in `check_list` against the `article` and the `schema` it was validated against,
+// returning their warnings prefixed with the name of the check.
+// articles that failed to be read or were skipped are not checked.
+func run_extra_checks(check_list []string, article Article, schema *jsonschema.Schema) []string {
+	if article.Error != nil || article.Skipped || article.Data == nil {
+		return nil
+	}
+	warning_list := []string{}
+	for _, name := range check_list {
+		for _, warning := range extra_check_map[name](article, schema) {
+			warning_list = append(warning_list, name+": "+warning)
+		}
+	}
+	return warning_list
+}
+
+// the custom keyword marking a schema as deprecated, `true` or a message saying what to use instead.
+// fields are deprecated in a schema before they're removed so the articles using them can be migrated.
+const x_deprecated = "x-deprecated"
+
+// the schema for `x_deprecated` values.
+var x_deprecated_meta = jsonschema.MustCompileString("x-deprecated.json", `{"properties": {"x-deprecated": {"type": ["boolean", "string"]}}}`)
+
+// compiles the `x_deprecated` keyword so it's available on compiled schemas, see `check_deprecated`.
+type x_deprecated_compiler struct{}
+
+func (x_deprecated_compiler) Compile(_ jsonschema.CompilerContext, m map[string]interface{}) (jsonschema.ExtSchema, error) {
+	switch value := m[x_deprecated].(type) {
+	case string:
+		return x_deprecated_schema{message: value}, nil
+	case bool:
+		if value {
+			return x_deprecated_schema{}, nil
+		}
+	}
+	return nil, nil
+}
+
+// a compiled `x_deprecated` keyword. it's an annotation and never fails validation.
+type x_deprecated_schema struct {
+	message string
+}
+
+func (x_deprecated_schema) Validate(_ jsonschema.ValidationContext, _ interface{}) error {
+	return nil
+}
+
+// returns a warning for each value in the `article` whose schema within `schema` is marked `x_deprecated`.
+// warnings are sorted by location.
+func check_deprecated(article Article, schema *jsonschema.Schema) []string {
+	warning_list := []string{}
+	var walk func(schema_list []*jsonschema.Schema, value interface{}, location string)
+	walk = func(schema_list []*jsonschema.Schema, value interface{}, location string) {
+		applicable_list := []*jsonschema.Schema{}
+		for _, schema := range schema_list {
+			applicable_list = append(applicable_list, applicable_schemas(schema, value)...)
+		}
+		for _, schema := range applicable_list {
+			if deprecated, is_deprecated := schema.Extensions[x_deprecated].(x_deprecated_schema); is_deprecated {
+				warning := fmt.Sprintf("'%s' is deprecated", location)
+				if deprecated.message != "" {
+					warning += ": " + deprecated.message
+				}
+				warning_list = append(warning_list, warning)
+				break
+			}
+		}
+		switch val := value.(type) {
+		case map[string]interface{}:
+			for key, child := range val {
+				child_schema_list, _ := property_schemas(applicable_list, key)
+				walk(child_schema_list, child, location+"/"+escape_json_pointer(key))
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(item_schemas(applicable_list, i), child, location+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	if schema != nil {
+		walk([]*jsonschema.Schema{schema}, article.Data, "")
+	}
+	slices.Sort(warning_list)
+	return warning_list
+}
+
+// a numbered label, like "Figure 2", "Table 1" or "Figure 1—figure supplement 3".
+// the prefix before the number groups the labels that are numbered together.
+var numbered_label_regex = regexp.MustCompile(`^(.+) (\d+)$`)
+
+type numbered_label struct {
+	Label    string
+	Prefix   string
+	Number   int
+	Location string // json pointer to the object with the label
+}
+
+// returns the numbered labels in the json `data` in document order with their locations relative to `location`.
+// the raw json is walked rather than the unmarshalled article as unmarshalling doesn't preserve the order of keys.
+func find_numbered_labels(data gjson.Result, location string) []numbered_label {
+	label_list := []numbered_label{}
+	if data.IsObject() {
+		if label := data.Get("label"); label.Type == gjson.String {
+			match := numbered_label_regex.FindStringSubmatch(strings.TrimSpace(label.String()))
+			if match != nil {
+				number, err := strconv.Atoi(match[2])
+				if err == nil {
+					label_list = append(label_list, numbered_label{match[0], match[1], number, location})
+				}
+			}
+		}
+		data.ForEach(func(key, value gjson.Result) bool {
+			label_list = append(label_list, find_numbered_labels(value, location+"/"+escape_json_pointer(key.String()))...)
+			return true
+		})
+	} else if data.IsArray() {
+		i := 0
+		data.ForEach(func(_, item gjson.Result) bool {
+			label_list = append(label_list, find_numbered_labels(item, location+"/"+strconv.Itoa(i))...)
+			i++
+			return true
+		})
+	}
+	return label_list
+}
+
+// checks that labelled assets are numbered from 1 without gaps or duplicates, for example
+// "Figure 1", "Figure 2", "Figure 3".
+func check_label_sequence(article_json []byte) []string {
+	warning_list := []string{}
+	next_number_map := map[string]int{}             // prefix => next expected number
+	seen_map := map[string]map[int]numbered_label{} // prefix => number => first label with that number
+	for _, label := range find_numbered_labels(gjson.ParseBytes(article_json), "") {
+		if _, present := seen_map[label.Prefix]; !present {
+			seen_map[label.Prefix] = map[int]numbered_label{}
+			next_number_map[label.Prefix] = 1
+		}
+		if first, present := seen_map[label.Prefix][label.Number]; present {
+			warning_list = append(warning_list, fmt.Sprintf("duplicate label '%s' at '%s', first seen at '%s'", label.Label, label.Location, first.Location))
+			continue
+		}
+		seen_map[label.Prefix][label.Number] = label
+		expected := next_number_map[label.Prefix]
+		if label.Number != expected {
+			warning_list = append(warning_list, fmt.Sprintf("label '%s' at '%s' is out of sequence, expected '%s %d'", label.Label, label.Location, label.Prefix, expected))
+		}
+		// continue the sequence from this label so a single gap is only reported once.
+		next_number_map[label.Prefix] = label.Number + 1
+	}
+	return warning_list
+}
+
+// an affiliation referenced by id from an author's 'affiliations'.
+type affiliation_ref struct {
+	Id       string
+	Location string // json pointer to the reference
+}
+
+// returns the affiliations referenced by id in any 'affiliations' list within `data`, with their locations relative to `location`.
+// inline affiliations (objects) are not references and are ignored.
+func find_affiliation_refs(data interface{}, location string) []affiliation_ref {
+	ref_list := []affiliation_ref{}
+	switch val := data.(type) {
+	case map[string]interface{}:
+		key_list := []string{}
+		for key := range val {
+			key_list = append(key_list, key)
+		}
+		slices.Sort(key_list)
+		for _, key := range key_list {
+			key_location := location + "/" + escape_json_pointer(key)
+			if affiliation_list, is_list := val[key].([]interface{}); is_list && key == "affiliations" {
+				for i, affiliation := range affiliation_list {
+					if id, is_string := affiliation.(string); is_string {
+						ref_list = append(ref_list, affiliation_ref{id, key_location + "/" + strconv.Itoa(i)})
+					}
+				}
+				continue
+			}
+			ref_list = append(ref_list, find_affiliation_refs(val[key], key_location)...)
+		}
+	case []interface{}:
+		for i, item := range val {
+			ref_list = append(ref_list, find_affiliation_refs(item, location+"/"+strconv.Itoa(i))...)
+		}
+	}
+	return ref_list
+}
+
+// checks that each affiliation an author references by id, "aff1", is defined in the article's 'affiliations'.
+// authors within groups are checked as well.
+// articles whose authors only have inline affiliations, like those from the bot-lax-adaptor, have nothing to check.
+func check_affiliations(article interface{}) []string {
+	warning_list := []string{}
+	article_map, is_map := article.(map[string]interface{})
+	if !is_map {
+		return warning_list
+	}
+	defined_map := map[string]bool{}
+	if affiliation_list, is_list := article_map["affiliations"].([]interface{}); is_list {
+		for _, affiliation := range affiliation_list {
+			if affiliation_map, is_map := affiliation.(map[string]interface{}); is_map {
+				if id, is_string := affiliation_map["id"].(string); is_string {
+					defined_map[id] = true
+				}
+			}
+		}
+	}
+	for _, ref := range find_affiliation_refs(article_map["authors"], "/authors") {
+		if !defined_map[ref.Id] {
+			warning_list = append(warning_list, fmt.Sprintf("affiliation '%s' at '%s' is not defined", ref.Id, ref.Location))
+		}
+	}
+	return warning_list
+}
+
+// the dates of an article in the order they should happen, earliest first.
+var article_date_order = []string{"received", "accepted", "published", "versionDate"}
+
+// returns the date in the string `value`, either a full timestamp or just a date, "2016-01-02".
+func parse_article_date(value interface{}) (time.Time, bool) {
+	date_string, is_string := value.(string)
+	if !is_string {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		date, err := time.Parse(layout, date_string)
+		if err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checks that the dates in `article_date_order` found in the article or its 'history' are in chronological order,
+// for example an article can't be published before it was accepted.
+// dates that are missing or can't be parsed are not checked, the schema checks their format.
+func check_date_order(article interface{}) []string {
+	warning_list := []string{}
+	article_map, is_map := article.(map[string]interface{})
+	if !is_map {
+		return warning_list
+	}
+	type article_date struct {
+		Name     string
+		Date     time.Time
+		Location string
+	}
+	date_list := []article_date{}
+	history_map, _ := article_map["history"].(map[string]interface{})
+	for _, name := range article_date_order {
+		if date, ok := parse_article_date(article_map[name]); ok {
+			date_list = append(date_list, article_date{name, date, "/" + name})
+		} else if date, ok := parse_article_date(history_map[name]); ok {
+			date_list = append(date_list, article_date{name, date, "/history/" + name})
+		}
+	}
+	// each date is compared to the one before it, a single date out of order is reported once.
+	for i := 1; i < len(date_list); i++ {
+		earlier, later := date_list[i-1], date_list[i]
+		if later.Date.Before(earlier.Date) {
+			warning_list = append(warning_list, fmt.Sprintf("'%s' at '%s' (%s) is before '%s' at '%s' (%s)",
+				later.Name, later.Location, later.Date.Format("2006-01-02"), earlier.Name, earlier.Location, earlier.Date.Format("2006-01-02")))
+		}
+	}
+	return warning_list
+}
+
+// returns true if the directory `input_path` is within a git working tree.
+func is_git_work_tree(input_path string) bool {
+	out, err := exec.Command("git", "-C", input_path, "rev-parse", "--is-inside-work-tree").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// returns the set of files in the git working tree directory `input_path` that have been added or modified since `ref`,
+// including any untracked files.
+func git_changed_files(input_path string, ref string) (map[string]bool, error) {
+	// '--relative' makes paths relative to `input_path` and excludes changes outside of it.
+	diff_out, err := exec.Command("git", "-C", input_path, "diff", "--name-only", "--relative", "--diff-filter=AM", ref, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff against '%s' failed: %w", ref, err)
+	}
+	untracked_out, err := exec.Command("git", "-C", input_path, "ls-files", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing untracked files failed: %w", err)
+	}
+	changed_map := map[string]bool{}
+	for _, line := range strings.Split(string(diff_out)+string(untracked_out), "\n") {
+		if line == "" {
+			continue
+		}
+		changed_map[filepath.Join(input_path, line)] = true
+	}
+	return changed_map, nil
+}
+
+// returns a random `percent` of the files in `file_list`, preserving order.
+// the same `seed` selects the same files from the same `file_list`.
+// at least one file is returned from a non-empty `file_list`.
+func sample_files(file_list []string, percent float64, seed int64) []string {
+	sample_size := int(math.Round(float64(len(file_list)) * percent / 100))
+	sample_size = min(max(sample_size, 1), len(file_list))
+	index_list := rand.New(rand.NewSource(seed)).Perm(len(file_list))[:sample_size]
+	slices.Sort(index_list)
+	sampled_file_list := []string{}
+	for _, i := range index_list {
+		sampled_file_list = append(sampled_file_list, file_list[i])
+	}
+	return sampled_file_list
+}
+
+// returns the files in `file_list` that are present in `keep_map`, preserving order.
+func filter_files(file_list []string, keep_map map[string]bool) []string {
+	filtered_file_list := []string{}
+	for _, file := range file_list {
+		if keep_map[file] {
+			filtered_file_list = append(filtered_file_list, file)
+		}
+	}
+	return filtered_file_list
+}
+
+// matches the article id and version in an article-json filename, "elife-12345-v2.xml.json" => "12345", "2"
+var article_version_regex = regexp.MustCompile(`^elife-(\d+)-v(\d+)\.`)
+
+// returns the article id and version of the article-json `file` parsed from its filename.
+func parse_article_version(file string) (string, int, bool) {
+	match := article_version_regex.FindStringSubmatch(filepath.Base(file))
+	if match == nil {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return match[1], version, true
+}
+
+// checks the article id and version in the filename of the `article` match its 'id' and 'version'.
+// a renamed or swapped file has the content of a different article or version to its filename.
+// filenames that don't follow the "elife-12345-v1.xml.json" convention, like a document in a list, are not checked.
+func check_filename_id(article Article) []string {
+	warning_list := []string{}
+	file_id, file_version, ok := parse_article_version(article.FileName)
+	if !ok {
+		return warning_list
+	}
+	// "09560" and "9560" are the same article.
+	if id := article.ID(); id != "" && strings.TrimLeft(id, "0") != strings.TrimLeft(file_id, "0") {
+		warning_list = append(warning_list, fmt.Sprintf("'/id' is '%s' but the filename has article id '%s'", id, file_id))
+	}
+	if version := article.Version(); version != 0 && version != file_version {
+		warning_list = append(warning_list, fmt.Sprintf("'/version' is %d but the filename has version %d", version, file_version))
+	}
+	return warning_list
+}
+
+// returns a map of article id to the sorted versions of that article for articles in `file_list` with more than one version.
+func multiple_article_versions(file_list []string) map[string][]int {
+	version_map := map[string][]int{}
+	for _, file := range file_list {
+		id, version, ok := parse_article_version(file)
+		if ok {
+			version_map[id] = append(version_map[id], version)
+		}
+	}
+	for id, version_list := range version_map {
+		if len(version_list) < 2 {
+			delete(version_map, id)
+			continue
+		}
+		slices.Sort(version_list)
+	}
+	return version_map
+}
+
+// returns the files in `file_list` with the highest version of each article id, preserving order.
+// files that don't follow the "elife-<id>-v<version>" filename convention are kept.
+func latest_article_versions(file_list []string) []string {
+	latest_map := map[string]int{}
+	for _, file := range file_list {
+		id, version, ok := parse_article_version(file)
+		if ok && version > latest_map[id] {
+			latest_map[id] = version
+		}
+	}
+	filtered_file_list := []string{}
+	for _, file := range file_list {
+		id, version, ok := parse_article_version(file)
+		if !ok || version == latest_map[id] {
+			filtered_file_list = append(filtered_file_list, file)
+		}
+	}
+	return filtered_file_list
+}
+
+// parses a comma separated list of article ids, "12345,09560".
+// leading zeros are removed so "09560" and "9560" are the same article.
+func parse_article_id_list(s string) ([]string, error) {
+	id_list := []string{}
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("article id is not a number: %s", id)
+		}
+		id_list = append(id_list, strings.TrimLeft(id, "0"))
+	}
+	if len(id_list) == 0 {
+		return nil, errors.New("no article ids given")
+	}
+	return id_list, nil
+}
+
+// returns the files in `file_list` whose article id is in `id_list`, preserving order.
+// the id is parsed from the filename, see `parse_article_version`.
+// files that don't follow the "elife-<id>-v<version>" filename convention are dropped.
+func filter_article_ids(file_list []string, id_list []string) []string {
+	filtered_file_list := []string{}
+	for _, file := range file_list {
+		id, _, ok := parse_article_version(file)
+		if ok && slices.Contains(id_list, strings.TrimLeft(id, "0")) {
+			filtered_file_list = append(filtered_file_list, file)
+		}
+	}
+	return filtered_file_list
+}
+
+// returns the ids in `id_list` that no file in `file_list` has, preserving order.
+func missing_article_ids(file_list []string, id_list []string) []string {
+	found_map := map[string]bool{}
+	for _, file := range file_list {
+		if id, _, ok := parse_article_version(file); ok {
+			found_map[strings.TrimLeft(id, "0")] = true
+		}
+	}
+	missing_id_list := []string{}
+	for _, id := range id_list {
+		if !found_map[id] {
+			missing_id_list = append(missing_id_list, id)
+		}
+	}
+	return missing_id_list
+}
+
+// returns the mean and sample standard deviation of `value_list`.
+func mean_stddev(value_list []float64) (float64, float64) {
+	if len(value_list) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, val := range value_list {
+		sum += val
+	}
+	mean := sum / float64(len(value_list))
+	if len(value_list) == 1 {
+		return mean, 0
+	}
+	var sq_diff_sum float64
+	for _, val := range value_list {
+		sq_diff_sum += (val - mean) * (val - mean)
+	}
+	return mean, math.Sqrt(sq_diff_sum / float64(len(value_list)-1))
+}
+
+// the performance of a batch, as saved with --write-perf-baseline and compared with --perf-baseline.
+type PerfNumbers struct {
+	Throughput float64 `json:"throughput"` // articles per second of wall-time
+	P99Ms      int64   `json:"p99_ms"`     // 99th percentile of article validation time
+}
+
+// returns the nearest-rank `percentile` (0-100) of the validation times in `result_list`.
+func percentile_elapsed(result_list []Result, percentile float64) int64 {
+	if len(result_list) == 0 {
+		return 0
+	}
+	elapsed_list := []int64{}
+	for _, result := range result_list {
+		elapsed_list = append(elapsed_list, result.Elapsed)
+	}
+	slices.Sort(elapsed_list)
+	rank := int(math.Ceil(percentile / 100 * float64(len(elapsed_list))))
+	if rank < 1 {
+		rank = 1
+	}
+	return elapsed_list[rank-1]
+}
+
+// returns the throughput and p99 of a batch.
+func perf_numbers(start_time time.Time, end_time time.Time, result_list []Result) PerfNumbers {
+	perf := PerfNumbers{P99Ms: percentile_elapsed(result_list, 99)}
+	wall_time := end_time.Sub(start_time).Seconds()
+	if wall_time > 0 {
+		perf.Throughput = float64(len(result_list)) / wall_time
+	}
+	return perf
+}
+
+func read_perf_baseline(baseline_path string) (PerfNumbers, error) {
+	baseline := PerfNumbers{}
+	baseline_bytes, err := os.ReadFile(baseline_path)
+	if err != nil {
+		return baseline, err
+	}
+	err = json.Unmarshal(baseline_bytes, &baseline)
+	return baseline, err
+}
+
+func write_perf_baseline(baseline_path string, perf PerfNumbers) error {
+	perf_bytes, err := json.MarshalIndent(perf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(baseline_path, append(perf_bytes, '\n'), 0644)
+}
+
+// the smallest increase in the p99 that counts as a regression, however large it is as a percentage.
+// validation times are whole milliseconds, so a fast baseline going from 1ms to 2ms is noise rather than 100% slower.
+const perf_p99_floor_ms = 5
+
+// compares the `current` performance to the `baseline`,
+// returning a message for each number that is worse than the baseline by more than `tolerance` percent.
+// the p99 must also be at least `perf_p99_floor_ms` slower.
+func compare_perf(baseline PerfNumbers, current PerfNumbers, tolerance float64) []string {
+	regression_list := []string{}
+	if current.Throughput < baseline.Throughput*(1-tolerance/100) {
+		regression_list = append(regression_list, fmt.Sprintf("throughput:%.1f/s is more than %.0f%% below the baseline of %.1f/s", current.Throughput, tolerance, baseline.Throughput))
+	}
+	if float64(current.P99Ms) > float64(baseline.P99Ms)*(1+tolerance/100) && current.P99Ms-baseline.P99Ms >= perf_p99_floor_ms {
+		regression_list = append(regression_list, fmt.Sprintf("p99:%dms is more than %.0f%% and at least %dms above the baseline of %dms", current.P99Ms, tolerance, perf_p99_floor_ms, baseline.P99Ms))
+	}
+	return regression_list
+}
+
+// the drafts or schema versions that accept and reject an article, see --probe-drafts and --schema-versions.
+type DraftProbe struct {
+	FileName string
+	Accepted []string
+	Rejected []string
+}
+
+// compiles the schemas in `schema_root` once for each draft in `draft_name_list`,
+// ignoring any '$schema' in the POA and VOR schemas so each draft is actually used.
+// returns a map of draft => schemas and a map of draft => error for drafts the schemas can't be compiled as.
+func compile_draft_schemas(schema_root string, schema_opts SchemaOptions) (map[string]map[string]Schema, map[string]error) {
+	draft_schema_map := map[string]map[string]Schema{}
+	draft_error_map := map[string]error{}
+	for _, name := range draft_name_list {
+		schema_opts.Draft = draft_map[name]
+		schema_opts.ForceDraft = true
+		schema_map, err := configure_validator(schema_root, schema_opts)
+		if err != nil {
+			draft_error_map[name] = err
+			continue
+		}
+		draft_schema_map[name] = schema_map
+	}
+	return draft_schema_map, draft_error_map
+}
+
+// validates each article in `file_list` against the schemas compiled as each draft and
+// prints which drafts accept it, then how many articles each draft accepted.
+// articles accepted by some drafts and not others point to draft-sensitive constructs in the schemas.
+func do_probe_drafts(file_list []string, schema_map map[string]Schema, draft_schema_map map[string]map[string]Schema, draft_error_map map[string]error, opts Options) {
+	// "draft 4: accepted 9 of 10"
+	label := func(name string) string {
+		return "draft " + name
+	}
+	do_probe(file_list, schema_map, draft_schema_map, draft_error_map, draft_name_list, "draft", label, opts)
+}
+
+// supported --schema-versions values.
+// 'latest' is the highest version of each schema, 'prev' is the version below it.
+var schema_version_name_list = []string{"latest", "prev"}
+
+// compiles the schemas in `schema_root` once for each of the schema versions in `name_list`.
+// returns a map of version name => schemas and a map of version name => error for versions that can't be compiled,
+// like 'prev' when a schema only has the one version.
+func compile_version_schemas(schema_root string, schema_opts SchemaOptions, name_list []string) (map[string]map[string]Schema, map[string]error) {
+	version_schema_map := map[string]map[string]Schema{}
+	version_error_map := map[string]error{}
+	for _, name := range name_list {
+		schema_opts.Previous = name == "prev"
+		schema_map, err := configure_validator(schema_root, schema_opts)
+		if err != nil {
+			version_error_map[name] = err
+			continue
+		}
+		version_schema_map[name] = schema_map
+	}
+	return version_schema_map, version_error_map
+}
+
+// returns the version of each schema in `schema_map`, "POA v3, VOR v7".
+func schema_map_versions(schema_map map[string]Schema) string {
+	label_list := []string{}
+	for label := range schema_map {
+		label_list = append(label_list, label)
+	}
+	slices.Sort(label_list)
+	version_list := []string{}
+	for _, label := range label_list {
+		version, ok := parse_schema_version(schema_map[label].Path)
+		if !ok {
+			version_list = append(version_list, label+" unversioned")
+			continue
+		}
+		version_list = append(version_list, fmt.Sprintf("%s v%d", label, version))
+	}
+	return strings.Join(version_list, ", ")
+}
+
+// validates the `article` against each set of schemas in `named_schema_map` named in `name_list`, in that order,
+// for example the schemas compiled as each draft or each schema version.
+// names without schemas, that failed to compile, are skipped.
+func probe_schemas(article Article, named_schema_map map[string]map[string]Schema, name_list []string, opts Options) DraftProbe {
+	probe := DraftProbe{
+		FileName: relative_file_name(opts.RelativeTo, article.FileName),
+		Accepted: []string{},
+		Rejected: []string{},
+	}
+	for _, name := range name_list {
+		schema_map, present := named_schema_map[name]
+		if !present {
+			continue
+		}
+		if check_article(schema_map, article, opts).Success {
+			probe.Accepted = append(probe.Accepted, name)
+		} else {
+			probe.Rejected = append(probe.Rejected, name)
+		}
+	}
+	return probe
+}
+
+// validates each article in `file_list` against each set of schemas in `named_schema_map` named in `name_list` and
+// prints which accept it, then how many articles each accepted.
+// the sets that failed to compile, in `named_error_map`, are printed first.
+// `kind` is what a set of schemas is called, "draft", and `label` returns what a set is called in the totals.
+func do_probe(file_list []string, schema_map map[string]Schema, named_schema_map map[string]map[string]Schema, named_error_map map[string]error, name_list []string, kind string, label func(name string) string, opts Options) {
+	for _, name := range name_list {
+		if err, present := named_error_map[name]; present {
+			println(fmt.Sprintf("%s %s: failed to compile schemas: %v", kind, name, err))
+		}
+	}
+	opts.CaptureError = false
+	opts.Coverage = nil
+	accepted_map := map[string]int{}
+	num_articles := 0
+	num_differ := 0
+	for _, file := range file_list {
+		for _, article := range read_articles(file, schema_map, opts) {
+			probe := probe_schemas(article, named_schema_map, name_list, opts)
+			num_articles++
+			for _, name := range probe.Accepted {
+				accepted_map[name]++
+			}
+			if len(probe.Accepted) > 0 && len(probe.Rejected) > 0 {
+				num_differ++
+			}
+			// "elife-09560-v1.xml.json: accepted by 4, 6, 7, rejected by 2019-09, 2020-12"
+			fmt.Printf("%s: accepted by %s, rejected by %s\n", probe.FileName, join_or_none(probe.Accepted), join_or_none(probe.Rejected))
+		}
+	}
+	fmt.Println()
+	for _, name := range name_list {
+		if _, present := named_schema_map[name]; present {
+			fmt.Printf("%s: accepted %d of %d\n", label(name), accepted_map[name], num_articles)
+		}
+	}
+	fmt.Printf("%d of %d articles are accepted by some %ss and rejected by others\n", num_differ, num_articles, kind)
+}
+
+// validates each article in `file_list` against each of the schema versions in `name_list` and
+// prints which versions accept it, then how many articles each version accepted.
+// articles rejected by 'latest' but accepted by 'prev' break on the newest schemas,
+// articles accepted by 'latest' but rejected by 'prev' would break if the schemas were rolled back.
+func do_schema_versions(file_list []string, schema_map map[string]Schema, version_schema_map map[string]map[string]Schema, version_error_map map[string]error, name_list []string, opts Options) {
+	// "latest (POA v3, VOR v7): accepted 9 of 10"
+	label := func(name string) string {
+		return fmt.Sprintf("%s (%s)", name, schema_map_versions(version_schema_map[name]))
+	}
+	do_probe(file_list, schema_map, version_schema_map, version_error_map, name_list, "schema version", label, opts)
+}
+
+// returns the items of `item_list` joined with commas, or "none" if there aren't any.
+func join_or_none(item_list []string) string {
+	if len(item_list) == 0 {
+		return "none"
+	}
+	return strings.Join(item_list, ", ")
+}
+
+// validates `file_list` `passes` times, discarding the first pass as a warmup,
+// and prints the mean and standard deviation of the throughput of the remaining passes.
+func do_bench(passes int, file_list []string, schema_map map[string]Schema, opts Options) {
+	opts.CaptureError = false
+	opts.PrintStatus = false
+	throughput_list := []float64{}
+	for i := 1; i <= passes; i++ {
+		start_time, end_time, result_list := process_files_with_feeder(file_list, schema_map, opts)
+		summary := summarise(result_list, opts.NumWorkers, start_time, end_time)
+		// articles per second
+		throughput := float64(summary.Articles) / end_time.Sub(start_time).Seconds()
+		label := ""
+		if i == 1 {
+			label = " (warmup, discarded)"
+		} else {
+			throughput_list = append(throughput_list, throughput)
+		}
+		// "pass 2 of 5: articles:10, failures:0, ..., throughput:21.7/s"
+		println(fmt.Sprintf("pass %d of %d: %s, throughput:%.1f/s%s", i, passes, summary.String(), throughput, label))
+	}
+	mean, stddev := mean_stddev(throughput_list)
+	println("")
+	println(fmt.Sprintf("passes:%d, articles:%d, workers:%d, mean:%.1f/s, stddev:%.1f/s", len(throughput_list), len(file_list), opts.NumWorkers, mean, stddev))
+}
+
+func do() {
+	// `validate-article-json bench --passes 5 ...`
+	bench_mode := len(os.Args) > 1 && os.Args[1] == "bench"
+	arg_list := os.Args[1:]
+	if bench_mode {
+		arg_list = os.Args[2:]
+	}
+
+	schema_root_ptr := flag.String("schema-root", "", "path to api-raml schema root or a zip archive of it, locally or in S3")
+	compare_report_ptr := flag.String("compare-report", "", "path to an old json report to compare to a new json report given as the last argument, for example:\n--compare-report old.json new.json")
+	ref_map := ref_map_flag{}
+	flag.Var(ref_map, "ref-map", "map schema urls starting with a prefix to a local directory, for example:\n--ref-map https://api.elifesciences.org/schemas/=/path/to/schemas/\nmay be given more than once")
+	schema_versions_ptr := flag.String("schema-versions", "", "comma separated list of schema versions to validate each article-json file against and print which versions accept it.\n'latest' is the highest version of each schema, 'prev' the version below it, for example 'latest,prev'")
+	probe_drafts_ptr := flag.Bool("probe-drafts", false, "validate each article-json file against the schemas compiled as each json schema draft, ignoring their '$schema',\nand print which drafts accept it")
+	draft_ptr := flag.String("draft", "4", "json schema draft of schemas without a '$schema', either '4', '6', '7', '2019-09' or '2020-12'")
+	definition_ptr := flag.String("definition", "", "name of a definition within the schemas to validate the article against instead of the whole schema, for example 'book'")
+	input_path_ptr := flag.String("article-json", "", "path to an article-json file or directory, or an S3 object or prefix, 's3://bucket/prefix/'")
+	extra_checks_ptr := flag.String("extra-checks", "", "comma separated list of additional checks to run on each article-json file, reported as warnings.\nsupported checks: "+strings.Join(extra_check_names(), ", "))
+	write_golden_ptr := flag.String("write-golden", "", "path to a directory to write the canonicalized result of each article-json file to, for comparing future runs against")
+	compare_golden_ptr := flag.String("compare-golden", "", "path to a directory of results written by --write-golden to compare the result of each article-json file against.\nthe differences of each result that doesn't match are printed and fail the run")
+	raw_errors_ptr := flag.Bool("raw-errors", false, "print validation errors in the validator's own format, for debugging the validator")
+	perf_baseline_ptr := flag.String("perf-baseline", "", "path to a json file of performance numbers to compare a batch against, failing if it is slower by more than --perf-tolerance")
+	perf_tolerance_ptr := flag.Float64("perf-tolerance", 10, "percent that a batch may be slower than the --perf-baseline")
+	write_perf_baseline_ptr := flag.String("write-perf-baseline", "", "path to write the performance numbers of a batch to, for use with --perf-baseline")
+	no_extract_ptr := flag.Bool("no-extract", false, "validate the whole article-json document rather than its 'article' section, for schemas that describe the entire document.\nthe schema is still selected with --status-field")
+	force_type_ptr := flag.String("force-type", "", "validate every article-json file with the schema of this type, 'POA' or 'VOR', without looking for its status.\nfor partial documents that have no status")
+	status_field_ptr := flag.String("status-field", default_status_field, "path to the field in each article-json file whose uppercased value selects the schema to validate with, for example 'article.type'")
+	relative_to_ptr := flag.String("relative-to", "", "report article-json filenames relative to this directory rather than as they were found")
+	max_errors_total_ptr := flag.Int("max-errors-total", 0, "abort a batch once this many article-json files have failed, exiting with exit code 4. 0 never aborts")
+	poa_buffer_ptr := flag.Int("poa-buffer", 0, "maximum number of POA articles to keep in memory at once, within --buffer-size. 0 is only limited by --buffer-size")
+	vor_buffer_ptr := flag.Int("vor-buffer", 0, "maximum number of VOR articles to keep in memory at once, within --buffer-size. 0 is only limited by --buffer-size")
+	prefill_ptr := flag.Int("prefill", 0, "number of article-json files to read into the buffer before validation starts and the wall-time is measured, up to --buffer-size.\n-1 fills the buffer, 0 starts validating immediately")
+	base64_field_ptr := flag.String("base64-field", "", "path to a field within each file containing the article-json as a base64 encoded string, for example 'message.body'")
+	explain_file_ptr := flag.String("explain-file", "", "path to a single article-json file to validate and print the full validation error of, ignoring --article-json")
+	hash_ptr := flag.String("hash", "", "hash each article-json file as it is read and include the digest in the json report, --sqlite database and --manifest-file, either 'sha256' or 'md5'.\nfiles are hashed by the single goroutine reading files which can lower throughput")
+	log_target_ptr := flag.String("log-target", "stderr", "where to write the valid/invalid message of each article-json file and the summary, either 'stderr' or 'syslog'")
+	report_extra_properties_ptr := flag.Bool("report-extra-properties", false, "report properties of valid article-json files that the schema allows but doesn't declare as warnings")
+	self_test_ptr := flag.Bool("self-test", false, "validate a few built-in known-good and known-bad article-json fixtures against the schemas and exit")
+	latest_version_only_ptr := flag.Bool("latest-version-only", false, "validate only the highest version of each article when a directory has more than one, for example 'elife-12345-v2.xml.json' but not 'elife-12345-v1.xml.json'")
+	result_cache_size_ptr := flag.Int("result-cache-size", 0, "with --ndjson or --jobs-from-queue, cache the results of this many of the most recent article-json documents by content, so a retried document isn't validated again")
+	jobs_from_queue_ptr := flag.Bool("jobs-from-queue", false, "run as a worker, validating the article-json paths popped from the Redis list --redis-queue until interrupted")
+	redis_addr_ptr := flag.String("redis-addr", "127.0.0.1:6379", "address of the Redis server used by --jobs-from-queue")
+	redis_queue_ptr := flag.String("redis-queue", "", "name of the Redis list article-json paths are pushed to (LPUSH), see --jobs-from-queue")
+	redis_results_ptr := flag.String("redis-results", "", "name of the Redis list each result is pushed to as json (RPUSH), see --jobs-from-queue (default \"<redis-queue>:results\")")
+	assert_format_ptr := flag.Bool("assert-format", false, "validate 'format' in schemas compiled as draft 2019-09 or later, where it's otherwise an annotation. earlier drafts always validate it")
+	assert_content_ptr := flag.Bool("assert-content", false, "validate 'contentEncoding' and 'contentMediaType' in schemas compiled as draft 2019-09 or later")
+	raml_ptr := flag.Bool("raml", false, "select the schemas from the article media types declared in the api-raml's api.raml, as the api-raml does, rather than the latest files in 'dist/model'")
+	explain_schema_selection_ptr := flag.Bool("explain-schema-selection", false, "print the schema files matched for each article type, their versions and which one is selected and why, then exit")
+	stdin_ptr := flag.Bool("stdin", false, "read a single article-json document from stdin instead of --article-json")
+	gzip_ptr := flag.Bool("gzip", false, "with --stdin, decompress stdin before validating it. gzipped stdin is detected without this flag")
+	ndjson_ptr := flag.Bool("ndjson", false, "with --stdin, read one article-json document per line and write each result to stdout as a line of json as it's validated")
+	count_ptr := flag.Bool("count", false, "print only the number of valid and invalid article-json files to stdout, separated by a space.\nthe exit code is unchanged")
+	normalize_ptr := flag.Bool("normalize", false, "rewrite each valid article-json file with its keys sorted and indented with two spaces. invalid and gzipped files are left as they are.\nfiles are replaced atomically but are no longer byte-for-byte the same, update any --checksums manifest")
+	schema_overlay_ptr := flag.String("schema-overlay", "", "path to a json schema of additional constraints that articles must also be valid against, added to the 'allOf' of both the POA and VOR schemas")
+	include_raw_ptr := flag.Bool("include-raw", false, "include the 'article' section of each invalid article-json file in json results, so each failure can be looked at without the original file.\nthis can make reports very large")
+	ext_ptr := flag.String("ext", strings.Join(default_ext_list, ","), "comma separated list of filename extensions of article-json files in an --article-json directory.\nfiles ending with '.gz' are decompressed")
+	print_schema_info_ptr := flag.Bool("print-schema-info", false, "print the '$schema' declared by each schema, the draft it was compiled as and any patches applied to it, then exit")
+	fail_on_modification_ptr := flag.Bool("fail-on-modification", false, fmt.Sprintf("exit with %d if a schema was patched beyond the default patches, for example by --schema-overlay,\nor if --normalize rewrote an article-json file. schemas are checked before any files are read", exit_modified))
+	format_template_ptr := flag.String("format-template", "", "print the status of each article-json file using this Go text/template of its result instead, for example:\n--format-template '{{.Type}} {{.FileName}} {{.Elapsed}}'")
+	timing_ptr := flag.Bool("timing", false, "print the time spent in each phase of a batch after its summary: schema discovery, schema compilation, file listing and validation")
+	debug_ptr := flag.Bool("debug", false, "print details useful when debugging a run to stderr, like each change made to a schema before it was compiled")
+	schema_fingerprint_ptr := flag.Bool("schema-fingerprint", false, "print a sha256 digest of the POA and VOR schemas as they are compiled and exit.\nalso in the meta of the json report")
+	ignore_keyword_list := string_list_flag{}
+	flag.Var(&ignore_keyword_list, "ignore-keyword", "ignore validation errors for this json schema keyword, for example 'required'. articles with only ignored errors pass.\nmay be given more than once")
+	summary_only_ptr := flag.Bool("summary-only", false, "print only the summary of a batch, without the status of each article-json file or the errors of failures.\nthe exit code is unchanged")
+	slow_threshold_ptr := flag.Duration("slow-threshold", 0, "print a warning for each article-json file that takes this long or longer to validate, valid or not, for example '500ms'.\nthe warning never fails a run. 0 never warns")
+	group_by_dir_ptr := flag.Bool("group-by-dir", false, "also print the totals for the article-json files in each directory after a batch, included in the json report as 'dirs'")
+	top_slow_ptr := flag.Int("top-slow", 0, "print this many of the slowest article-json files to validate after a batch")
+	deadline_ptr := flag.Duration("deadline", 0, "abort the run if it hasn't finished within this duration, for example '10m'.\nthe summary covers the article-json files validated so far and the exit code is 3")
+	only_ids_ptr := flag.String("only-ids", "", "comma separated list of article ids to validate, for example '12345,09560'.\nthe id is parsed from each filename and other files are skipped")
+	changed_since_ptr := flag.String("changed-since", "", "only validate article-json files added or modified since this git ref, for example 'origin/master'.\nignored if --article-json is not within a git working tree")
+	sample_size_ptr := flag.Int("sample-size", -1, "number of article-json files to parse")
+	require_sample_size_ptr := flag.Bool("require-sample-size", false, "fail if fewer article-json files than --sample-size are found rather than validating the files that are")
+	sample_percent_ptr := flag.Float64("sample-percent", 0, "percent of article-json files to parse, chosen at random. can't be used with --sample-size")
+	sample_seed_ptr := flag.Int64("sample-seed", 0, "seed for choosing the random --sample-percent of files, the same seed chooses the same files.\n0 for a new seed each run, printed so the run can be repeated")
+	num_workers_ptr := flag.Int("num-workers", 0, "number of workers (goroutines) to process the article-json files\n0 for number of cpu cores (default), -1 for unbounded")
+	// 1k articles is about ~1.5GiB of RAM
+	buffer_size_ptr := flag.Int("buffer-size", 1000, "maximum number of article-json files to keep in memory at once")
+	output_dir_ptr := flag.String("output-dir", "", "path to a directory to write an error report to for each invalid article-json file")
+	output_ptr := flag.String("output", "text", "format of the validation results, either 'text', 'json' or 'github'.\n'github' prints an error annotation for github actions for each problem in each invalid file")
+	pretty_ptr := flag.Bool("pretty", false, "indent json output for reading")
+	checksums_ptr := flag.String("checksums", "", "path to a sha256sum manifest to verify each article-json file against before validating")
+	no_fail_ptr := flag.Bool("no-fail", false, "validate and report as usual but always exit with 0, for advisory checks that shouldn't fail a build")
+	fail_on_warnings_ptr := flag.Bool("fail-on-warnings", false, "exit with a failure if any article-json file has warnings")
+	auto_workers_ptr := flag.Bool("auto-workers", false, "experimental. adjust the number of workers as articles are validated, starting at the number of cpu cores.\nignores --num-workers")
+	metrics_file_ptr := flag.String("metrics-file", "", "path to a file to write Prometheus text-format metrics to after validation")
+	coverage_file_ptr := flag.String("coverage-file", "", "path to a file to write a json report to of which schema locations were evaluated and for how many article-json files.\nslows validation down")
+	print0_failures_ptr := flag.Bool("print0-failures", false, "write the path to each article-json file that failed validation to stdout, each terminated by a NUL byte, for 'xargs -0'.\nimplies --summary-only")
+	failures_file_ptr := flag.String("failures-file", "", "path to a file to write the path to each article-json file that failed validation to, one per line.\nthe file is written even when there are no failures")
+	keep_going_ptr := flag.Bool("keep-going", false, "always validate every article-json file in a batch, never aborting early.\na file that can't be read fails like an invalid article and is written to --failures-file.\ncan't be used with --max-errors-total or --deadline")
+	allow_empty_ptr := flag.Bool("allow-empty", false, "skip empty article-json files rather than failing them")
+	// validation errors for very large or very broken articles can be many MiB each.
+	capture_errors_ptr := flag.Bool("capture-errors", false, "keep validation errors from the first pass rather than re-validating failures to show their errors.\nfaster, but every failure's errors are kept in memory until the end")
+	max_file_size_ptr := flag.Int64("max-file-size", 0, "maximum size in bytes of an article-json file before it fails without being read.\n0 for no limit")
+	check_utf8_ptr := flag.Bool("check-utf8", false, "fail article-json files that aren't valid UTF-8 before they're parsed, with the byte offset of the first invalid sequence")
+	max_depth_ptr := flag.Int("max-depth", 1000, "maximum nesting of objects and arrays in an article-json file before it fails without being parsed.\n0 for no limit")
+	output_file_ptr := flag.String("output-file", "", "with --output json, write the json report to this file instead of stdout.\nthe status of each article-json file is still printed to stderr as it's validated")
+	progress_ptr := flag.Bool("progress", false, fmt.Sprintf("print the number of article-json files validated and failed so far to stderr every %s, and once validation is done", progress_interval))
+	result_socket_ptr := flag.String("result-socket", "", "path to a Unix domain socket to write each result to as a line of json as it's validated.\nresults are dropped while nothing is listening")
+	manifest_file_ptr := flag.String("manifest-file", "", "path to a csv file to write a row to for each result as it's validated, with the --hash of each article-json file")
+	sqlite_ptr := flag.String("sqlite", "", "path to a SQLite database to insert each result into as it's validated, creating it as necessary")
+	color_ptr := flag.String("color", "auto", "color the output, either 'auto', 'always' or 'never'.\n'auto' colors output to a terminal unless NO_COLOR is set")
+	passes_ptr := flag.Int("passes", 5, "number of times to validate the article-json files in 'bench' mode.\nthe first pass is a warmup and is discarded")
+	trace_ptr := flag.String("trace", "", "path to write an execution trace of the run to, for viewing with 'go tool trace'")
+	flag.CommandLine.Parse(arg_list)
+
+	if *trace_ptr != "" {
+		start_trace(*trace_ptr)
+	}
+
+	die(*deadline_ptr < 0, "--deadline must be a positive duration")
+	die(*slow_threshold_ptr < 0, "--slow-threshold must be a positive duration")
+	ctx := context.Background()
+	if *deadline_ptr > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline_ptr)
+		defer cancel()
+	}
+
+	output_format := *output_ptr
+	die(!slices.Contains([]string{"text", "json", "github"}, output_format), "--output must be either 'text', 'json' or 'github'")
+
+	pretty := *pretty_ptr
+	die(pretty && output_format != "json", "--pretty can only be used with --output json")
+	output_file := *output_file_ptr
+	die(output_file != "" && output_format != "json", "--output-file can only be used with --output json")
+	die(*progress_ptr && (*ndjson_ptr || *jobs_from_queue_ptr), "--progress can't be used with --ndjson or --jobs-from-queue")
+
+	summary_only := *summary_only_ptr
+	die(summary_only && output_format != "text", "--summary-only can only be used with --output text")
+	die(*include_raw_ptr && output_format != "json" && !*ndjson_ptr, "--include-raw can only be used with --output json or --ndjson")
+	count := *count_ptr
+	die(count && output_format != "text", "--count can only be used with --output text")
+	// the count replaces the summary, everything else is suppressed the same.
+	summary_only = summary_only || count
+	// the paths are the only thing written to stdout, validation errors are suppressed like --summary-only.
+	die(*print0_failures_ptr && (output_format != "text" || count), "--print0-failures can only be used with --output text and can't be used with --count")
+	summary_only = summary_only || *print0_failures_ptr
+
+	if *compare_report_ptr != "" {
+		die(flag.NArg() != 1, "--compare-report requires the path to a new json report as the last argument")
+		do_compare_reports(*compare_report_ptr, flag.Arg(0), output_format, pretty)
+		return
+	}
+
+	schema_root := *schema_root_ptr
+	die(schema_root == "", "--schema-root is required")
+	die(!path_exists(schema_root), "--schema-root path does not exist. it should be a path to the api-raml or a zip archive of it.")
+	if is_s3_url(schema_root) {
+		var err error
+		schema_root, err = fetch_s3_schema_root(schema_root)
+		die(err != nil, err.Error())
+	}
+
+	if *explain_schema_selection_ptr {
+		die(*raml_ptr, "--explain-schema-selection can't be used with --raml")
+		selection_list, err := explain_schema_selection(schema_root)
+		die(err != nil, fmt.Sprintf("failed to select schemas: %v", err))
+		if output_format == "json" {
+			err = new_json_encoder(os.Stdout, pretty).Encode(selection_list)
+			panic_on_err(err, "writing schema selection")
+		} else {
+			print_schema_selection(selection_list)
+		}
+		return
+	}
+
+	schema_opts := SchemaOptions{
+		Definition:    *definition_ptr,
+		RefMap:        ref_map,
+		Raml:          *raml_ptr,
+		AssertFormat:  *assert_format_ptr,
+		AssertContent: *assert_content_ptr,
+	}
+	var timer *phase_timer
+	if *timing_ptr {
+		timer = new_phase_timer()
+		schema_opts.Timer = timer
+	}
+	if *schema_overlay_ptr != "" {
+		die(schema_opts.Definition != "", "--schema-overlay can't be used with --definition")
+		overlay_bytes, err := os.ReadFile(*schema_overlay_ptr)
+		die(err != nil, fmt.Sprintf("failed to read --schema-overlay: %v", err))
+		schema_opts.Overlay = overlay_bytes
+	}
+	draft, present := draft_map[*draft_ptr]
+	die(!present, "--draft must be either '4', '6', '7', '2019-09' or '2020-12'")
+	schema_opts.Draft = draft
+	schema_map, err := configure_validator(schema_root, schema_opts)
+	die(err != nil, fmt.Sprintf("failed to configure validator: %v", err))
+	if *debug_ptr {
+		for _, info := range compiled_schema_info(schema_map) {
+			for _, patch := range info.Patches {
+				println("debug: patched " + patch.String())
+			}
+		}
+	}
+	err = sanity_check_schemas(schema_map)
+	die(err != nil, fmt.Sprintf("schemas failed to validate a trivial article, the validator is misconfigured: %v", err))
+	if *fail_on_modification_ptr {
+		patch_list := requested_patches(schema_map)
+		for _, patch := range patch_list {
+			println("schema patched: " + patch.String())
+		}
+		if len(patch_list) > 0 {
+			println(fmt.Sprintf("failing as %d schema patches were applied (--fail-on-modification)", len(patch_list)))
+			exit(exit_modified)
+		}
+	}
+
+	if *schema_fingerprint_ptr {
+		fmt.Println(schema_fingerprint(schema_map))
+		return
+	}
+
+	if *print_schema_info_ptr {
+		info_list := compiled_schema_info(schema_map)
+		if output_format == "json" {
+			err = new_json_encoder(os.Stdout, pretty).Encode(info_list)
+			panic_on_err(err, "writing schema info")
+		} else {
+			print_compiled_schema_info(info_list)
+		}
+		return
+	}
+
+	if *self_test_ptr {
+		failure_list := self_test(schema_map)
+		for _, failure := range failure_list {
+			println("self-test failed: " + failure)
+		}
+		die(len(failure_list) > 0, fmt.Sprintf("self-test failed for %d of %d fixtures", len(failure_list), len(self_test_fixture_list)))
+		println(fmt.Sprintf("self-test passed for %d fixtures", len(self_test_fixture_list)))
+		return
+	}
+
+	explain_file := *explain_file_ptr
+	input_path := *input_path_ptr
+	if explain_file != "" {
+		die(!path_exists(explain_file) || path_is_dir(explain_file), "--explain-file must be a path to an article-json file")
+	} else if *jobs_from_queue_ptr {
+		die(input_path != "", "--jobs-from-queue can't be used with --article-json")
+		die(*stdin_ptr, "--jobs-from-queue can't be used with --stdin")
+		die(*redis_queue_ptr == "", "--jobs-from-queue requires --redis-queue")
+	} else if *stdin_ptr {
+		die(input_path != "", "--stdin can't be used with --article-json")
+		die(*checksums_ptr != "", "--checksums can't be used with --stdin")
+		die(*normalize_ptr, "--normalize can't be used with --stdin")
+	} else {
+		die(*ndjson_ptr, "--ndjson requires --stdin")
+		die(*gzip_ptr, "--gzip requires --stdin")
+		die(input_path == "", "--article-json is required")
+		die(!path_exists(input_path), "--article-json path does not exist. it should be a path to an article-json file or a directory of article-json files.")
+		die(*changed_since_ptr != "" && !path_is_dir(input_path), "--changed-since requires --article-json to be a directory")
+		die(*only_ids_ptr != "" && !path_is_dir(input_path), "--only-ids requires --article-json to be a directory")
+		die(is_s3_url(input_path) && *normalize_ptr, "--normalize can't be used with an S3 --article-json")
+	}
+
+	var only_id_list []string
+	if *only_ids_ptr != "" {
+		only_id_list, err = parse_article_id_list(*only_ids_ptr)
+		die(err != nil, fmt.Sprintf("failed to parse --only-ids: %v", err))
+	}
+
+	ext_list := []string{}
+	for _, ext := range strings.Split(*ext_ptr, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext != "" {
+			ext_list = append(ext_list, ext)
+		}
+	}
+	die(len(ext_list) == 0, "--ext must list at least one filename extension")
+
+	sample_size := *sample_size_ptr
+	die(sample_size < -1 || sample_size == 0, "--sample-size must be -1 or a value greater than 0")
+	sample_percent := *sample_percent_ptr
+	die(sample_percent < 0 || sample_percent > 100, "--sample-percent must be between 0 and 100")
+	die(sample_percent > 0 && sample_size != -1, "--sample-percent can't be used with --sample-size")
+	die(*require_sample_size_ptr && sample_size == -1, "--require-sample-size requires --sample-size")
+	// fails when a directory has fewer article-json files than asked for, when asked to.
+	require_sample_size := func(file_list []string) {
+		die(*require_sample_size_ptr && len(file_list) < sample_size,
+			fmt.Sprintf("only %d of the %d article-json files asked for with --sample-size were found (--require-sample-size)", len(file_list), sample_size))
+	}
+	sample_seed := *sample_seed_ptr
+	if sample_percent > 0 && sample_seed == 0 {
+		sample_seed = time.Now().UnixNano()
+	}
+	// a random percent of the files in `file_list`, when asked for.
+	sample := func(file_list []string) []string {
+		if sample_percent == 0 || len(file_list) == 0 {
+			return file_list
+		}
+		sampled_file_list := sample_files(file_list, sample_percent, sample_seed)
+		println(fmt.Sprintf("sampled %d of %d article-json files (--sample-percent %g --sample-seed %d)", len(sampled_file_list), len(file_list), sample_percent, sample_seed))
+		return sampled_file_list
+	}
+
+	num_workers := *num_workers_ptr
+	die(num_workers < -1, "--num-workers must be -1 or greater")
+	if num_workers == 0 {
+		num_workers = runtime.NumCPU()
+	}
+
+	buffer_size := *buffer_size_ptr
+	die(buffer_size < 1, "--buffer-size must be a positive integer")
+	die(*prefill_ptr < -1, "--prefill must be -1 or greater")
+	die(*poa_buffer_ptr < 0, "--poa-buffer must be 0 or greater")
+	die(*vor_buffer_ptr < 0, "--vor-buffer must be 0 or greater")
+	var article_type_buffer *type_buffer
+	if *poa_buffer_ptr > 0 || *vor_buffer_ptr > 0 {
+		// the buffer may never fill when one type of article is limited.
+		die(*prefill_ptr != 0, "--prefill can't be used with --poa-buffer or --vor-buffer")
+		article_type_buffer = new_type_buffer(map[string]int{"POA": *poa_buffer_ptr, "VOR": *vor_buffer_ptr})
+	}
+	die(*max_errors_total_ptr < 0, "--max-errors-total must be 0 or greater")
+	die(*keep_going_ptr && *max_errors_total_ptr > 0, "--keep-going can't be used with --max-errors-total")
+	die(*keep_going_ptr && *deadline_ptr > 0, "--keep-going can't be used with --deadline")
+	die(*perf_tolerance_ptr < 0, "--perf-tolerance must be 0 or greater")
+	var perf_baseline PerfNumbers
+	if *perf_baseline_ptr != "" {
+		perf_baseline, err = read_perf_baseline(*perf_baseline_ptr)
+		die(err != nil, fmt.Sprintf("failed to read --perf-baseline: %v", err))
+	}
+
+	die(*max_depth_ptr < 0, "--max-depth must be 0 or greater")
+	die(*max_file_size_ptr < 0, "--max-file-size must be 0 or greater")
+	die(*top_slow_ptr < 0, "--top-slow must be 0 or greater")
 
-	// validate!
-	elapsed, err := validate(schema, article.Data)
+	output_dir := *output_dir_ptr
+	if output_dir != "" {
+		err = os.MkdirAll(output_dir, 0755)
+		die(err != nil, fmt.Sprintf("failed to create --output-dir: %v", err))
+	}
 
-	r := Result{
-		Type:     article.Type, // POA or VOR
-		FileName: article.FileName,
-		Elapsed:  elapsed.Milliseconds(),
-		Success:  err == nil,
+	golden_dir := *write_golden_ptr
+	if golden_dir != "" {
+		err = os.MkdirAll(golden_dir, 0755)
+		die(err != nil, fmt.Sprintf("failed to create --write-golden directory: %v", err))
 	}
+	compare_golden_dir := *compare_golden_ptr
+	die(compare_golden_dir != "" && golden_dir != "", "--compare-golden can't be used with --write-golden")
+	die(compare_golden_dir != "" && !path_is_dir(compare_golden_dir), "--compare-golden must be a directory")
 
-	if capture_error && err != nil {
-		r.Error = err
+	fail_on_warnings := *fail_on_warnings_ptr
+	raw_errors := *raw_errors_ptr
+	capture_errors := *capture_errors_ptr
+	metrics_file := *metrics_file_ptr
+	failures_file := *failures_file_ptr
+
+	checksum_map := map[string]string{}
+	if *checksums_ptr != "" {
+		checksum_map, err = read_checksum_manifest(*checksums_ptr)
+		die(err != nil, fmt.Sprintf("failed to read --checksums manifest: %v", err))
+		die(len(checksum_map) == 0, "--checksums manifest is empty")
 	}
 
-	return r
-}
+	color_mode := *color_ptr
+	die(!slices.Contains([]string{"auto", "always", "never"}, color_mode), "--color must be either 'auto', 'always' or 'never'")
+	// status messages are written to stderr, validation errors to stdout.
+	color_stderr := use_color(color_mode, os.Stderr)
+	color_stdout := use_color(color_mode, os.Stdout)
 
-func format_ms(ms int64) string {
-	elapsed_str := fmt.Sprintf("%dms", ms)
-	if ms >= 60000 {
-		// minutes
-		elapsed_str = fmt.Sprintf("%dm", (ms/1000)/60)
+	extra_check_list := []string{}
+	if *extra_checks_ptr != "" {
+		for _, name := range strings.Split(*extra_checks_ptr, ",") {
+			name = strings.TrimSpace(name)
+			_, present := extra_check_map[name]
+			die(!present, fmt.Sprintf("unknown --extra-checks check: %s", name))
+			extra_check_list = append(extra_check_list, name)
+		}
+	}
 
-	} else if ms >= 1000 {
-		// seconds
-		elapsed_str = fmt.Sprintf("%ds", ms/1000)
+	die(*hash_ptr != "" && !slices.Contains(hash_algorithm_list, *hash_ptr), "--hash must be either 'sha256' or 'md5'")
+
+	log_target := *log_target_ptr
+	die(log_target != "stderr" && log_target != "syslog", "--log-target must be either 'stderr' or 'syslog'")
+	var logger StatusLogger
+	if log_target == "syslog" {
+		syslog_writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "validate-article-json")
+		die(err != nil, fmt.Sprintf("failed to connect to syslog: %v", err))
+		defer syslog_writer.Close()
+		logger = syslog_writer
 	}
-	return elapsed_str
-}
 
-func short_validation_error(err error) {
-	fmt.Printf("%v\n", err)
-}
+	opts := Options{
+		BufferSize:            buffer_size,
+		NumWorkers:            num_workers,
+		Color:                 color_stderr,
+		Checksums:             checksum_map,
+		AutoWorkers:           *auto_workers_ptr,
+		AllowEmpty:            *allow_empty_ptr,
+		MaxDepth:              *max_depth_ptr,
+		CheckUTF8:             *check_utf8_ptr,
+		MaxFileSize:           *max_file_size_ptr,
+		ExtraChecks:           extra_check_list,
+		Context:               ctx,
+		ReportExtraProperties: *report_extra_properties_ptr,
+		Logger:                logger,
+		Hash:                  *hash_ptr,
+		Base64Field:           *base64_field_ptr,
+		Prefill:               *prefill_ptr,
+		TypeBuffer:            article_type_buffer,
+		MaxFailures:           *max_errors_total_ptr,
+		RelativeTo:            *relative_to_ptr,
+		StatusField:           *status_field_ptr,
+		ForceType:             strings.ToUpper(*force_type_ptr),
+		IncludeRaw:            *include_raw_ptr,
+		IgnoreKeywords:        ignore_keyword_list,
+		KeepGoing:             *keep_going_ptr,
+		Timer:                 timer,
+		NoExtract:             *no_extract_ptr,
+		SlowThreshold:         *slow_threshold_ptr,
+	}
+	coverage_file := *coverage_file_ptr
+	if coverage_file != "" {
+		opts.Coverage = new_schema_coverage()
+	}
+	if opts.ForceType != "" {
+		_, present := schema_map[opts.ForceType]
+		die(!present, fmt.Sprintf("no schema for --force-type '%s', expected 'POA' or 'VOR'", *force_type_ptr))
+	}
+	if *format_template_ptr != "" {
+		tmpl, err := parse_format_template(*format_template_ptr)
+		die(err != nil, fmt.Sprintf("bad --format-template: %v", err))
+		opts.FormatTemplate = tmpl
+	}
 
-func long_validation_error(err error) {
-	fmt.Printf("%#v\n", err)
-}
+	// exits with `code` once validation has failed, or with 0 and a note when --no-fail is given.
+	fail := func(code int) {
+		if *no_fail_ptr {
+			println(fmt.Sprintf("exiting with 0 instead of %d (--no-fail)", code))
+			code = 0
+		}
+		exit(code)
+	}
 
-func die(b bool, msg string) {
-	if b {
-		fmt.Println(msg)
-		os.Exit(1)
+	if explain_file != "" {
+		opts.CaptureError = true
+		result := check_article(schema_map, read_article_data(explain_file, schema_map, opts), opts)
+		if explain_result(result, color_stderr, color_stdout, raw_errors) {
+			fail(1)
+		}
+		return
 	}
-}
 
-// keep a buffer of `buffer_size` files in memory at once to feed a pool of `num_workers`.
-// ensures disk I/O is not a factor in keeping the CPU busy.
-// when `capture_error` is true, the validation is available in the `Result` struct.
-// when `print_status` is true, a short valid/invalid message is printed as it occurs.
-func process_files_with_feeder(buffer_size int, num_workers int, file_list []string, schema_map map[string]Schema, capture_error bool, print_status bool) (time.Time, time.Time, []Result) {
-	// read files from disk into buffer
+	var stdin io.Reader = os.Stdin
+	if *stdin_ptr {
+		var err error
+		stdin, err = maybe_gunzip_reader(os.Stdin, *gzip_ptr)
+		die(err != nil, fmt.Sprintf("failed reading stdin: %v", err))
+	}
 
-	job_size := len(file_list)
-	if job_size < buffer_size {
-		buffer_size = job_size
+	die(*result_cache_size_ptr < 0, "--result-cache-size must be zero or more")
+	if *result_cache_size_ptr > 0 {
+		die(!(*stdin_ptr && *ndjson_ptr) && !*jobs_from_queue_ptr, "--result-cache-size requires --ndjson or --jobs-from-queue")
+		opts.ResultCache = new_result_cache(*result_cache_size_ptr)
 	}
-	article_chan := make(chan Article, buffer_size)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func(article_chan chan Article, wg *sync.WaitGroup) {
-		defer wg.Done()
-		for _, file := range file_list {
-			article_chan <- read_article_data(file)
+
+	if *stdin_ptr && *ndjson_ptr {
+		die(pretty, "--pretty can't be used with --ndjson")
+		die(summary_only, "--summary-only and --count can't be used with --ndjson")
+		die(failures_file != "", "--failures-file can't be used with --ndjson")
+		die(*print0_failures_ptr, "--print0-failures can't be used with --ndjson")
+		die(*result_socket_ptr != "", "--result-socket can't be used with --ndjson, results are already written to stdout")
+		die(coverage_file != "", "--coverage-file can't be used with --ndjson")
+		opts.CaptureError = true
+		summary, err := process_ndjson_stream(stdin, os.Stdout, schema_map, opts)
+		die(err != nil, fmt.Sprintf("failed validating stdin: %v", err))
+		if metrics_file != "" {
+			// results aren't kept, so there are no metrics by article type or validation time.
+			err = write_metrics_file(metrics_file, summary, nil)
+			panic_on_err(err, "writing metrics file")
 		}
-		close(article_chan)
-		//println("(done reading files)")
-	}(article_chan, &wg)
+		if opts.Logger != nil {
+			log_summary(opts.Logger, summary, fail_on_warnings)
+		} else {
+			println(summary.String())
+		}
+		if ctx.Err() != nil {
+			fail(exit_deadline_exceeded)
+		}
+		if summary.Failed(fail_on_warnings) {
+			fail(1)
+		}
+		return
+	}
 
-	// process articles from `article_chan` until it's closed.
+	if *jobs_from_queue_ptr {
+		die(pretty, "--pretty can't be used with --jobs-from-queue")
+		die(summary_only, "--summary-only and --count can't be used with --jobs-from-queue")
+		die(failures_file != "", "--failures-file can't be used with --jobs-from-queue")
+		die(*print0_failures_ptr, "--print0-failures can't be used with --jobs-from-queue")
+		die(coverage_file != "", "--coverage-file can't be used with --jobs-from-queue")
+		die(*result_socket_ptr != "", "--result-socket can't be used with --jobs-from-queue, results are already pushed to --redis-results")
+		results_list := *redis_results_ptr
+		if results_list == "" {
+			results_list = *redis_queue_ptr + ":results"
+		}
+		// a worker runs until it's told to stop.
+		queue_ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		opts.Context = queue_ctx
+		opts.CaptureError = true
+		summary, err := process_redis_queue(*redis_addr_ptr, *redis_queue_ptr, results_list, schema_map, opts)
+		die(err != nil, fmt.Sprintf("failed validating redis queue: %v", err))
+		if metrics_file != "" {
+			// results aren't kept, so there are no metrics by article type or validation time.
+			err = write_metrics_file(metrics_file, summary, nil)
+			panic_on_err(err, "writing metrics file")
+		}
+		if opts.Logger != nil {
+			log_summary(opts.Logger, summary, fail_on_warnings)
+		} else {
+			println(summary.String())
+		}
+		return
+	}
 
-	worker_pool := pool.NewWithResults[Result]()
-	if num_workers >= 1 {
-		worker_pool = worker_pool.WithMaxGoroutines(num_workers)
+	if *probe_drafts_ptr {
+		die(*stdin_ptr, "--stdin can't be used with --probe-drafts")
+		file_list := []string{input_path}
+		if path_is_dir(input_path) {
+			file_list = sample(list_files(input_path, sample_size, ext_list))
+			require_sample_size(file_list)
+		}
+		draft_schema_map, draft_error_map := compile_draft_schemas(schema_root, schema_opts)
+		die(len(draft_schema_map) == 0, "failed to compile schemas as any draft")
+		do_probe_drafts(file_list, schema_map, draft_schema_map, draft_error_map, opts)
+		return
 	}
-	start_time := time.Now()
-	for article := range article_chan {
-		article := article
-		worker_pool.Go(func() Result {
-			result := validate_article(schema_map, article, capture_error)
-			if print_status {
-				println(result.String())
+
+	if *schema_versions_ptr != "" {
+		die(*stdin_ptr, "--stdin can't be used with --schema-versions")
+		die(*raml_ptr, "--raml can't be used with --schema-versions")
+		name_list := []string{}
+		for _, name := range strings.Split(*schema_versions_ptr, ",") {
+			name = strings.TrimSpace(name)
+			die(!slices.Contains(schema_version_name_list, name), fmt.Sprintf("unknown --schema-versions value '%s', expected 'latest' or 'prev'", name))
+			if !slices.Contains(name_list, name) {
+				name_list = append(name_list, name)
 			}
-			return result
-		})
+		}
+		file_list := []string{input_path}
+		if path_is_dir(input_path) {
+			file_list = sample(list_files(input_path, sample_size, ext_list))
+			require_sample_size(file_list)
+		}
+		version_schema_map, version_error_map := compile_version_schemas(schema_root, schema_opts, name_list)
+		die(len(version_schema_map) == 0, "failed to compile any of the --schema-versions")
+		do_schema_versions(file_list, schema_map, version_schema_map, version_error_map, name_list, opts)
+		return
 	}
 
-	wg.Wait()
-	result_list := worker_pool.Wait()
-	end_time := time.Now()
-	return start_time, end_time, result_list
-}
-
-func do() {
-	schema_root_ptr := flag.String("schema-root", "", "path to api-raml schema root")
-	input_path_ptr := flag.String("article-json", "", "path to an article-json file or directory")
-	sample_size_ptr := flag.Int("sample-size", -1, "number of article-json files to parse")
-	num_workers_ptr := flag.Int("num-workers", 0, "number of workers (goroutines) to process the article-json files\n0 for number of cpu cores (default), -1 for unbounded")
-	// 1k articles is about ~1.5GiB of RAM
-	buffer_size_ptr := flag.Int("buffer-size", 1000, "maximum number of article-json files to keep in memory at once")
-	flag.Parse()
-
-	schema_root := *schema_root_ptr
-	die(schema_root == "", "--schema-root is required")
-	die(!path_exists(schema_root), "--schema-root path does not exist. it should be a path to the api-raml.")
-	schema_map, err := configure_validator(schema_root)
-	die(err != nil, fmt.Sprintf("failed to configure validator: %v", err))
+	if bench_mode {
+		die(*stdin_ptr, "--stdin can't be used in 'bench' mode")
+		passes := *passes_ptr
+		die(passes < 2, "--passes must be 2 or greater")
+		die(!path_is_dir(input_path), "--article-json must be a directory in 'bench' mode")
+		file_list := sample(list_files(input_path, sample_size, ext_list))
+		require_sample_size(file_list)
+		die(len(file_list) == 0, "no article-json files found to benchmark")
+		do_bench(passes, file_list, schema_map, opts)
+		return
+	}
 
-	input_path := *input_path_ptr
-	die(input_path == "", "--article-json is required")
-	die(!path_exists(input_path), "--article-json path does not exist. it should be a path to an article-json file or a directory of article-json files.")
+	// a single file with a list of article-json documents is validated like a directory of files.
+	validate_single := *stdin_ptr || (!path_is_dir(input_path) && !is_document_list_file(input_path, opts))
+	// set once validation is done, before the results are written.
+	var start_time, end_time time.Time
 
-	sample_size := *sample_size_ptr
-	die(sample_size < -1 || sample_size == 0, "--sample-size must be -1 or a value greater than 0")
+	// writes the json report to stdout, or to --output-file.
+	write_report := func(result_list []Result) error {
+		report_num_workers := num_workers
+		if validate_single {
+			report_num_workers = 1
+		}
+		summary := summarise(result_list, report_num_workers, start_time, end_time)
+		meta := new_meta(schema_root, schema_map, report_num_workers, sample_size, start_time, end_time)
+		var dir_list []DirSummary
+		if *group_by_dir_ptr && !validate_single {
+			dir_list = summarise_by_dir(result_list)
+		}
+		if output_file == "" {
+			return write_json_report(os.Stdout, meta, summary, result_list, dir_list, pretty)
+		}
+		buf := bytes.Buffer{}
+		err := write_json_report(&buf, meta, summary, result_list, dir_list, pretty)
+		if err != nil {
+			return err
+		}
+		return write_file_atomic(output_file, buf.Bytes())
+	}
 
-	num_workers := *num_workers_ptr
-	die(num_workers < -1, "--num-workers must be -1 or greater")
-	if num_workers == 0 {
-		num_workers = runtime.NumCPU()
+	// prints a github annotation for each problem in each failed result.
+	write_github_annotations := func(result_list []Result) error {
+		for _, result := range result_list {
+			if result.Success {
+				continue
+			}
+			if *stdin_ptr {
+				for _, annotation := range github_annotations(result, stdin_file_name, nil, opts.NoExtract) {
+					fmt.Println(annotation)
+				}
+				continue
+			}
+			// filenames are relative to `opts.RelativeTo` but files must be read from where they are.
+			print_github_annotations(result, absolute_file_name(opts.RelativeTo, result.FileName), opts)
+		}
+		return nil
 	}
 
-	buffer_size := *buffer_size_ptr
-	die(buffer_size < 1, "--buffer-size must be a positive integer")
+	sinks := result_sinks{}
+	if output_format == "json" {
+		report_chan, close_report_writer := start_report_writer(write_report, buffer_size)
+		sinks.add(report_chan, func() {
+			err := close_report_writer()
+			die(err != nil, fmt.Sprintf("failed writing json report: %v", err))
+		})
+	} else if output_format == "github" {
+		annotation_chan, close_annotation_writer := start_report_writer(write_github_annotations, buffer_size)
+		sinks.add(annotation_chan, func() {
+			close_annotation_writer()
+		})
+	}
+	if *sqlite_ptr != "" {
+		sqlite_chan, close_sqlite_writer, err := start_sqlite_writer(*sqlite_ptr, schema_map, buffer_size)
+		die(err != nil, fmt.Sprintf("failed to open --sqlite database: %v", err))
+		sinks.add(sqlite_chan, func() {
+			err := close_sqlite_writer()
+			die(err != nil, fmt.Sprintf("failed writing results to --sqlite database: %v", err))
+		})
+	}
+	if *manifest_file_ptr != "" {
+		manifest_chan, close_manifest_writer, err := start_manifest_writer(*manifest_file_ptr, buffer_size)
+		die(err != nil, fmt.Sprintf("failed to create --manifest-file: %v", err))
+		sinks.add(manifest_chan, func() {
+			err := close_manifest_writer()
+			die(err != nil, fmt.Sprintf("failed writing results to --manifest-file: %v", err))
+		})
+	}
+	if *result_socket_ptr != "" {
+		socket_chan, close_socket_writer := start_socket_writer(*result_socket_ptr, buffer_size)
+		sinks.add(socket_chan, func() {
+			err := close_socket_writer()
+			if err != nil {
+				println(fmt.Sprintf("%s --result-socket: %v", colorise("warning:", ansi_yellow, color_stderr), err))
+			}
+		})
+	}
+	if *progress_ptr {
+		progress_chan, close_progress_writer := start_progress_writer(os.Stderr, progress_interval, buffer_size)
+		sinks.add(progress_chan, func() {
+			close_progress_writer()
+		})
+	}
+	opts.ResultChan = sinks.start(buffer_size)
+	// waits for any results still being written and stops sending further results.
+	close_result_writers := func() {
+		sinks.close()
+		opts.ResultChan = nil
+	}
 
-	if !path_is_dir(input_path) {
+	if validate_single {
 		// validate single
-		capture_errors := true
-		article := read_article_data(input_path)
-		result := validate_article(schema_map, article, capture_errors)
-		if !result.Success {
-			long_validation_error(result.Error)
-			os.Exit(1)
+		opts.CaptureError = true
+		var article Article
+		if *stdin_ptr {
+			article = read_stdin_article(stdin, schema_map, opts)
+		} else {
+			article = read_article_data(input_path, schema_map, opts)
+		}
+		start_time = time.Now()
+		result := check_article(schema_map, article, opts)
+		end_time = time.Now()
+		if opts.ResultChan != nil {
+			opts.ResultChan <- result
+		}
+		close_result_writers()
+		summary := summarise([]Result{result}, 1, start_time, end_time)
+		if opts.Logger != nil && !count {
+			if summary_only {
+				log_summary(opts.Logger, summary, fail_on_warnings)
+			} else {
+				log_status(opts.Logger, result, opts.FormatTemplate)
+			}
+		}
+		if metrics_file != "" {
+			err = write_metrics_file(metrics_file, summary, []Result{result})
+			panic_on_err(err, "writing metrics file")
+		}
+		if failures_file != "" {
+			err = write_failures_file(failures_file, []Result{result}, opts.RelativeTo)
+			panic_on_err(err, "writing failures file")
+		}
+		if *print0_failures_ptr {
+			err = write_failures0(os.Stdout, []Result{result}, opts.RelativeTo)
+			panic_on_err(err, "writing failures")
+		}
+		if coverage_file != "" {
+			meta := new_meta(schema_root, schema_map, 1, sample_size, start_time, end_time)
+			err = write_coverage_file(coverage_file, meta, opts.Coverage, schema_map)
+			panic_on_err(err, "writing coverage file")
+		}
+		name := mirrored_name(filepath.Dir(input_path), absolute_file_name(opts.RelativeTo, result.FileName))
+		if !result.Success && output_dir != "" {
+			err = write_error_report(output_dir, name, result)
+			panic_on_err(err, "writing error report for: "+result.FileName)
+		}
+		if golden_dir != "" {
+			err = write_golden(golden_dir, name, result, schema_base_url())
+			panic_on_err(err, "writing golden result for: "+result.FileName)
+		}
+		golden_mismatched := false
+		if compare_golden_dir != "" {
+			golden_mismatched = compare_goldens(compare_golden_dir, []Result{result}, func(Result) string { return name }, schema_base_url()) > 0
+		}
+		modified := false
+		if *normalize_ptr && result.Success && !result.Skipped {
+			normalized, err := normalize_file(input_path)
+			die(err != nil, fmt.Sprintf("failed to normalize: %v", err))
+			if normalized && !summary_only {
+				println("normalized " + result.FileName)
+			}
+			modified = normalized
+		}
+		// json and github output is written by its result sink.
+		if count {
+			fmt.Println(summary.CountString())
+		} else if summary_only {
+			if opts.Logger == nil {
+				println(summary.String())
+			}
+		} else if output_format == "text" {
+			if !result.Success {
+				long_validation_error(result.Error, color_stdout, raw_errors)
+			}
+			for _, warning := range result.Warnings {
+				println(colorise("warning:", ansi_yellow, color_stderr) + " " + warning)
+			}
+			if warning, slow := slow_warning(result, opts.SlowThreshold); slow {
+				println(colorise("warning:", ansi_yellow, color_stderr) + " " + warning)
+			}
+			if result.Skipped {
+				println(format_result(result, color_stderr, opts.FormatTemplate))
+			}
+		}
+		if summary.Failed(fail_on_warnings) {
+			fail(1)
+		}
+		if golden_mismatched {
+			fail(1)
+		}
+		if modified && *fail_on_modification_ptr {
+			println("failing as the article-json file was normalized (--fail-on-modification)")
+			fail(exit_modified)
 		}
 	} else {
 		// validate many
-		path_list, err := os.ReadDir(input_path)
-		panic_on_err(err, "reading contents of directory: "+input_path)
+		stop_timer := timer.start("file listing")
+		file_list := []string{input_path}
+		if path_is_dir(input_path) {
+			// every file is listed, the --sample-size is taken once the files have been filtered.
+			file_list = list_files(input_path, -1, ext_list)
+		}
+		if *changed_since_ptr != "" {
+			if is_git_work_tree(input_path) {
+				changed_map, err := git_changed_files(input_path, *changed_since_ptr)
+				die(err != nil, fmt.Sprintf("failed to find files changed since --changed-since: %v", err))
+				file_list = filter_files(file_list, changed_map)
+				if len(file_list) == 0 {
+					println("no article-json files changed since " + *changed_since_ptr)
+					return
+				}
+			} else {
+				println("--article-json is not a git working tree, ignoring --changed-since")
+			}
+		}
+		if only_id_list != nil {
+			file_list = filter_article_ids(file_list, only_id_list)
+			missing_id_list := missing_article_ids(file_list, only_id_list)
+			die(len(file_list) == 0, "no article-json files found for --only-ids: "+strings.Join(missing_id_list, ", "))
+			if len(missing_id_list) > 0 {
+				println(fmt.Sprintf("%s no article-json files found for %d of the --only-ids: %s",
+					colorise("warning:", ansi_yellow, color_stderr), len(missing_id_list), strings.Join(missing_id_list, ", ")))
+			}
+		}
 
-		if sample_size == -1 || sample_size > len(path_list) {
-			// validate all files in dir
-			sample_size = len(path_list)
+		version_map := multiple_article_versions(file_list)
+		if len(version_map) > 0 {
+			id_list := []string{}
+			for id := range version_map {
+				id_list = append(id_list, id)
+			}
+			slices.Sort(id_list)
+			example_id := id_list[0]
+			println(fmt.Sprintf("%s %d articles have more than one version, for example elife-%s has versions %v",
+				colorise("warning:", ansi_yellow, color_stderr), len(version_map), example_id, version_map[example_id]))
+			if *latest_version_only_ptr {
+				file_list = latest_article_versions(file_list)
+				println(fmt.Sprintf("validating only the latest version of each article (--latest-version-only), %d article-json files", len(file_list)))
+			}
 		}
+		// samples are taken last, from the files left once every filter has been applied.
+		if path_is_dir(input_path) {
+			file_list = sample(take_sample(file_list, sample_size))
+			require_sample_size(file_list)
+		}
+		stop_timer()
 
-		// sort files by filename, numerically, lowest to highest (asc).
-		// order of file listings is never guaranteed so sort before we take a sample.
-		// note! filename output happens in parallel so progress may *appear* unordered.
-		sort.Slice(path_list, func(a, b int) bool {
-			return path_list[a].Name() < path_list[b].Name()
-		})
+		// errors are only needed up front when asked for or every failure is written to an error report, json or golden file.
+		opts.CaptureError = capture_errors || output_dir != "" || output_format != "text" || golden_dir != "" || compare_golden_dir != ""
+		opts.PrintStatus = !summary_only
+		var result_list []Result
+		if opts.AutoWorkers {
+			start_time, end_time, result_list, num_workers = process_files_with_auto_workers(file_list, schema_map, opts)
+		} else {
+			start_time, end_time, result_list = process_files_with_feeder(file_list, schema_map, opts)
+		}
+		timer.add("validation", end_time.Sub(start_time))
+		close_result_writers()
+		summary := summarise(result_list, num_workers, start_time, end_time)
+		deadline_exceeded := ctx.Err() != nil
+		// a batch that ran out of files at the same time is not aborted.
+		aborted := opts.MaxFailures > 0 && summary.Failures >= opts.MaxFailures && len(result_list) < len(file_list)
+		failure_exit_code := 1
+		if aborted {
+			failure_exit_code = exit_max_errors_total
+		}
 
-		file_list := []string{}
-		for i := 0; i < sample_size; i++ {
-			path := path_list[i]
-			// remove any directories
-			if path.IsDir() {
-				continue
+		failures := []Result{}
+		for _, result := range result_list {
+			if !result.Success {
+				failures = append(failures, result)
 			}
+		}
 
-			// remove any non-json files
-			if filepath.Ext(path.Name()) != ".json" {
-				continue
+		if count {
+			fmt.Println(summary.CountString())
+		} else if opts.Logger != nil {
+			log_summary(opts.Logger, summary, fail_on_warnings)
+		} else {
+			if !summary_only {
+				println("")
+			}
+			println(summary.String())
+			if summary.Failures > 0 {
+				println(severity_summary(summary))
+			}
+			if schema_opts.AssertFormat || schema_opts.AssertContent {
+				println(assertion_summary(schema_map))
+			}
+		}
+		var dir_list []DirSummary
+		if *group_by_dir_ptr {
+			dir_list = summarise_by_dir(result_list)
+			if opts.Logger != nil {
+				for _, dir_summary := range dir_list {
+					if dir_summary.Failures > 0 {
+						opts.Logger.Err(dir_summary.String())
+					} else {
+						opts.Logger.Info(dir_summary.String())
+					}
+				}
+			} else if !count {
+				println("")
+				println("by directory:")
+				for _, dir_summary := range dir_list {
+					println(dir_summary.String())
+				}
 			}
+		}
+		if timer != nil && !count {
+			println("")
+			println("timing:")
+			for _, line := range timer.lines() {
+				println(line)
+			}
+		}
+		if *top_slow_ptr > 0 && len(result_list) > 0 {
+			slowest_list := slowest_results(result_list, *top_slow_ptr)
+			println("")
+			println(fmt.Sprintf("slowest %d of %d:", len(slowest_list), len(result_list)))
+			print_slowest(slowest_list)
+		}
+		perf := perf_numbers(start_time, end_time, result_list)
+		if *write_perf_baseline_ptr != "" {
+			err = write_perf_baseline(*write_perf_baseline_ptr, perf)
+			panic_on_err(err, "writing performance baseline")
+		}
+		perf_regressed := false
+		if *perf_baseline_ptr != "" {
+			regression_list := compare_perf(perf_baseline, perf, *perf_tolerance_ptr)
+			println(fmt.Sprintf("throughput:%.1f/s (baseline %.1f/s), p99:%dms (baseline %dms)", perf.Throughput, perf_baseline.Throughput, perf.P99Ms, perf_baseline.P99Ms))
+			for _, regression := range regression_list {
+				println("performance regression: " + regression)
+			}
+			perf_regressed = len(regression_list) > 0
+		}
+		if aborted {
+			println(fmt.Sprintf("aborted after %d failures (--max-errors-total)", summary.Failures))
+		}
+		if deadline_exceeded {
+			println(fmt.Sprintf("deadline of %s exceeded, validated %d of %d article-json files", *deadline_ptr, len(result_list), len(file_list)))
+		}
 
-			file_list = append(file_list, filepath.Join(input_path, path.Name()))
+		if metrics_file != "" {
+			err = write_metrics_file(metrics_file, summary, result_list)
+			panic_on_err(err, "writing metrics file")
 		}
 
-		// reverse the sample (desc) so we do a natural 'count down' to the lowest article.
-		slices.Reverse(file_list)
+		if failures_file != "" {
+			err = write_failures_file(failures_file, result_list, opts.RelativeTo)
+			panic_on_err(err, "writing failures file")
+		}
 
-		// ensure the correct sample size is reported after filtering out directories.
-		sample_size = len(file_list)
+		if *print0_failures_ptr {
+			err = write_failures0(os.Stdout, result_list, opts.RelativeTo)
+			panic_on_err(err, "writing failures")
+		}
 
-		capture_error := false
-		print_result := true
-		start_time, end_time, result_list := process_files_with_feeder(buffer_size, num_workers, file_list, schema_map, capture_error, print_result)
-		wall_time_ms := end_time.Sub(start_time).Milliseconds()
+		if coverage_file != "" {
+			meta := new_meta(schema_root, schema_map, num_workers, sample_size, start_time, end_time)
+			err = write_coverage_file(coverage_file, meta, opts.Coverage, schema_map)
+			panic_on_err(err, "writing coverage file")
+		}
 
-		var cpu_time_ms int64
-		for _, result := range result_list {
-			cpu_time_ms = cpu_time_ms + result.Elapsed
+		// a list of documents is mirrored from the directory it's in.
+		input_dir := input_path
+		if !path_is_dir(input_path) {
+			input_dir = filepath.Dir(input_path)
 		}
 
-		failures := []Result{}
-		for _, result := range result_list {
-			if !result.Success {
-				failures = append(failures, result)
+		mirror := func(result Result) string {
+			return mirrored_name(input_dir, absolute_file_name(opts.RelativeTo, result.FileName))
+		}
+
+		if output_dir != "" {
+			for _, result := range failures {
+				err = write_error_report(output_dir, mirror(result), result)
+				panic_on_err(err, "writing error report for: "+result.FileName)
+			}
+		}
+
+		if golden_dir != "" {
+			base_url := schema_base_url()
+			for _, result := range result_list {
+				err = write_golden(golden_dir, mirror(result), result, base_url)
+				panic_on_err(err, "writing golden result for: "+result.FileName)
+			}
+		}
+
+		num_golden_mismatched := 0
+		if compare_golden_dir != "" {
+			num_golden_mismatched = compare_goldens(compare_golden_dir, result_list, mirror, schema_base_url())
+		}
+
+		num_normalized := 0
+		if *normalize_ptr {
+			normalize_list := []string{}
+			for _, result := range result_list {
+				if !result.Success || result.Skipped {
+					continue
+				}
+				if _, _, is_document := split_document_index(result.FileName); is_document {
+					// a list of documents is left as it is.
+					continue
+				}
+				// filenames are relative to `opts.RelativeTo` but files must be read from where they are.
+				normalize_list = append(normalize_list, absolute_file_name(opts.RelativeTo, result.FileName))
+			}
+			// nothing is replaced until every file has been normalized.
+			normalized_list, err := normalize_files(normalize_list)
+			die(err != nil, fmt.Sprintf("failed to normalize: %v", err))
+			num_normalized = len(normalized_list)
+			if !summary_only {
+				println(fmt.Sprintf("normalized %d of %d valid article-json files", num_normalized, len(result_list)-len(failures)))
+			}
+		}
+
+		if output_format == "json" || output_format == "github" {
+			// the report was written by its result sink once validation was done.
+			if deadline_exceeded {
+				fail(exit_deadline_exceeded)
+			}
+			if summary.Failed(fail_on_warnings) {
+				fail(failure_exit_code)
+			}
+			if perf_regressed || num_golden_mismatched > 0 {
+				fail(1)
+			}
+			if num_normalized > 0 && *fail_on_modification_ptr {
+				fail(exit_modified)
+			}
+			return
+		}
+
+		if summary_only {
+			if deadline_exceeded {
+				fail(exit_deadline_exceeded)
+			}
+			if len(failures) > 0 {
+				fail(failure_exit_code)
 			}
 		}
 
-		println("")
-		println(fmt.Sprintf("articles:%d, failures:%d, workers:%d, wall-time:%s, cpu-time:%s, average:%dms", sample_size, len(failures), num_workers, format_ms(wall_time_ms), format_ms(cpu_time_ms), (cpu_time_ms / int64(sample_size))))
+		if deadline_exceeded {
+			// there is no time left to re-validate failures for their errors.
+			if len(failures) > 0 {
+				println("")
+				for _, result := range failures {
+					println(format_result(result, color_stderr, opts.FormatTemplate))
+				}
+			}
+			fail(exit_deadline_exceeded)
+		}
 
 		if len(failures) > 0 {
 			println("")
 			for _, result := range failures {
-				println(result.String())
-				if capture_error {
-					short_validation_error(result.Error)
+				println(format_result(result, color_stderr, opts.FormatTemplate))
+				if opts.CaptureError {
+					short_validation_error(result.Error, color_stdout)
 					println("---")
 				}
 			}
 
-			// re-validate the first N failures but with detailed validation errors this time.
+			// show the first N failures with detailed validation errors,
+			// re-validating them unless the errors were already captured during the first pass.
 
 			num_to_revalidate := 25
 			if len(failures) > num_to_revalidate {
@@ -422,49 +7021,107 @@ func do() {
 
 			fmt.Println()
 
-			file_list := []string{}
-			for i := 0; i <= num_to_revalidate; i++ {
-				file_list = append(file_list, failures[i].FileName)
-			}
-
-			num_workers = 1
-			capture_error = true
-			print_result = false
-			_, _, result_list := process_files_with_feeder(buffer_size, num_workers, file_list, schema_map, capture_error, print_result)
+			result_list := detailed_failures(failures[:num_to_revalidate+1], schema_map, opts)
 			for i, result := range result_list {
 				// "--- failure 1 of 2: path/to/invalid.xml.json"
 				fmt.Printf("--- failure %d of %d: %v\n", i+1, len(failures), result.FileName)
-				long_validation_error(result.Error)
+				long_validation_error(result.Error, color_stdout, raw_errors)
 				fmt.Println()
 			}
 
-			os.Exit(1)
+			fail(failure_exit_code)
+		}
+
+		if summary.Failed(fail_on_warnings) {
+			println("")
+			println("failing due to warnings (--fail-on-warnings)")
+			fail(1)
+		}
+
+		if perf_regressed {
+			println("")
+			println("failing due to performance regression (--perf-baseline)")
+			fail(1)
+		}
+
+		if num_golden_mismatched > 0 {
+			println("")
+			println(fmt.Sprintf("failing as %d results differ from their golden results (--compare-golden)", num_golden_mismatched))
+			fail(1)
+		}
+
+		if num_normalized > 0 && *fail_on_modification_ptr {
+			println("")
+			println(fmt.Sprintf("failing as %d article-json files were normalized (--fail-on-modification)", num_normalized))
+			fail(exit_modified)
 		}
 	}
 }
 
-func do_with_profiling(output_filename string) {
+// starts a CPU profile that is written to `output_filename` on exit.
+func start_cpu_profile(output_filename string) {
 	f, err := os.Create(output_filename)
 	die(err != nil, "could not create CPU profile")
-	defer f.Close()
 
 	err = pprof.StartCPUProfile(f)
 	die(err != nil, "could not start CPU profile")
 
-	defer pprof.StopCPUProfile()
+	add_exit_hook(func() {
+		pprof.StopCPUProfile()
+		f.Close()
+		println("---")
+		println("wrote " + output_filename)
+	})
+}
 
-	do()
+// writes a heap profile to `output_filename` on exit.
+// the profile includes everything allocated during the run as well as what was still in use at the end.
+func start_heap_profile(output_filename string) {
+	f, err := os.Create(output_filename)
+	die(err != nil, "could not create heap profile")
+
+	add_exit_hook(func() {
+		defer f.Close()
+		runtime.GC() // up-to-date statistics on what is in use
+		err := pprof.WriteHeapProfile(f)
+		if err != nil {
+			println("could not write heap profile: " + err.Error())
+			return
+		}
+		println("---")
+		println("wrote " + output_filename)
+	})
+}
+
+// starts an execution trace that is written to `output_filename` on exit.
+// the trace shows when the feeder and workers were running, blocked or waiting on the garbage collector.
+func start_trace(output_filename string) {
+	f, err := os.Create(output_filename)
+	die(err != nil, "could not create trace")
+
+	err = trace.Start(f)
+	die(err != nil, "could not start trace")
+
+	add_exit_hook(func() {
+		trace.Stop()
+		f.Close()
+		println("wrote " + output_filename)
+	})
 }
 
 func main() {
 	profile := os.Getenv("VAJ_PROFILE")
-	if profile != "" {
+	mem_profile := os.Getenv("VAJ_MEMPROFILE")
+	if profile != "" || mem_profile != "" {
 		println("profiling is on")
 		println("---")
-		do_with_profiling("cpu.prof")
-		println("---")
-		println("wrote cpu.prof")
-	} else {
-		do()
 	}
+	if profile != "" {
+		start_cpu_profile("cpu.prof")
+	}
+	if mem_profile != "" {
+		start_heap_profile("mem.prof")
+	}
+	do()
+	exit(0)
 }
@@ -1,14 +1,66 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
 )
 
+// when set, the test binary runs `do` with these arguments, separated by a unit separator, instead of the tests.
+const run_command_env = "VAJ_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if arg_string, present := os.LookupEnv(run_command_env); present {
+		os.Args = append([]string{"validate-article-json"}, strings.Split(arg_string, "\x1f")...)
+		do()
+		exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runs the command with `arg_list` in a separate process, as `do` calls `exit` when it's done.
+// returns its stdout, stderr and exit code.
+func run_command(t *testing.T, arg_list ...string) (string, string, int) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), run_command_env+"="+strings.Join(arg_list, "\x1f"))
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	exit_err := &exec.ExitError{}
+	if err != nil && !errors.As(err, &exit_err) {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
 func Test_format_ms(t *testing.T) {
 	cases := map[int64]string{
 		1:     "1ms",
@@ -43,3 +95,3189 @@ func Test_assert_panic_on_err(t *testing.T) {
 		panic_on_err(errors.New("kaboom"), "pressing a red button")
 	})
 }
+
+func Test_flatten_validation_error(t *testing.T) {
+	err := &jsonschema.ValidationError{
+		InstanceLocation: "",
+		KeywordLocation:  "",
+		Message:          "doesn't validate",
+		Causes: []*jsonschema.ValidationError{
+			{InstanceLocation: "", KeywordLocation: "/required", Message: "missing properties: 'title'"},
+			{InstanceLocation: "/version", KeywordLocation: "/properties/version/type", Message: "expected integer, but got string"},
+		},
+	}
+	expected := []string{
+		"[I#] [S#/required] missing properties: 'title'",
+		"[I#/version] [S#/properties/version/type] expected integer, but got string",
+	}
+	assert.Equal(t, expected, flatten_validation_error(err))
+	assert.Equal(t, []string{"kaboom"}, flatten_validation_error(errors.New("kaboom")))
+}
+
+func Test_verify_checksum(t *testing.T) {
+	tmp := t.TempDir()
+	manifest := path.Join(tmp, "sums.txt")
+	// sha256 of "bar"
+	digest := "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+	os.WriteFile(manifest, []byte(digest+"  foo.json\n\n"+digest+" *baz.json\n"), 0644)
+
+	checksum_map, err := read_checksum_manifest(manifest)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo.json": digest, "baz.json": digest}, checksum_map)
+
+	assert.Nil(t, verify_checksum(checksum_map, "/path/to/foo.json", []byte("bar")))
+	assert.ErrorIs(t, verify_checksum(checksum_map, "/path/to/foo.json", []byte("baa")), ErrChecksumMismatch)
+	assert.ErrorIs(t, verify_checksum(checksum_map, "/path/to/qux.json", []byte("bar")), ErrChecksumMismatch)
+}
+
+func Test_DetailedError(t *testing.T) {
+	err := &jsonschema.ValidationError{
+		KeywordLocation:         "",
+		AbsoluteKeywordLocation: "file:///VOR#",
+		Message:                 "doesn't validate",
+		Causes: []*jsonschema.ValidationError{
+			{InstanceLocation: "/version", KeywordLocation: "/properties/version/type", AbsoluteKeywordLocation: "file:///VOR#/properties/version/type", Message: "expected integer, but got string"},
+		},
+	}
+	expected := []ErrorDetail{
+		{
+			AbsoluteKeywordLocation: "file:///VOR#",
+			Message:                 "doesn't validate",
+			Causes: []ErrorDetail{
+				{InstanceLocation: "/version", KeywordLocation: "/properties/version/type", AbsoluteKeywordLocation: "file:///VOR#/properties/version/type", Message: "expected integer, but got string"},
+			},
+		},
+	}
+	assert.Equal(t, expected, DetailedError(err))
+	assert.Equal(t, []ErrorDetail{{Message: "kaboom"}}, DetailedError(errors.New("kaboom")))
+	assert.Nil(t, DetailedError(nil))
+}
+
+func Test_mean_stddev(t *testing.T) {
+	mean, stddev := mean_stddev([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	assert.Equal(t, 5.0, mean)
+	assert.InDelta(t, 2.138, stddev, 0.001)
+
+	mean, stddev = mean_stddev([]float64{3})
+	assert.Equal(t, 3.0, mean)
+	assert.Equal(t, 0.0, stddev)
+
+	mean, stddev = mean_stddev(nil)
+	assert.Equal(t, 0.0, mean)
+	assert.Equal(t, 0.0, stddev)
+}
+
+func Test_read_article_data__gzip(t *testing.T) {
+	tmp := t.TempDir()
+	article_json := `{"article": {"status": "vor", "id": "09560"}}`
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(article_json))
+	writer.Close()
+	gz_file := path.Join(tmp, "elife-09560-v1.xml.json.gz")
+	os.WriteFile(gz_file, buf.Bytes(), 0644)
+
+	schema_map := map[string]Schema{"VOR": {}}
+	article := read_article_data(gz_file, schema_map, Options{})
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, map[string]interface{}{"status": "vor", "id": "09560"}, article.Data)
+
+	bad_gz_file := path.Join(tmp, "elife-09561-v1.xml.json.gz")
+	os.WriteFile(bad_gz_file, []byte(article_json), 0644)
+	assert.NotNil(t, read_article_data(bad_gz_file, schema_map, Options{}).Error)
+}
+
+func Test_maybe_gunzip_reader(t *testing.T) {
+	article_json := `{"article": {"status": "vor", "id": "09560"}}`
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(article_json))
+	writer.Close()
+	gz_bytes := buf.Bytes()
+
+	cases := []struct {
+		data  []byte
+		force bool
+	}{
+		{[]byte(article_json), false},
+		{gz_bytes, false},
+		{gz_bytes, true},
+	}
+	for _, c := range cases {
+		reader, err := maybe_gunzip_reader(bytes.NewReader(c.data), c.force)
+		assert.Nil(t, err)
+		actual, err := io.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, article_json, string(actual))
+	}
+
+	_, err := maybe_gunzip_reader(strings.NewReader(article_json), true)
+	assert.NotNil(t, err)
+
+	// empty stdin isn't gzip and is left for the article-json reader to fail on.
+	reader, err := maybe_gunzip_reader(strings.NewReader(""), false)
+	assert.Nil(t, err)
+	actual, _ := io.ReadAll(reader)
+	assert.Empty(t, actual)
+}
+
+func Test_is_article_json_file(t *testing.T) {
+	assert.True(t, is_article_json_file("elife-09560-v1.xml.json", default_ext_list))
+	assert.True(t, is_article_json_file("elife-09560-v1.xml.json.gz", default_ext_list))
+	assert.False(t, is_article_json_file("elife-09560-v1.xml.json.bak", default_ext_list))
+	assert.False(t, is_article_json_file("elife-09560-v1.xml", default_ext_list))
+
+	ext_list := []string{".json.bak", ".json.processed"}
+	assert.True(t, is_article_json_file("elife-09560-v1.xml.json.bak", ext_list))
+	assert.True(t, is_article_json_file("elife-09560-v1.xml.json.processed", ext_list))
+	assert.False(t, is_article_json_file("elife-09560-v1.xml.json", ext_list))
+}
+
+func Test_Summary_String(t *testing.T) {
+	summary := Summary{Articles: 10, Workers: 12, WallTimeMs: 680, CpuTimeMs: 4570}
+	assert.Equal(t, "articles:10, failures:0, warnings:0, workers:12, wall-time:680ms, cpu-time:4s, cpu-time-per-article:457ms, parallelism:6.7", summary.String())
+
+	// nothing validated
+	summary = Summary{Workers: 12}
+	assert.Equal(t, int64(0), summary.CpuTimePerArticleMs())
+	assert.Equal(t, 0.0, summary.Parallelism())
+	assert.Equal(t, "articles:0, failures:0, warnings:0, workers:12, wall-time:0ms, cpu-time:0ms, cpu-time-per-article:0ms, parallelism:0.0", summary.String())
+
+	// timestamps of a batch
+	start_time := time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("", 3600))
+	summary = summarise([]Result{}, 12, start_time, start_time.Add(2*time.Second))
+	assert.Equal(t, "articles:0, failures:0, warnings:0, workers:12, wall-time:2s, cpu-time:0ms, cpu-time-per-article:0ms, parallelism:0.0, start:2024-03-01T09:30:00+01:00, end:2024-03-01T09:30:02+01:00", summary.String())
+}
+
+func Test_Summary_Failed(t *testing.T) {
+	assert.False(t, Summary{}.Failed(false))
+	assert.False(t, Summary{}.Failed(true))
+	assert.True(t, Summary{Failures: 1}.Failed(false))
+	assert.False(t, Summary{Warnings: 1}.Failed(false))
+	assert.True(t, Summary{Warnings: 1}.Failed(true))
+}
+
+// writes a minimal api-raml schema root with a POA and VOR schema to a temporary directory.
+// both schemas require an 'id' string and a 'status' of 'poa' or 'vor' respectively.
+// the third `allOf` is where the VOR ISBN patch is applied.
+func write_schema_root(t *testing.T) string {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		schema := `{"allOf": [
+			{"type": "object", "required": ["id", "status"]},
+			{"properties": {"id": {"type": "string"}, "status": {"enum": ["` + status + `"]}}},
+			{}
+		]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	return schema_root
+}
+
+func Test_Validator(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				result, err := validator.Validate([]byte(`{"article": {"id": "09560", "status": "vor"}}`))
+				assert.Nil(t, err)
+				assert.True(t, result.Success)
+				assert.Equal(t, "VOR", result.Type)
+			} else {
+				result, err := validator.Validate([]byte(`{"article": {"id": 9560, "status": "poa"}}`))
+				assert.Nil(t, err)
+				assert.False(t, result.Success)
+				assert.NotNil(t, result.Error)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = validator.Validate([]byte(`{"article": {"status": "retracted"}}`))
+	assert.NotNil(t, err)
+
+	_, err = validator.Validate([]byte(`{"journal": {}}`))
+	assert.NotNil(t, err)
+}
+
+func Test_Validator_ValidateValue(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	result, err := validator.ValidateValue("VOR", map[string]interface{}{"id": "09560", "status": "vor"})
+	assert.Nil(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, "VOR", result.Type)
+
+	result, err = validator.ValidateValue("poa", map[string]interface{}{"id": 9560.0, "status": "poa"})
+	assert.Nil(t, err)
+	assert.False(t, result.Success)
+	assert.NotNil(t, result.Error)
+
+	_, err = validator.ValidateValue("retracted", map[string]interface{}{})
+	assert.ErrorIs(t, err, ErrUnknownStatus)
+
+	// not a type `encoding/json` unmarshals into
+	_, err = validator.ValidateValue("VOR", map[string]interface{}{"id": []string{"09560"}, "status": "vor"})
+	assert.NotNil(t, err)
+}
+
+func Test_Validator_ValidateReader(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	article_json := `{"article": {"id": "09560", "status": "vor"}}`
+	result, err := validator.ValidateReader(strings.NewReader(article_json))
+	assert.Nil(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, len(article_json), result.Bytes)
+
+	result, err = validator.ValidateReader(strings.NewReader(`{"article": {"id": 9560, "status": "vor"}}`))
+	assert.Nil(t, err)
+	assert.False(t, result.Success)
+
+	read_err := errors.New("connection reset")
+	_, err = validator.ValidateReader(iotest.ErrReader(read_err))
+	assert.ErrorIs(t, err, read_err)
+}
+
+func Test_Validator_ValidateDirectory(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	for i := 1; i <= 20; i++ {
+		article_json := fmt.Sprintf(`{"article": {"id": "%05d", "status": "vor"}}`, i)
+		if i == 7 {
+			article_json = `{"article": {"id": 7, "status": "vor"}}`
+		}
+		os.WriteFile(path.Join(dir, fmt.Sprintf("elife-%05d-v1.xml.json", i)), []byte(article_json), 0644)
+	}
+
+	result_list, err := validator.ValidateDirectory(context.Background(), dir)
+	assert.Nil(t, err)
+	assert.Len(t, result_list, 20)
+	failures := []string{}
+	for _, result := range result_list {
+		if !result.Success {
+			failures = append(failures, result.FileName)
+		}
+	}
+	assert.Equal(t, []string{"elife-00007-v1.xml.json"}, failures)
+
+	// a cancelled context stops the batch before any files are read.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result_list, err = validator.ValidateDirectory(ctx, dir)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, result_list)
+
+	_, err = validator.ValidateDirectory(context.Background(), path.Join(dir, "elife-00001-v1.xml.json"))
+	assert.ErrorContains(t, err, "not a directory")
+
+	_, err = validator.ValidateDirectory(context.Background(), path.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a malformed file is a failed result, the rest of the directory is still validated.
+	os.WriteFile(path.Join(dir, "elife-00021-v1.xml.json"), []byte(`{"article": {"id": "00021", "status": `), 0644)
+	result_list, err = validator.ValidateDirectory(context.Background(), dir)
+	assert.Nil(t, err)
+	assert.Len(t, result_list, 21)
+	error_map := map[string]string{}
+	for _, result := range result_list {
+		if !result.Success && result.FileName != "elife-00007-v1.xml.json" {
+			error_map[result.FileName] = result.Error.Error()
+		}
+	}
+	assert.Equal(t, map[string]string{"elife-00021-v1.xml.json": "failed parsing article-json: not valid json"}, error_map)
+}
+
+func Test_use_color(t *testing.T) {
+	not_a_terminal, _ := os.Create(path.Join(t.TempDir(), "out.txt"))
+	defer not_a_terminal.Close()
+
+	assert.True(t, use_color("always", not_a_terminal))
+	assert.False(t, use_color("never", not_a_terminal))
+	assert.False(t, use_color("auto", not_a_terminal))
+
+	t.Setenv("NO_COLOR", "1")
+	assert.True(t, use_color("always", not_a_terminal))
+	assert.False(t, use_color("auto", not_a_terminal))
+}
+
+func Test_highlight_instance_locations(t *testing.T) {
+	msg := "[I#/title] [S#/properties/title/minLength] length must be >= 1, but got 0"
+	assert.Equal(t, msg, highlight_instance_locations(msg, false))
+	expected := "\033[36m[I#/title]\033[0m [S#/properties/title/minLength] length must be >= 1, but got 0"
+	assert.Equal(t, expected, highlight_instance_locations(msg, true))
+
+	msg = "jsonschema: '/title' does not validate with file:///POA#/properties/title/minLength: length must be >= 1, but got 0"
+	expected = "\033[36mjsonschema: '/title'\033[0m does not validate with file:///POA#/properties/title/minLength: length must be >= 1, but got 0"
+	assert.Equal(t, expected, highlight_instance_locations(msg, true))
+}
+
+func Test_read_article_data__unknown_status(t *testing.T) {
+	tmp_file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	os.WriteFile(tmp_file, []byte(`{"article": {"status": "retracted"}}`), 0644)
+
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+	article := read_article_data(tmp_file, schema_map, Options{})
+	assert.ErrorIs(t, article.Error, ErrUnknownStatus)
+	assert.Equal(t, "RETRACTED", article.Type)
+
+	result := validate_article(schema_map, article, false)
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, ErrUnknownStatus)
+}
+
+func Test_configure_validator__zip(t *testing.T) {
+	zip_file := path.Join(t.TempDir(), "api-raml.zip")
+	fh, _ := os.Create(zip_file)
+	writer := zip.NewWriter(fh)
+	file_map := map[string]string{
+		// github archives have a single top-level directory
+		"api-raml-master/dist/model/article-poa.v1.json": `{"$ref": "../misc/id.v1.json"}`,
+		"api-raml-master/dist/model/article-vor.v1.json": `{"allOf": [{"$ref": "../misc/id.v1.json"}, {}, {}]}`,
+		"api-raml-master/dist/misc/id.v1.json":           `{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}}}`,
+	}
+	for name, content := range file_map {
+		w, _ := writer.Create(name)
+		w.Write([]byte(content))
+	}
+	writer.Close()
+	fh.Close()
+
+	schema_map, err := configure_validator(zip_file, SchemaOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, schema_map["POA"].Schema.Validate(map[string]interface{}{"id": "09560"}))
+	assert.NotNil(t, schema_map["POA"].Schema.Validate(map[string]interface{}{"id": 9560}))
+	assert.NotNil(t, schema_map["VOR"].Schema.Validate(map[string]interface{}{}))
+}
+
+func Test_next_worker_count(t *testing.T) {
+	max_workers := 8
+	// first change scales up
+	workers, direction := next_worker_count(4, 0, 10, 100, 0, max_workers)
+	assert.Equal(t, []int{5, 1}, []int{workers, direction})
+	// throughput improved, keep scaling up
+	workers, direction = next_worker_count(5, 1, 10, 120, 100, max_workers)
+	assert.Equal(t, []int{6, 1}, []int{workers, direction})
+	// throughput dropped, reverse
+	workers, direction = next_worker_count(6, 1, 10, 90, 120, max_workers)
+	assert.Equal(t, []int{5, -1}, []int{workers, direction})
+	// no real difference, hold
+	workers, direction = next_worker_count(5, -1, 10, 91, 90, max_workers)
+	assert.Equal(t, []int{5, 0}, []int{workers, direction})
+	// feeder can't keep up, scale down
+	workers, direction = next_worker_count(5, 1, 0, 200, 100, max_workers)
+	assert.Equal(t, []int{4, -1}, []int{workers, direction})
+	// bounds
+	workers, _ = next_worker_count(1, 1, 0, 100, 100, max_workers)
+	assert.Equal(t, 1, workers)
+	workers, _ = next_worker_count(8, 1, 10, 200, 100, max_workers)
+	assert.Equal(t, 8, workers)
+}
+
+func Test_process_files_with_auto_workers(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	tmp := t.TempDir()
+	file_list := []string{}
+	for i := 0; i < 20; i++ {
+		file := path.Join(tmp, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+		os.WriteFile(file, []byte(`{"article": {"id": "00001", "status": "vor"}}`), 0644)
+		file_list = append(file_list, file)
+	}
+
+	_, _, result_list, num_workers := process_files_with_auto_workers(file_list, schema_map, Options{BufferSize: 5})
+	assert.Equal(t, 20, len(result_list))
+	assert.GreaterOrEqual(t, num_workers, 1)
+}
+
+func Test_process_files__cancelled(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	tmp := t.TempDir()
+	file_list := []string{}
+	for i := 0; i < 20; i++ {
+		file := path.Join(tmp, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+		os.WriteFile(file, []byte(`{"article": {"id": "00001", "status": "vor"}}`), 0644)
+		file_list = append(file_list, file)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := Options{BufferSize: 5, NumWorkers: 2, Context: ctx}
+
+	_, _, result_list := process_files_with_feeder(file_list, schema_map, opts)
+	assert.Equal(t, 0, len(result_list))
+
+	_, _, result_list, _ = process_files_with_auto_workers(file_list, schema_map, opts)
+	assert.Equal(t, 0, len(result_list))
+
+	summary := summarise(result_list, 2, time.Now(), time.Now())
+	assert.Contains(t, summary.String(), "articles:0, failures:0")
+}
+
+func Test_write_failures(t *testing.T) {
+	result_list := []Result{
+		{FileName: "elife-09561-v1.xml.json", Success: false},
+		{FileName: "elife-09560-v1.xml.json", Success: true},
+		{FileName: "list.json[2]", Success: false},
+		{FileName: "list.json[0]", Success: false},
+		{FileName: "elife-09559-v1.xml.json", Success: false},
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, write_failures(&buf, result_list, ""))
+	assert.Equal(t, "elife-09559-v1.xml.json\nelife-09561-v1.xml.json\nlist.json\n", buf.String())
+
+	// names relative to --relative-to are written as paths that can be read.
+	buf.Reset()
+	assert.Nil(t, write_failures(&buf, result_list, "path/to"))
+	assert.Equal(t, "path/to/elife-09559-v1.xml.json\npath/to/elife-09561-v1.xml.json\npath/to/list.json\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, write_failures(&buf, result_list[1:2], ""))
+	assert.Equal(t, "", buf.String())
+
+	result_list = append(result_list, Result{FileName: "odd name\nv1.json", Success: false})
+	buf.Reset()
+	assert.Nil(t, write_failures0(&buf, result_list, "path/to"))
+	expected := "path/to/elife-09559-v1.xml.json\x00path/to/elife-09561-v1.xml.json\x00path/to/list.json\x00path/to/odd name\nv1.json\x00"
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_write_metrics(t *testing.T) {
+	result_list := []Result{
+		{Type: "VOR", Elapsed: 30, Success: true},
+		{Type: "VOR", Elapsed: 700, Success: false},
+		{Type: "POA", Elapsed: 5, Success: true},
+	}
+	summary := Summary{Articles: 3, Failures: 1, WallTimeMs: 1500}
+	buf := bytes.Buffer{}
+	assert.Nil(t, write_metrics(&buf, summary, result_list))
+	metrics := buf.String()
+
+	expected_list := []string{
+		"# TYPE vaj_articles_total counter\nvaj_articles_total 3\n",
+		"vaj_failures_total 1\n",
+		"vaj_cache_hits_total 0\n",
+		"vaj_type_articles_total{type=\"POA\"} 1\nvaj_type_articles_total{type=\"VOR\"} 2\n",
+		"vaj_type_failures_total{type=\"POA\"} 0\nvaj_type_failures_total{type=\"VOR\"} 1\n",
+		"vaj_wall_time_seconds 1.5\n",
+		"vaj_validation_seconds_bucket{le=\"0.01\"} 1\n",
+		"vaj_validation_seconds_bucket{le=\"0.05\"} 2\n",
+		"vaj_validation_seconds_bucket{le=\"0.5\"} 2\n",
+		"vaj_validation_seconds_bucket{le=\"1\"} 3\n",
+		"vaj_validation_seconds_bucket{le=\"+Inf\"} 3\n",
+		"vaj_validation_seconds_sum 0.735\n",
+		"vaj_validation_seconds_count 3\n",
+	}
+	for _, expected := range expected_list {
+		assert.Contains(t, metrics, expected)
+	}
+}
+
+func Test_read_article_data__empty(t *testing.T) {
+	tmp_file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	os.WriteFile(tmp_file, []byte(" \n\t\n"), 0644)
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+
+	article := read_article_data(tmp_file, schema_map, Options{})
+	assert.ErrorIs(t, article.Error, ErrEmptyArticle)
+	result := validate_article(schema_map, article, false)
+	assert.False(t, result.Success)
+
+	article = read_article_data(tmp_file, schema_map, Options{AllowEmpty: true})
+	assert.Nil(t, article.Error)
+	result = validate_article(schema_map, article, false)
+	assert.True(t, result.Success)
+	assert.True(t, result.Skipped)
+	assert.Equal(t, 1, summarise([]Result{result}, 1, time.Now(), time.Now()).Skipped)
+}
+
+func Test_check_trailing_data(t *testing.T) {
+	assert.Nil(t, check_trailing_data([]byte(`{"article": {"status": "vor"}}`)))
+	assert.Nil(t, check_trailing_data([]byte(" {\"article\": {}}\n\n")))
+	assert.Nil(t, check_trailing_data([]byte(`[1, 2]`)))
+	// invalid json isn't trailing data.
+	assert.Nil(t, check_trailing_data([]byte(`{"article": `)))
+
+	doc := `{"article": {"status": "vor"}}`
+	assert.EqualError(t, check_trailing_data([]byte(doc+doc)), "trailing data after json document: at byte 30")
+	assert.ErrorIs(t, check_trailing_data([]byte(doc+"\n}")), ErrTrailingData)
+	assert.ErrorIs(t, check_trailing_data([]byte(`"vor" garbage`)), ErrTrailingData)
+
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+	article := prepare_article("elife-09560-v1.xml.json", []byte(doc+doc), schema_map, Options{})
+	assert.ErrorIs(t, article.Error, ErrTrailingData)
+}
+
+func Test_prepare_article__force_type(t *testing.T) {
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+	article_json := []byte(`{"article": {"id": "09560"}}`)
+	article := prepare_article("elife-09560-v1.xml.json", article_json, schema_map, Options{ForceType: "VOR"})
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, map[string]interface{}{"id": "09560"}, article.Data)
+
+	// the status is ignored when there is one.
+	article_json = []byte(`{"article": {"id": "09560", "status": "poa"}}`)
+	article = prepare_article("elife-09560-v1.xml.json", article_json, schema_map, Options{ForceType: "VOR"})
+	assert.Equal(t, "VOR", article.Type)
+
+	article = prepare_article("elife-09560-v1.xml.json", article_json, schema_map, Options{ForceType: "VOR", NoExtract: true})
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, "09560", article.ID())
+
+	assert.Nil(t, check_ndjson_line([]byte(`{"article": {}}`), Options{ForceType: "VOR"}))
+	assert.NotNil(t, check_ndjson_line([]byte(`{"article": {}}`), Options{}))
+}
+
+func Test_error_severity(t *testing.T) {
+	schema_root := write_schema_root(t)
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+
+	// 'id' is required and must be a string.
+	article := Article{Type: "VOR", Data: map[string]interface{}{"status": "vor"}}
+	result := validate_article(schema_map, article, false)
+	assert.Equal(t, "high", result.Severity)
+
+	article = Article{Type: "VOR", Data: map[string]interface{}{"id": 9560.0, "status": "vor"}}
+	assert.Equal(t, "high", validate_article(schema_map, article, false).Severity)
+
+	article = Article{Type: "VOR", FileName: "elife-09560-v1.xml.json", Error: ErrEmptyArticle}
+	assert.Equal(t, "high", validate_article(schema_map, article, false).Severity)
+
+	assert.Equal(t, "medium", error_severity(&jsonschema.ValidationError{KeywordLocation: "/properties/title/minLength"}))
+	assert.Equal(t, "low", error_severity(&jsonschema.ValidationError{KeywordLocation: "/not"}))
+	ve := &jsonschema.ValidationError{KeywordLocation: "/allOf", Causes: []*jsonschema.ValidationError{
+		{KeywordLocation: "/allOf/0/not"},
+		{KeywordLocation: "/allOf/1/properties/doi/pattern"},
+	}}
+	assert.Equal(t, "medium", error_severity(ve))
+
+	summary := summarise([]Result{
+		{Success: false, Severity: "high"},
+		{Success: false, Severity: "medium"},
+		{Success: false, Severity: "medium"},
+		{Success: true},
+	}, 1, time.Time{}, time.Time{})
+	assert.Equal(t, map[string]int{"high": 1, "medium": 2}, summary.Severity)
+	assert.Equal(t, "failures by severity: high:1, medium:2, low:0", severity_summary(summary))
+}
+
+func Test_prepare_article__malformed(t *testing.T) {
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+	article := prepare_article("elife-09560-v1.xml.json", []byte(`{"article": {"status": `), schema_map, Options{})
+	assert.EqualError(t, article.Error, "failed parsing article-json: not valid json")
+
+	article = prepare_article("elife-09560-v1.xml.json", []byte(`{"article": {"id": "09560"}}`), schema_map, Options{})
+	assert.EqualError(t, article.Error, "failed parsing article-json: 'article.status' field in article data not found")
+
+	article_list := prepare_articles("dump.json", []byte(`[{"article": {"status": "vor"}}, {"journal": {}}]`), schema_map, Options{})
+	assert.Len(t, article_list, 2)
+	assert.Nil(t, article_list[0].Error)
+	assert.ErrorContains(t, article_list[1].Error, "field in article data not found")
+}
+
+func Test_check_utf8(t *testing.T) {
+	assert.Nil(t, check_utf8([]byte(`{"title": "Café \ufffd"}`)))
+	assert.Nil(t, check_utf8([]byte("{\"title\": \"Café �\"}")))
+	assert.EqualError(t, check_utf8([]byte("{\"title\": \"Caf\xe9\"}")), "invalid UTF-8: at byte 14")
+
+	doc := []byte("{\"article\": {\"status\": \"vor\", \"title\": \"\xff\"}}")
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+	assert.Nil(t, prepare_article("elife-09560-v1.xml.json", doc, schema_map, Options{}).Error)
+	article := prepare_article("elife-09560-v1.xml.json", doc, schema_map, Options{CheckUTF8: true})
+	assert.ErrorIs(t, article.Error, ErrInvalidUTF8)
+}
+
+func Test_read_article_data__max_file_size(t *testing.T) {
+	tmp_file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	os.WriteFile(tmp_file, []byte(`{"article": {"status": "vor"}}`), 0644)
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+
+	article := read_article_data(tmp_file, schema_map, Options{MaxFileSize: 30})
+	assert.Nil(t, article.Error)
+
+	article = read_article_data(tmp_file, schema_map, Options{MaxFileSize: 29})
+	assert.ErrorIs(t, article.Error, ErrFileTooLarge)
+	assert.EqualError(t, article.Error, "file too large: 30 bytes, over the --max-file-size of 29 bytes")
+	assert.Equal(t, 30, article.Bytes)
+	article_list := read_articles(tmp_file, schema_map, Options{MaxFileSize: 29})
+	assert.ErrorIs(t, article_list[0].Error, ErrFileTooLarge)
+
+	result := validate_article(schema_map, article, false)
+	assert.False(t, result.Success)
+	summary := summarise([]Result{result, {Success: false}}, 1, time.Time{}, time.Time{})
+	assert.Equal(t, 2, summary.Failures)
+	assert.Equal(t, 1, summary.TooLarge)
+	assert.Contains(t, summary.String(), ", too-large:1,")
+}
+
+func Test_read_article_data__keep_going(t *testing.T) {
+	tmp_file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+
+	assert.Panics(t, func() { read_article_data(tmp_file, schema_map, Options{}) })
+
+	article := read_article_data(tmp_file, schema_map, Options{KeepGoing: true})
+	assert.Equal(t, tmp_file, article.FileName)
+	assert.ErrorIs(t, article.Error, os.ErrNotExist)
+	assert.Contains(t, article.Error.Error(), "failed reading file: ")
+	article_list := read_articles(tmp_file, schema_map, Options{KeepGoing: true})
+	assert.ErrorIs(t, article_list[0].Error, os.ErrNotExist)
+
+	_, _, result_list := process_files_with_feeder([]string{tmp_file}, schema_map, Options{BufferSize: 1, NumWorkers: 1, KeepGoing: true})
+	assert.Equal(t, 1, len(result_list))
+	assert.False(t, result_list[0].Success)
+}
+
+func Test_find_definition(t *testing.T) {
+	schema := `{
+		"definitions": {"person": {}},
+		"allOf": [{}, {}, {"properties": {"references": {"items": {"definitions": {"book": {}, "person": {}}}}}}],
+		"properties": {"a/b": {"$defs": {"book": {}}}}
+	}`
+	pointer, err := find_definition([]byte(schema), "person")
+	assert.Nil(t, err)
+	assert.Equal(t, "/definitions/person", pointer)
+
+	pointer, err = find_definition([]byte(schema), "book")
+	assert.Nil(t, err)
+	assert.Equal(t, "/properties/a~1b/$defs/book", pointer)
+
+	_, err = find_definition([]byte(schema), "journal")
+	assert.NotNil(t, err)
+}
+
+func Test_parse_schema_version(t *testing.T) {
+	cases := map[string]int{
+		"/path/to/api-raml/dist/model/article-vor.v7.json":                   7,
+		"dist/model/article-poa.v10.json":                                    10,
+		"dist/model/article-vor.v7.json#/definitions/book":                   7,
+		"zip:///api-raml-master/dist/model/article-vor.v12.json#/$defs/book": 12,
+	}
+	for given, expected := range cases {
+		version, ok := parse_schema_version(given)
+		assert.True(t, ok)
+		assert.Equal(t, expected, version)
+	}
+	_, ok := parse_schema_version("dist/model/article-vor.json")
+	assert.False(t, ok)
+}
+
+func Test_json_depth(t *testing.T) {
+	cases := map[string]int{
+		`"foo"`:                        0,
+		`{}`:                           1,
+		`{"a": [1, {"b": []}]}`:        4,
+		`{"a": "[[[{{{"}`:              1,
+		`{"a": "\"[[[", "b": [[]]}`:    3,
+		`[{"a": "\\"}, [[["\\\\["]]]]`: 4,
+	}
+	for given, expected := range cases {
+		assert.Equal(t, expected, json_depth([]byte(given), 100), given)
+	}
+
+	deep := strings.Repeat("[", 5000) + strings.Repeat("]", 5000)
+	assert.Equal(t, 11, json_depth([]byte(deep), 10))
+}
+
+func Test_start_sqlite_writer(t *testing.T) {
+	db_path := path.Join(t.TempDir(), "results.db")
+	schema_map := map[string]Schema{"VOR": {Path: "dist/model/article-vor.v7.json"}}
+	result_chan, close_fn, err := start_sqlite_writer(db_path, schema_map, 10)
+	assert.Nil(t, err)
+	result_chan <- Result{Type: "VOR", FileName: "elife-09560-v1.xml.json", Elapsed: 12, Success: true, Bytes: 100}
+	result_chan <- Result{Type: "VOR", FileName: "elife-'quoted'-v1.xml.json", Elapsed: 34, Success: false, Bytes: 200, ErrorCount: 3, Hash: "abc123"}
+	assert.Nil(t, close_fn())
+
+	// results are appended to an existing database.
+	result_chan, close_fn, err = start_sqlite_writer(db_path, schema_map, 10)
+	assert.Nil(t, err)
+	result_chan <- Result{Type: "POA", FileName: "elife-09561-v1.xml.json", Elapsed: 56, Success: true, Bytes: 300}
+	assert.Nil(t, close_fn())
+
+	db, err := sql.Open("sqlite", db_path)
+	assert.Nil(t, err)
+	defer db.Close()
+	rows, err := db.Query("SELECT filename, type, elapsed_ms, bytes, success, error_count, schema_version, ifnull(hash, '') FROM results ORDER BY elapsed_ms")
+	assert.Nil(t, err)
+	defer rows.Close()
+	row_list := []string{}
+	for rows.Next() {
+		var filename, article_type, hash string
+		var elapsed_ms, bytes, success, error_count, schema_version int
+		assert.Nil(t, rows.Scan(&filename, &article_type, &elapsed_ms, &bytes, &success, &error_count, &schema_version, &hash))
+		row_list = append(row_list, fmt.Sprintf("%s|%s|%d|%d|%d|%d|%d|%s", filename, article_type, elapsed_ms, bytes, success, error_count, schema_version, hash))
+	}
+	expected := []string{
+		"elife-09560-v1.xml.json|VOR|12|100|1|0|7|",
+		"elife-'quoted'-v1.xml.json|VOR|34|200|0|3|7|abc123",
+		"elife-09561-v1.xml.json|POA|56|300|1|0|0|",
+	}
+	assert.Equal(t, expected, row_list)
+
+	_, _, err = start_sqlite_writer(path.Join(t.TempDir(), "missing", "results.db"), schema_map, 10)
+	assert.NotNil(t, err)
+}
+
+func Test_start_manifest_writer(t *testing.T) {
+	manifest_path := path.Join(t.TempDir(), "manifest.csv")
+	result_chan, close_fn, err := start_manifest_writer(manifest_path, 10)
+	assert.Nil(t, err)
+	result_chan <- Result{Type: "VOR", FileName: "elife-09560-v1.xml.json", Success: true, Bytes: 100, Hash: "abc123"}
+	result_chan <- Result{Type: "POA", FileName: "elife-09561, v1.xml.json", Success: false, Bytes: 200, ErrorCount: 3}
+	assert.Nil(t, close_fn())
+
+	data, err := os.ReadFile(manifest_path)
+	assert.Nil(t, err)
+	expected := "filename,type,success,error_count,bytes,hash\n" +
+		"elife-09560-v1.xml.json,VOR,true,0,100,abc123\n" +
+		"\"elife-09561, v1.xml.json\",POA,false,3,200,\n"
+	assert.Equal(t, expected, string(data))
+
+	_, _, err = start_manifest_writer(path.Join(t.TempDir(), "missing", "manifest.csv"), 10)
+	assert.NotNil(t, err)
+}
+
+func Test_start_progress_writer(t *testing.T) {
+	buf := bytes.Buffer{}
+	result_chan, close_fn := start_progress_writer(&buf, time.Hour, 10)
+	result_chan <- Result{FileName: "elife-09560-v1.xml.json", Success: true}
+	result_chan <- Result{FileName: "elife-09561-v1.xml.json", Success: false}
+	assert.Nil(t, close_fn())
+	// only the final totals within the interval.
+	assert.True(t, strings.HasPrefix(buf.String(), "progress: articles:2, failures:1, "), buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+}
+
+func Test_result_sinks(t *testing.T) {
+	sinks := result_sinks{}
+	assert.Nil(t, sinks.start(10))
+
+	received := [][]string{{}, {}}
+	closed := []int{}
+	for i := range received {
+		i := i
+		result_chan := make(chan Result, 10)
+		sinks.add(result_chan, func() {
+			close(result_chan)
+			for result := range result_chan {
+				received[i] = append(received[i], result.FileName)
+			}
+			closed = append(closed, i)
+		})
+	}
+	result_chan := sinks.start(10)
+	result_chan <- Result{FileName: "elife-09560-v1.xml.json"}
+	result_chan <- Result{FileName: "elife-09561-v1.xml.json"}
+	sinks.close()
+	expected := []string{"elife-09560-v1.xml.json", "elife-09561-v1.xml.json"}
+	assert.Equal(t, [][]string{expected, expected}, received)
+	assert.Equal(t, []int{0, 1}, closed)
+}
+
+func Test_start_report_writer(t *testing.T) {
+	written := []string{}
+	result_chan, close_fn := start_report_writer(func(result_list []Result) error {
+		for _, result := range result_list {
+			written = append(written, result.FileName)
+		}
+		return nil
+	}, 10)
+	result_chan <- Result{FileName: "elife-09561-v1.xml.json"}
+	result_chan <- Result{FileName: "elife-09560-v1.xml.json"}
+	// nothing is written until every result is in.
+	assert.Equal(t, []string{}, written)
+	assert.Nil(t, close_fn())
+	assert.Equal(t, []string{"elife-09561-v1.xml.json", "elife-09560-v1.xml.json"}, written)
+
+	_, close_fn = start_report_writer(func([]Result) error { return errors.New("failed") }, 10)
+	assert.EqualError(t, close_fn(), "failed")
+}
+
+func Test_do__json_report_sink(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	os.WriteFile(path.Join(input_path, "elife-00001-v1.xml.json"), []byte(`{"article": {"id": "00001", "status": "vor"}}`), 0644)
+	os.WriteFile(path.Join(input_path, "elife-00002-v1.xml.json"), []byte(`{"article": {"status": "vor"}}`), 0644)
+	output_file := path.Join(t.TempDir(), "report.json")
+
+	// the json report and --progress are both sent every result.
+	stdout, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path,
+		"--output", "json", "--output-file", output_file, "--progress")
+	assert.Equal(t, 1, code, stderr)
+	assert.Equal(t, "", stdout)
+	assert.Contains(t, stderr, "progress: articles:2, failures:1")
+	data, err := os.ReadFile(output_file)
+	assert.Nil(t, err)
+	report := Report{}
+	assert.Nil(t, json.Unmarshal(data, &report))
+	assert.Equal(t, 2, report.Summary.Articles)
+	assert.Equal(t, 1, report.Summary.Failures)
+	assert.Equal(t, path.Join(input_path, "elife-00001-v1.xml.json"), gjson.GetBytes(data, "results.0.file_name").String())
+	assert.Equal(t, path.Join(input_path, "elife-00002-v1.xml.json"), gjson.GetBytes(data, "results.1.file_name").String())
+
+	// a single file is reported to stdout.
+	stdout, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", path.Join(input_path, "elife-00001-v1.xml.json"), "--output", "json")
+	assert.Equal(t, 0, code, stderr)
+	report = Report{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, 1, report.Summary.Articles)
+	assert.Equal(t, 1, len(report.Results))
+}
+
+func Test_write_json_report__pretty(t *testing.T) {
+	result_list := []Result{
+		{Type: "VOR", FileName: "elife-09561-v1.xml.json", Success: true},
+		{Type: "VOR", FileName: "elife-09560-v1.xml.json", Success: true},
+	}
+	compact := bytes.Buffer{}
+	assert.Nil(t, write_json_report(&compact, Meta{}, Summary{Articles: 2}, result_list, nil, false))
+	pretty := bytes.Buffer{}
+	assert.Nil(t, write_json_report(&pretty, Meta{}, Summary{Articles: 2}, result_list, nil, true))
+
+	// compact is a single line, pretty is indented with two spaces.
+	assert.Equal(t, 1, strings.Count(compact.String(), "\n"))
+	assert.True(t, strings.HasPrefix(pretty.String(), "{\n  \"meta\": {\n"), pretty.String())
+	assert.Contains(t, pretty.String(), "\n  \"results\": [\n    {\n      \"type\": \"VOR\",\n")
+
+	// the same report either way.
+	indented := bytes.Buffer{}
+	assert.Nil(t, json.Indent(&indented, compact.Bytes(), "", "  "))
+	assert.Equal(t, pretty.String(), indented.String())
+	assert.Equal(t, "elife-09560-v1.xml.json", gjson.GetBytes(pretty.Bytes(), "results.0.file_name").String())
+}
+
+func Test_do__pretty(t *testing.T) {
+	schema_root := write_schema_root(t)
+	file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	os.WriteFile(file, []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+
+	stdout, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", file, "--output", "json")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, 1, strings.Count(stdout, "\n"))
+
+	stdout, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", file, "--output", "json", "--pretty")
+	assert.Equal(t, 0, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "{\n  \"meta\": {\n"), stdout)
+	assert.True(t, json.Valid([]byte(stdout)))
+
+	stdout, _, code = run_command(t, "--schema-root", schema_root, "--article-json", file, "--pretty")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout, "--pretty can only be used with --output json")
+}
+
+func Test_start_socket_writer(t *testing.T) {
+	// unix socket paths are limited to ~100 characters, too short for some temporary directories.
+	tmp, err := os.MkdirTemp("", "vaj")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	socket_path := path.Join(tmp, "vaj.sock")
+
+	// nothing listening
+	result_chan, close_fn := start_socket_writer(socket_path, 10)
+	result_chan <- Result{FileName: "elife-09560-v1.xml.json", Success: true}
+	assert.EqualError(t, close_fn(), "1 results were not written, nothing was listening")
+
+	listener, err := net.Listen("unix", socket_path)
+	assert.Nil(t, err)
+	defer listener.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	result_chan, close_fn = start_socket_writer(socket_path, 10)
+	result_chan <- Result{FileName: "elife-09560-v1.xml.json", Success: true}
+	result_chan <- Result{FileName: "elife-09561-v1.xml.json", Success: false}
+	assert.Nil(t, close_fn())
+	lines := strings.Split(strings.TrimSpace(<-received), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, "elife-09561-v1.xml.json", gjson.Get(lines[1], "file_name").String())
+}
+
+func Test_compare_reports(t *testing.T) {
+	old_report := []ReportEntry{
+		{FileName: "a.json", Success: false, ErrorCount: 2},
+		{FileName: "b.json", Success: true},
+		{FileName: "c.json", Success: false, ErrorCount: 2},
+		// a report without 'error_count'
+		{FileName: "d.json", Success: false, Errors: []ErrorDetail{{Causes: []ErrorDetail{{}, {}}}}},
+		{FileName: "e.json", Success: true},
+	}
+	new_report := []ReportEntry{
+		{FileName: "a.json", Success: true},
+		{FileName: "b.json", Success: false, ErrorCount: 1},
+		{FileName: "c.json", Success: false, ErrorCount: 5},
+		{FileName: "d.json", Success: false, ErrorCount: 2},
+		{FileName: "f.json", Success: true},
+	}
+	expected := ReportDiff{
+		NewlyPassing:      []string{"a.json"},
+		NewlyFailing:      []string{"b.json"},
+		ErrorCountChanged: []ErrorCountChange{{FileName: "c.json", Old: 2, New: 5}},
+		OnlyInOld:         []string{"e.json"},
+		OnlyInNew:         []string{"f.json"},
+	}
+	assert.Equal(t, expected, compare_reports(old_report, new_report))
+}
+
+func Test_git_changed_files(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("'git' command not available")
+	}
+	repo_dir := t.TempDir()
+	git := func(arg_list ...string) {
+		arg_list = append([]string{"-C", repo_dir, "-c", "user.name=test", "-c", "user.email=test@example.org"}, arg_list...)
+		out, err := exec.Command("git", arg_list...).CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+	input_path := path.Join(repo_dir, "articles")
+	assert.Nil(t, os.Mkdir(input_path, 0755))
+	write := func(name string) {
+		assert.Nil(t, os.WriteFile(path.Join(input_path, name), []byte("{}"), 0644))
+	}
+
+	git("init", "--quiet")
+	write("elife-00001-v1.xml.json")
+	write("elife-00002-v1.xml.json")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "initial")
+
+	assert.True(t, is_git_work_tree(input_path))
+	assert.False(t, is_git_work_tree(t.TempDir()))
+
+	assert.Nil(t, os.WriteFile(path.Join(input_path, "elife-00002-v1.xml.json"), []byte(`{"article":{}}`), 0644))
+	write("elife-00003-v1.xml.json")
+
+	changed_map, err := git_changed_files(input_path, "HEAD")
+	assert.Nil(t, err)
+	expected := map[string]bool{
+		path.Join(input_path, "elife-00002-v1.xml.json"): true,
+		path.Join(input_path, "elife-00003-v1.xml.json"): true,
+	}
+	assert.Equal(t, expected, changed_map)
+
+	file_list := list_files(input_path, -1, default_ext_list)
+	assert.Equal(t, []string{path.Join(input_path, "elife-00003-v1.xml.json"), path.Join(input_path, "elife-00002-v1.xml.json")}, filter_files(file_list, changed_map))
+
+	_, err = git_changed_files(input_path, "no-such-ref")
+	assert.NotNil(t, err)
+}
+
+func Test_do__changed_since_before_sample_size(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("'git' command not available")
+	}
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	git := func(arg_list ...string) {
+		arg_list = append([]string{"-C", input_path, "-c", "user.name=test", "-c", "user.email=test@example.org"}, arg_list...)
+		out, err := exec.Command("git", arg_list...).CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+	write := func(id string) {
+		assert.Nil(t, os.WriteFile(path.Join(input_path, "elife-"+id+"-v1.xml.json"), []byte(`{"article": {"id": "`+id+`", "status": "vor"}}`), 0644))
+	}
+
+	git("init", "--quiet")
+	write("00001")
+	write("00002")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "initial")
+	write("00003")
+
+	// the sample is taken from the changed files, not the changed files from the sample.
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--changed-since", "HEAD", "--sample-size", "1")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "elife-00003-v1.xml.json")
+	assert.NotContains(t, stderr, "no article-json files changed")
+}
+
+func Test_check_label_sequence(t *testing.T) {
+	article := `{"body": [
+		{"type": "figure", "assets": [
+			{"id": "fig1", "label": "Figure 1"},
+			{"id": "fig1s1", "label": "Figure 1—figure supplement 1"},
+			{"id": "fig1s2", "label": "Figure 1—figure supplement 2"}
+		]},
+		{"type": "table", "id": "table1", "label": "Table 1"},
+		{"type": "figure", "assets": [{"id": "fig3", "label": "Figure 3"}]},
+		{"type": "figure", "assets": [{"id": "fig4", "label": "Figure 4"}]},
+		{"type": "table", "id": "table1a", "label": "Table 1"},
+		{"type": "table", "id": "keyresource", "label": "Key resources table"}
+	]}`
+	// unnumbered labels are ignored
+	expected := []string{
+		"label 'Figure 3' at '/body/2/assets/0' is out of sequence, expected 'Figure 2'",
+		"duplicate label 'Table 1' at '/body/4', first seen at '/body/1'",
+	}
+	assert.Equal(t, expected, check_label_sequence([]byte(article)))
+
+	article = `{"body": [{"id": "fig1", "label": "Figure 1"}, {"id": "fig2", "label": "Figure 2"}]}`
+	assert.Equal(t, []string{}, check_label_sequence([]byte(article)))
+
+	// keys are walked in document order, not lexical order.
+	article = `{"body": [{"id": "fig1", "label": "Figure 1"}], "appendices": [{"id": "fig2", "label": "Figure 2"}]}`
+	assert.Equal(t, []string{}, check_label_sequence([]byte(article)))
+	article = `{"body": [{"id": "fig2", "label": "Figure 2"}], "appendices": [{"id": "fig1", "label": "Figure 1"}]}`
+	expected = []string{
+		"label 'Figure 2' at '/body/0' is out of sequence, expected 'Figure 1'",
+		"label 'Figure 1' at '/appendices/0' is out of sequence, expected 'Figure 3'",
+	}
+	assert.Equal(t, expected, check_label_sequence([]byte(article)))
+}
+
+func Test_check_affiliations(t *testing.T) {
+	article := map[string]interface{}{
+		"affiliations": []interface{}{
+			map[string]interface{}{"id": "aff1", "name": []interface{}{"University of Cambridge"}},
+			map[string]interface{}{"id": "aff2", "name": []interface{}{"eLife Sciences"}},
+		},
+		"authors": []interface{}{
+			map[string]interface{}{"type": "person", "affiliations": []interface{}{"aff1", "aff3"}},
+			map[string]interface{}{"type": "group", "people": []interface{}{
+				map[string]interface{}{"type": "person", "affiliations": []interface{}{"aff2", "aff4"}},
+			}},
+			// inline affiliations are not references
+			map[string]interface{}{"type": "person", "affiliations": []interface{}{
+				map[string]interface{}{"name": []interface{}{"Elsewhere"}},
+			}},
+		},
+	}
+	expected := []string{
+		"affiliation 'aff3' at '/authors/0/affiliations/1' is not defined",
+		"affiliation 'aff4' at '/authors/1/people/0/affiliations/1' is not defined",
+	}
+	assert.Equal(t, expected, check_affiliations(article))
+
+	article = map[string]interface{}{
+		"authors": []interface{}{
+			map[string]interface{}{"type": "person", "affiliations": []interface{}{
+				map[string]interface{}{"name": []interface{}{"University of Cambridge"}},
+			}},
+		},
+	}
+	assert.Equal(t, []string{}, check_affiliations(article))
+}
+
+func Test_check_date_order(t *testing.T) {
+	article := map[string]interface{}{
+		"history": map[string]interface{}{
+			"received": "2016-03-01",
+			"accepted": "2016-02-01",
+		},
+		"published":   "2016-04-01T00:00:00Z",
+		"versionDate": "2016-05-01T00:00:00Z",
+	}
+	expected := []string{"'accepted' at '/history/accepted' (2016-02-01) is before 'received' at '/history/received' (2016-03-01)"}
+	assert.Equal(t, expected, check_date_order(article))
+
+	article = map[string]interface{}{
+		"published":   "2016-04-01T00:00:00Z",
+		"versionDate": "2016-03-01T00:00:00Z",
+		"received":    "not a date",
+	}
+	expected = []string{"'versionDate' at '/versionDate' (2016-03-01) is before 'published' at '/published' (2016-04-01)"}
+	assert.Equal(t, expected, check_date_order(article))
+
+	article = map[string]interface{}{"published": "2016-04-01T00:00:00Z", "versionDate": "2016-04-01T00:00:00Z"}
+	assert.Equal(t, []string{}, check_date_order(article))
+}
+
+func Test_check_filename_id(t *testing.T) {
+	article, err := parse_article_data("path/to/elife-09560-v1.xml.json", []byte(`{"article": {"id": "09560", "version": 1, "status": "poa"}}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, check_filename_id(article))
+
+	article.FileName = "path/to/elife-9560-v1.xml.json"
+	assert.Equal(t, []string{}, check_filename_id(article))
+
+	article.FileName = "path/to/elife-09561-v2.xml.json"
+	expected := []string{
+		"'/id' is '09560' but the filename has article id '09561'",
+		"'/version' is 1 but the filename has version 2",
+	}
+	assert.Equal(t, expected, check_filename_id(article))
+
+	article.FileName = "path/to/article.json"
+	assert.Equal(t, []string{}, check_filename_id(article))
+
+	expected = []string{"filename-id: '/version' is 1 but the filename has version 2"}
+	article.FileName = "elife-09560-v2.xml.json"
+	assert.Equal(t, expected, run_extra_checks([]string{"filename-id"}, article, nil))
+}
+
+func Test_Article_accessors(t *testing.T) {
+	document := `{"article": {"id": "09560", "version": 2, "status": "vor", "title": "A <i>title</i>"}, "journal": {"id": "elife"}}`
+	article, err := parse_article_data("elife-09560-v2.xml.json", []byte(document), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "09560", article.ID())
+	assert.Equal(t, 2, article.Version())
+	assert.Equal(t, "vor", article.Status())
+	assert.Equal(t, "A <i>title</i>", article.Title())
+
+	// the whole document is validated but the fields still come from the 'article' section.
+	article, err = parse_document_data("elife-09560-v2.xml.json", []byte(document), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "09560", article.ID())
+	assert.Equal(t, "vor", article.Status())
+
+	// missing fields are zero values.
+	assert.Equal(t, "", Article{}.ID())
+	assert.Equal(t, 0, Article{}.Version())
+}
+
+func Test_check_deprecated(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		schema := `{"allOf": [
+			{"properties": {
+				"impactStatement": {"type": "string", "x-deprecated": "use 'abstract' instead"},
+				"keywords": {"items": {"properties": {"old": {"x-deprecated": true}, "new": {"x-deprecated": false}}}}
+			}},
+			{}, {}
+		]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+
+	article, err := parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {
+		"status": "vor",
+		"impactStatement": "foo",
+		"keywords": [{"new": 1}, {"old": 1, "new": 2}]
+	}}`), "")
+	assert.Nil(t, err)
+	result := check_article(schema_map, article, Options{ExtraChecks: []string{"deprecated"}})
+	assert.True(t, result.Success)
+	expected := []string{
+		"deprecated: '/impactStatement' is deprecated: use 'abstract' instead",
+		"deprecated: '/keywords/1/old' is deprecated",
+	}
+	assert.Equal(t, expected, result.Warnings)
+
+	// the keyword must be a boolean or message.
+	os.WriteFile(path.Join(model_dir, "article-poa.v1.json"), []byte(`{"x-deprecated": 1}`), 0644)
+	_, err = configure_validator(schema_root, SchemaOptions{})
+	assert.NotNil(t, err)
+}
+
+func Test_compile_version_schemas(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	// v1 requires an 'id', v2 requires a 'title' too.
+	for _, status := range []string{"poa", "vor"} {
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(`{"allOf": [{"required": ["id"]}, {}, {}]}`), 0644)
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v2.json"), []byte(`{"allOf": [{"required": ["id", "title"]}, {}, {}]}`), 0644)
+	}
+
+	version_schema_map, version_error_map := compile_version_schemas(schema_root, SchemaOptions{}, schema_version_name_list)
+	assert.Empty(t, version_error_map)
+	assert.Equal(t, "POA v2, VOR v2", schema_map_versions(version_schema_map["latest"]))
+	assert.Equal(t, "POA v1, VOR v1", schema_map_versions(version_schema_map["prev"]))
+
+	article_json := []byte(`{"article": {"id": "09560", "status": "vor"}}`)
+	article := prepare_article("elife-09560-v1.xml.json", article_json, version_schema_map["latest"], Options{})
+	expected := DraftProbe{
+		FileName: "elife-09560-v1.xml.json",
+		Accepted: []string{"prev"},
+		Rejected: []string{"latest"},
+	}
+	assert.Equal(t, expected, probe_schemas(article, version_schema_map, schema_version_name_list, Options{}))
+
+	article_json = []byte(`{"article": {"id": "09560", "status": "poa", "title": "foo"}}`)
+	article = prepare_article("elife-09560-v1.xml.json", article_json, version_schema_map["latest"], Options{})
+	assert.Equal(t, []string{"latest", "prev"}, probe_schemas(article, version_schema_map, schema_version_name_list, Options{}).Accepted)
+
+	// versions are compared as numbers, "v10" is later than "v2".
+	os.WriteFile(path.Join(model_dir, "article-vor.v10.json"), []byte(`{"allOf": [{"required": ["id"]}, {}, {}]}`), 0644)
+	version_schema_map, version_error_map = compile_version_schemas(schema_root, SchemaOptions{}, schema_version_name_list)
+	assert.Empty(t, version_error_map)
+	assert.Equal(t, "POA v2, VOR v10", schema_map_versions(version_schema_map["latest"]))
+	assert.Equal(t, "POA v1, VOR v2", schema_map_versions(version_schema_map["prev"]))
+
+	// a schema with just the one version has no previous version.
+	os.Remove(path.Join(model_dir, "article-poa.v1.json"))
+	_, version_error_map = compile_version_schemas(schema_root, SchemaOptions{}, schema_version_name_list)
+	assert.ErrorContains(t, version_error_map["prev"], "no version of the POA schema found lower than dist/model/article-poa.v2.json")
+}
+
+func Test_probe_drafts(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		// 'const' is ignored by draft 4, the schema's own '$schema' is ignored too.
+		schema := `{"$schema": "http://json-schema.org/draft-04/schema#", "allOf": [
+			{"type": "object", "required": ["id", "status"]},
+			{"properties": {"id": {"const": "09560"}, "status": {"enum": ["` + status + `"]}}},
+			{}
+		]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	draft_schema_map, draft_error_map := compile_draft_schemas(schema_root, SchemaOptions{})
+	assert.Empty(t, draft_error_map)
+	assert.Equal(t, len(draft_name_list), len(draft_schema_map))
+
+	article_json := []byte(`{"article": {"id": "09561", "status": "vor"}}`)
+	article := prepare_article("elife-09561-v1.xml.json", article_json, draft_schema_map["4"], Options{})
+	expected := DraftProbe{
+		FileName: "elife-09561-v1.xml.json",
+		Accepted: []string{"4"},
+		Rejected: []string{"6", "7", "2019-09", "2020-12"},
+	}
+	assert.Equal(t, expected, probe_schemas(article, draft_schema_map, draft_name_list, Options{}))
+
+	article_json = []byte(`{"article": {"id": "09560", "status": "vor"}}`)
+	article = prepare_article("elife-09560-v1.xml.json", article_json, draft_schema_map["4"], Options{})
+	assert.Equal(t, []string{}, probe_schemas(article, draft_schema_map, draft_name_list, Options{}).Rejected)
+}
+
+func Test_write_golden(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	result, err := validator.Validate([]byte(`{"article": {"id": 9560, "status": "poa"}}`))
+	assert.Nil(t, err)
+	result.FileName = "/path/to/elife-09560-v1.xml.json"
+	result.Elapsed = 123
+
+	base_url := schema_base_url()
+	golden := golden_result(result, base_url)
+	assert.Equal(t, "elife-09560-v1.xml.json", golden.FileName)
+	assert.False(t, golden.Success)
+	assert.NotEmpty(t, golden.Errors)
+	assert.NotContains(t, golden.Errors[0].Message, base_url)
+
+	// the same result written twice is identical
+	golden_dir := t.TempDir()
+	golden_file := path.Join(golden_dir, "issue-1", "elife-09560-v1.xml.json.golden.json")
+	assert.Nil(t, write_golden(golden_dir, "issue-1/elife-09560-v1.xml.json", result, base_url))
+	expected, _ := os.ReadFile(golden_file)
+	assert.Contains(t, string(expected), `"file_name": "issue-1/elife-09560-v1.xml.json"`)
+	result.Elapsed = 456
+	assert.Nil(t, write_golden(golden_dir, "issue-1/elife-09560-v1.xml.json", result, base_url))
+	actual, _ := os.ReadFile(golden_file)
+	assert.Equal(t, string(expected), string(actual))
+
+	diff_list, err := compare_golden(golden_dir, "issue-1/elife-09560-v1.xml.json", result, base_url)
+	assert.Nil(t, err)
+	assert.Empty(t, diff_list)
+
+	diff_list, err = compare_golden(golden_dir, "issue-2/elife-09560-v1.xml.json", result, base_url)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"no golden result"}, diff_list)
+
+	fixed, err := validator.Validate([]byte(`{"article": {"id": "09560", "status": "poa"}}`))
+	assert.Nil(t, err)
+	diff_list, err = compare_golden(golden_dir, "issue-1/elife-09560-v1.xml.json", fixed, base_url)
+	assert.Nil(t, err)
+	assert.Contains(t, diff_list, "success: true, was false")
+	assert.Contains(t, diff_list, fmt.Sprintf("error count: 0, was %d", result.ErrorCount))
+	assert.Contains(t, diff_list, "- error "+flatten_golden_errors(golden.Errors)[0])
+}
+
+func Test_do__compare_golden(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	golden_dir := path.Join(t.TempDir(), "golden")
+	invalid_file := path.Join(input_path, "elife-09560-v1.xml.json")
+	os.WriteFile(invalid_file, []byte(`{"article": {"status": "vor"}}`), 0644)
+	os.WriteFile(path.Join(input_path, "elife-09561-v1.xml.json"), []byte(`{"article": {"id": "09561", "status": "vor"}}`), 0644)
+
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--write-golden", golden_dir)
+	assert.Equal(t, 1, code, stderr)
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--compare-golden", golden_dir)
+	assert.Equal(t, 1, code, stderr)
+	assert.NotContains(t, stderr, "golden result differs")
+
+	// a change in the results fails the run, even when every article-json file is now valid.
+	os.WriteFile(invalid_file, []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--compare-golden", golden_dir)
+	assert.Equal(t, 1, code, stderr)
+	assert.Contains(t, stderr, "golden result differs: "+invalid_file+"\n  success: true, was false\n")
+	assert.Contains(t, stderr, "- error [I#] [S#/allOf/0/required] missing properties: 'id'\n")
+	assert.Contains(t, stderr, "failing as 1 results differ from their golden results (--compare-golden)")
+}
+
+func Test_golden_result__sorted(t *testing.T) {
+	result := Result{
+		Error: &jsonschema.ValidationError{
+			Message: "doesn't validate",
+			Causes: []*jsonschema.ValidationError{
+				{InstanceLocation: "/title", KeywordLocation: "/properties/title/type", Message: "expected string"},
+				{InstanceLocation: "/id", KeywordLocation: "/properties/id/type", Message: "expected string"},
+			},
+		},
+	}
+	golden := golden_result(result, "")
+	assert.Equal(t, "/id", golden.Errors[0].Causes[0].InstanceLocation)
+	assert.Equal(t, "/title", golden.Errors[0].Causes[1].InstanceLocation)
+}
+
+func Test_configure_validator__ref_map(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		schema := `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$id": "https://api.elifesciences.org/schemas/article-` + status + `.v1.json",
+			"allOf": [
+				{"type": "object", "required": ["id", "status"]},
+				{"properties": {"id": {"$ref": "common.v1.json#/$defs/id"}}},
+				{}
+			]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	ref_dir := t.TempDir()
+	os.WriteFile(path.Join(ref_dir, "common.v1.json"), []byte(`{"$defs": {"id": {"type": "string", "pattern": "^[0-9]{5}$"}}}`), 0644)
+
+	// refs can't be resolved offline without a mapping.
+	_, err := configure_validator(schema_root, SchemaOptions{})
+	assert.NotNil(t, err)
+
+	ref_map := map[string]string{"https://api.elifesciences.org/schemas/": ref_dir}
+	schema_map, err := configure_validator(schema_root, SchemaOptions{RefMap: ref_map})
+	assert.Nil(t, err)
+
+	article, err := parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {"id": "09560", "status": "vor"}}`), "")
+	assert.Nil(t, err)
+	assert.True(t, validate_article(schema_map, article, true).Success)
+
+	article, err = parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {"id": "abc", "status": "vor"}}`), "")
+	assert.Nil(t, err)
+	assert.False(t, validate_article(schema_map, article, true).Success)
+}
+
+func Test_ref_map_flag(t *testing.T) {
+	ref_map := ref_map_flag{}
+	assert.Nil(t, ref_map.Set("https://example.org/schemas/=/tmp/schemas"))
+	assert.Nil(t, ref_map.Set("https://example.org/other/=/tmp/other"))
+	assert.Equal(t, "https://example.org/other/=/tmp/other,https://example.org/schemas/=/tmp/schemas", ref_map.String())
+	assert.NotNil(t, ref_map.Set("https://example.org/schemas/"))
+	assert.NotNil(t, ref_map.Set("=/tmp/schemas"))
+}
+
+func Test_slowest_results(t *testing.T) {
+	result_list := []Result{
+		{FileName: "a", Elapsed: 10},
+		{FileName: "b", Elapsed: 300},
+		{FileName: "c", Elapsed: 20},
+		{FileName: "d", Elapsed: 300},
+	}
+	slowest_list := slowest_results(result_list, 3)
+	assert.Equal(t, []string{"b", "d", "c"}, []string{slowest_list[0].FileName, slowest_list[1].FileName, slowest_list[2].FileName})
+	// the original order is untouched
+	assert.Equal(t, "a", result_list[0].FileName)
+	assert.Equal(t, 4, len(slowest_results(result_list, 10)))
+}
+
+func Test_self_test(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, self_test(schema_map))
+
+	// there is a known-good fixture for each article type and both pass.
+	known_good := map[string]bool{}
+	for _, fixture := range self_test_fixture_list {
+		if !fixture.Success {
+			continue
+		}
+		article, err := parse_article_data(fixture.Name, []byte(fixture.Data), default_status_field)
+		assert.Nil(t, err)
+		result := validate_article(schema_map, article, true)
+		assert.True(t, result.Success, fixture.Name)
+		known_good[article.Type] = result.Success
+	}
+	assert.Equal(t, map[string]bool{"POA": true, "VOR": true}, known_good)
+
+	// a schema that accepts anything fails the known-bad fixtures.
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(`{"allOf": [{}, {}, {}]}`), 0644)
+	}
+	schema_map, err = configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(self_test(schema_map)))
+}
+
+func Test_find_extra_properties(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		schema := `{"allOf": [
+			{"type": "object", "properties": {"id": {"type": "string"}, "status": {"type": "string"}}},
+			{"properties": {
+				"authors": {"type": "array", "items": {"properties": {"name": {"type": "string"}}}},
+				"meta": {"type": "object", "additionalProperties": {"type": "string"}},
+				"subject": {"oneOf": [
+					{"type": "string"},
+					{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}
+				]}
+			}},
+			{}
+		]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+
+	article, err := parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {
+		"id": "09560", "status": "vor", "extra": 1,
+		"authors": [{"name": "a"}, {"name": "b", "orcid": "x"}],
+		"meta": {"anything": "goes"},
+		"subject": {"name": "cell biology", "id": "cell-biology"}
+	}}`), "")
+	assert.Nil(t, err)
+	expected := []string{"/authors/1/orcid", "/extra", "/subject/id"}
+	assert.Equal(t, expected, find_extra_properties(schema_map["VOR"].Schema, article.Data))
+
+	result := check_article(schema_map, article, Options{ReportExtraProperties: true})
+	assert.True(t, result.Success)
+	assert.Equal(t, 3, len(result.Warnings))
+}
+
+// records logged messages by priority.
+type test_logger struct {
+	mu       sync.Mutex
+	msg_list []string
+}
+
+func (l *test_logger) log(priority string, msg string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msg_list = append(l.msg_list, priority+": "+msg)
+	return nil
+}
+
+func (l *test_logger) Info(msg string) error    { return l.log("info", msg) }
+func (l *test_logger) Warning(msg string) error { return l.log("warning", msg) }
+func (l *test_logger) Err(msg string) error     { return l.log("err", msg) }
+
+func Test_log_status(t *testing.T) {
+	logger := &test_logger{}
+	log_status(logger, Result{Type: "VOR", FileName: "a.json", Success: true, Warnings: []string{"w"}}, nil)
+	log_status(logger, Result{Type: "POA", FileName: "b.json", Success: false}, nil)
+	log_summary(logger, Summary{Articles: 2, Failures: 1}, false)
+	log_summary(logger, Summary{Articles: 2, Warnings: 1}, false)
+
+	expected := []string{
+		"info: VOR valid in\t   0ms: a.json",
+		"warning: w: a.json",
+		"err: POA invalid in\t   0ms: b.json",
+	}
+	assert.Equal(t, expected, logger.msg_list[:3])
+	assert.True(t, strings.HasPrefix(logger.msg_list[3], "err: articles:2, failures:1"))
+	assert.True(t, strings.HasPrefix(logger.msg_list[4], "info: articles:2, failures:0"))
+}
+
+func Test_format_result(t *testing.T) {
+	result := Result{Type: "VOR", FileName: "a.json", Elapsed: 12, Success: true}
+	assert.Equal(t, result.String(), format_result(result, false, nil))
+
+	tmpl, err := parse_format_template("{{.Type}} {{.FileName}} {{.Elapsed}} {{if .Success}}ok{{else}}fail{{end}}")
+	assert.Nil(t, err)
+	assert.Equal(t, "VOR a.json 12 ok", format_result(result, false, tmpl))
+
+	_, err = parse_format_template("{{.Type")
+	assert.NotNil(t, err)
+	_, err = parse_format_template("{{.Nope}}")
+	assert.ErrorContains(t, err, "can't evaluate field Nope")
+}
+
+func Test_slow_warning(t *testing.T) {
+	result := Result{Type: "VOR", FileName: "a.json", Success: true, Elapsed: 600}
+	warning, slow := slow_warning(result, 500*time.Millisecond)
+	assert.True(t, slow)
+	assert.Equal(t, "slow: took 600ms to validate, over the --slow-threshold of 500ms", warning)
+
+	_, slow = slow_warning(result, 0)
+	assert.False(t, slow)
+	_, slow = slow_warning(result, time.Second)
+	assert.False(t, slow)
+
+	// thresholds under a millisecond are compared with the time taken before it was truncated.
+	fast := Result{Type: "VOR", FileName: "b.json", Success: true, Elapsed: 0, elapsed: 200 * time.Microsecond}
+	_, slow = slow_warning(fast, 500*time.Microsecond)
+	assert.False(t, slow)
+	fast.elapsed = 700 * time.Microsecond
+	warning, slow = slow_warning(fast, 500*time.Microsecond)
+	assert.True(t, slow)
+	assert.Equal(t, "slow: took 700µs to validate, over the --slow-threshold of 500µs", warning)
+
+	logger := &test_logger{}
+	report_status(result, Options{Logger: logger, SlowThreshold: 500 * time.Millisecond})
+	expected := []string{
+		"info: VOR valid in\t 600ms: a.json",
+		"warning: slow: took 600ms to validate, over the --slow-threshold of 500ms: a.json",
+	}
+	assert.Equal(t, expected, logger.msg_list)
+}
+
+func Test_read_article_data__hash(t *testing.T) {
+	tmp := t.TempDir()
+	article_file := path.Join(tmp, "elife-09560-v1.xml.json")
+	os.WriteFile(article_file, []byte(`{"article": {"status": "retracted"}}`), 0644)
+
+	schema_map := map[string]Schema{"VOR": {}}
+	assert.Equal(t, "", read_article_data(article_file, schema_map, Options{}).Hash)
+
+	// the file fails to be prepared but is still hashed
+	article := read_article_data(article_file, schema_map, Options{Hash: "sha256"})
+	assert.Equal(t, "80dc02d3a6a23c060c87a2e264a6400656e5d69a5080b6f9e2034dd847e4377c", article.Hash)
+	article = read_article_data(article_file, schema_map, Options{Hash: "md5"})
+	assert.Equal(t, "e3b39580461dc84717386ef701c5f319", article.Hash)
+	assert.Equal(t, article.Hash, validate_article(schema_map, article, true).Hash)
+}
+
+func Test_read_article_data__base64_field(t *testing.T) {
+	tmp := t.TempDir()
+	schema_map := map[string]Schema{"VOR": {}}
+	opts := Options{Base64Field: "message.body"}
+
+	// base64 of `{"article": {"status": "vor", "id": "09560"}}`
+	envelope_file := path.Join(tmp, "elife-09560-v1.xml.json")
+	os.WriteFile(envelope_file, []byte(`{"message": {"body": "eyJhcnRpY2xlIjogeyJzdGF0dXMiOiAidm9yIiwgImlkIjogIjA5NTYwIn19"}}`), 0644)
+	article := read_article_data(envelope_file, schema_map, opts)
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, map[string]interface{}{"status": "vor", "id": "09560"}, article.Data)
+
+	cases := map[string]string{
+		"missing.json":    `{"message": {}}`,
+		"not-string.json": `{"message": {"body": 1}}`,
+		"not-base64.json": `{"message": {"body": "not base64!"}}`,
+	}
+	for name, envelope := range cases {
+		bad_file := path.Join(tmp, name)
+		os.WriteFile(bad_file, []byte(envelope), 0644)
+		assert.NotNil(t, read_article_data(bad_file, schema_map, opts).Error, name)
+	}
+}
+
+func Test_type_buffer(t *testing.T) {
+	opts := Options{TypeBuffer: new_type_buffer(map[string]int{"POA": 0, "VOR": 1})}
+	article_chan := make(chan Article, 10)
+	assert.True(t, send_article(article_chan, Article{Type: "VOR"}, opts))
+	assert.True(t, send_article(article_chan, Article{Type: "POA"}, opts))
+	assert.True(t, send_article(article_chan, Article{Type: "POA"}, opts))
+
+	// a second VOR waits for the first to be taken from the buffer.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	opts.Context = ctx
+	assert.False(t, send_article(article_chan, Article{Type: "VOR"}, opts))
+	assert.Equal(t, 3, len(article_chan))
+
+	opts.Context = nil
+	article, ok := receive_article(article_chan, opts)
+	assert.True(t, ok)
+	assert.Equal(t, "VOR", article.Type)
+	assert.True(t, send_article(article_chan, Article{Type: "VOR"}, opts))
+
+	// no limit at all.
+	opts.TypeBuffer = nil
+	assert.True(t, send_article(article_chan, Article{Type: "VOR"}, opts))
+	assert.Equal(t, 4, len(article_chan))
+}
+
+func Test_wait_for_prefill(t *testing.T) {
+	article_chan := make(chan Article, 4)
+	feeder_wg := &sync.WaitGroup{}
+	feeder_wg.Add(1)
+	go func() {
+		defer feeder_wg.Done()
+		for i := 0; i < 3; i++ {
+			article_chan <- Article{}
+		}
+	}()
+	assert.GreaterOrEqual(t, wait_for_prefill(article_chan, feeder_wg, 2), 2)
+
+	// the feeder finishes before the buffer is full
+	assert.Equal(t, 3, wait_for_prefill(article_chan, feeder_wg, -1))
+	assert.Equal(t, 3, wait_for_prefill(article_chan, feeder_wg, 0))
+}
+
+func Test_process_files__max_failures(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	tmp := t.TempDir()
+	file_list := []string{}
+	for i := 0; i < 500; i++ {
+		file := path.Join(tmp, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+		// every article is invalid
+		os.WriteFile(file, []byte(`{"article": {"id": 1, "status": "vor"}}`), 0644)
+		file_list = append(file_list, file)
+	}
+
+	opts := Options{BufferSize: 1, NumWorkers: 1, MaxFailures: 3}
+	_, _, result_list := process_files_with_feeder(file_list, schema_map, opts)
+	assert.GreaterOrEqual(t, len(result_list), 3)
+	assert.Less(t, len(result_list), len(file_list))
+
+	_, _, result_list, _ = process_files_with_auto_workers(file_list, schema_map, opts)
+	assert.GreaterOrEqual(t, len(result_list), 3)
+	assert.Less(t, len(result_list), len(file_list))
+
+	opts.MaxFailures = 0
+	_, _, result_list = process_files_with_feeder(file_list, schema_map, opts)
+	assert.Equal(t, len(file_list), len(result_list))
+}
+
+func Test_do__relative_to(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	file := path.Join(input_path, "elife-09560-v1.xml.json")
+	os.WriteFile(file, []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+
+	// filenames are reported as they were found unless --relative-to is given.
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path)
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, ": "+file+"\n")
+
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--relative-to", input_path)
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, ": elife-09560-v1.xml.json\n")
+	assert.NotContains(t, stderr, file)
+}
+
+func Test_do__sample_percent_after_filters(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	for i := 1; i <= 10; i++ {
+		id := fmt.Sprintf("%05d", i)
+		os.WriteFile(path.Join(input_path, "elife-"+id+"-v1.xml.json"), []byte(`{"article": {"id": "`+id+`", "status": "vor"}}`), 0644)
+	}
+
+	// the percentage is taken of the files --only-ids left, not of the whole directory.
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--only-ids", "00003,00007", "--sample-percent", "50")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "sampled 1 of 2 article-json files")
+}
+
+func Test_do__latest_version_only_before_sample_size(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	for _, name := range []string{"elife-00001-v1.xml.json", "elife-00001-v2.xml.json", "elife-00002-v1.xml.json"} {
+		os.WriteFile(path.Join(input_path, name), []byte(`{"article": {"id": "00001", "status": "vor"}}`), 0644)
+	}
+
+	// the latest versions are picked from every file, so the older version can't take the place of the newer one in the sample.
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--latest-version-only", "--sample-size", "1")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "elife-00001-v2.xml.json")
+	assert.NotContains(t, stderr, "elife-00001-v1.xml.json")
+}
+
+func Test_relative_file_name(t *testing.T) {
+	cases := []struct {
+		base_dir, file_name, expected string
+	}{
+		{"", "/path/to/elife-09560-v1.xml.json", "/path/to/elife-09560-v1.xml.json"},
+		{"/path/to", "/path/to/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+		{"/path/to/", "/path/to/sub/elife-09560-v1.xml.json", "sub/elife-09560-v1.xml.json"},
+		{"/path", "/other/elife-09560-v1.xml.json", "../other/elife-09560-v1.xml.json"},
+		{"article-json", "article-json/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+		{"/path/to", "", ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, relative_file_name(c.base_dir, c.file_name), c)
+	}
+}
+
+func Test_mirrored_name(t *testing.T) {
+	cases := []struct {
+		input_dir, file_name, expected string
+	}{
+		{"/path/to", "/path/to/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+		{"/path/to", "/path/to/issue-1/elife-09560-v1.xml.json", "issue-1/elife-09560-v1.xml.json"},
+		{"/path/to", "/path/to/dump.json[0]", "dump.json[0]"},
+		{"/path/to", "/other/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+		{"s3://bucket/prefix/", "s3://bucket/prefix/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+		{"s3://bucket/prefix/", "s3://bucket/other/elife-09560-v1.xml.json", "elife-09560-v1.xml.json"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, mirrored_name(c.input_dir, c.file_name), c)
+	}
+}
+
+func Test_write_error_report(t *testing.T) {
+	output_dir := t.TempDir()
+	result := Result{Error: &jsonschema.ValidationError{InstanceLocation: "/id", KeywordLocation: "/required", Message: "missing properties: 'id'"}}
+
+	// same-named files from different directories don't overwrite each other's report.
+	assert.Nil(t, write_error_report(output_dir, "issue-1/elife-09560-v1.xml.json", result))
+	assert.Nil(t, write_error_report(output_dir, "issue-2/elife-09560-v1.xml.json", result))
+	for _, name := range []string{"issue-1", "issue-2"} {
+		content, err := os.ReadFile(path.Join(output_dir, name, "elife-09560-v1.xml.json.errors.txt"))
+		assert.Nil(t, err)
+		assert.Contains(t, string(content), "missing properties: 'id'")
+	}
+}
+
+func Test_sanity_check_schemas(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, sanity_check_schemas(schema_map))
+
+	schema_map["POA"] = Schema{Label: "POA"}
+	assert.NotNil(t, sanity_check_schemas(schema_map))
+
+	// a schema whose validation panics
+	compiler := jsonschema.NewCompiler()
+	compiler.RegisterExtension("explode", jsonschema.MustCompileString("explode.json", `{}`), explode_extension{})
+	assert.Nil(t, compiler.AddResource("explode.json", strings.NewReader(`{"explode": true}`)))
+	explode_schema, err := compiler.Compile("explode.json")
+	assert.Nil(t, err)
+	err = sanity_check_schemas(map[string]Schema{"VOR": {Label: "VOR", Schema: explode_schema}})
+	assert.ErrorContains(t, err, "VOR schema panicked during sanity validation")
+}
+
+// a jsonschema extension whose 'explode' keyword panics when validated.
+type explode_extension struct{}
+
+func (explode_extension) Compile(ctx jsonschema.CompilerContext, m map[string]interface{}) (jsonschema.ExtSchema, error) {
+	if _, present := m["explode"]; present {
+		return explode_extension{}, nil
+	}
+	return nil, nil
+}
+
+func (explode_extension) Validate(ctx jsonschema.ValidationContext, v interface{}) error {
+	panic("kaboom")
+}
+
+func Test_read_article_data__status_field(t *testing.T) {
+	tmp := t.TempDir()
+	article_file := path.Join(tmp, "elife-09560-v1.xml.json")
+	os.WriteFile(article_file, []byte(`{"article": {"id": "09560", "type": "vor"}}`), 0644)
+	schema_map := map[string]Schema{"VOR": {}}
+
+	article := read_article_data(article_file, schema_map, Options{StatusField: "article.type"})
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "VOR", article.Type)
+
+	_, err := parse_article_data(article_file, []byte(`{"article": {"id": "09560", "type": "vor"}}`), "")
+	assert.ErrorContains(t, err, "'article.status' field in article data not found")
+}
+
+func Test_percentile_elapsed(t *testing.T) {
+	result_list := []Result{}
+	for i := 100; i >= 1; i-- {
+		result_list = append(result_list, Result{Elapsed: int64(i)})
+	}
+	assert.Equal(t, int64(99), percentile_elapsed(result_list, 99))
+	assert.Equal(t, int64(50), percentile_elapsed(result_list, 50))
+	assert.Equal(t, int64(1), percentile_elapsed(result_list, 0))
+	assert.Equal(t, int64(7), percentile_elapsed([]Result{{Elapsed: 7}}, 99))
+	assert.Equal(t, int64(0), percentile_elapsed(nil, 99))
+}
+
+func Test_compare_perf(t *testing.T) {
+	baseline := PerfNumbers{Throughput: 100, P99Ms: 200}
+	assert.Equal(t, []string{}, compare_perf(baseline, PerfNumbers{Throughput: 95, P99Ms: 210}, 10))
+	assert.Equal(t, []string{}, compare_perf(baseline, PerfNumbers{Throughput: 150, P99Ms: 100}, 0))
+	assert.Equal(t, 2, len(compare_perf(baseline, PerfNumbers{Throughput: 89, P99Ms: 221}, 10)))
+
+	// whole milliseconds make small p99s noisy, a fast baseline needs to be a few milliseconds slower to regress.
+	assert.Equal(t, []string{}, compare_perf(PerfNumbers{P99Ms: 1}, PerfNumbers{P99Ms: 2}, 10))
+	assert.Equal(t, []string{}, compare_perf(PerfNumbers{P99Ms: 0}, PerfNumbers{P99Ms: 4}, 10))
+	assert.Equal(t, []string{"p99:6ms is more than 10% and at least 5ms above the baseline of 1ms"}, compare_perf(PerfNumbers{P99Ms: 1}, PerfNumbers{P99Ms: 6}, 10))
+
+	baseline_path := path.Join(t.TempDir(), "perf.json")
+	assert.Nil(t, write_perf_baseline(baseline_path, baseline))
+	actual, err := read_perf_baseline(baseline_path)
+	assert.Nil(t, err)
+	assert.Equal(t, baseline, actual)
+}
+
+func Test_format_validation_tree(t *testing.T) {
+	err := &jsonschema.ValidationError{
+		Message: "doesn't validate with VOR#",
+		Causes: []*jsonschema.ValidationError{
+			{KeywordLocation: "/allOf/0", Message: "allOf failed", Causes: []*jsonschema.ValidationError{
+				{KeywordLocation: "/allOf/0/required", Message: "missing properties: 'title'"},
+			}},
+			{InstanceLocation: "/version", KeywordLocation: "/allOf/1/properties/version/type", Message: "expected integer, but got string"},
+		},
+	}
+	expected := "[I#] doesn't validate with VOR#\n" +
+		"  [I#] allOf failed\n" +
+		"    [I#] missing properties: 'title'\n" +
+		"  [I#/version] expected integer, but got string"
+	assert.Equal(t, expected, format_validation_tree(err))
+}
+
+func Test_do__explain_file(t *testing.T) {
+	schema_root := write_schema_root(t)
+	file := path.Join(t.TempDir(), "elife-09560-v1.xml.json")
+	os.WriteFile(file, []byte(`{"article": {"id": 9560, "status": "vor"}}`), 0644)
+
+	stdout, stderr, code := run_command(t, "--schema-root", schema_root, "--explain-file", file)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "VOR invalid")
+	assert.Contains(t, stderr, file)
+	// the full tree of validation errors, down to the error that explains the failure.
+	assert.Contains(t, stdout, "[I#] doesn't validate with ")
+	assert.Contains(t, stdout, "\n  [I#] allOf failed\n")
+	assert.Contains(t, stdout, "\n    [I#/id] expected string, but got number\n")
+
+	os.WriteFile(file, []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+	stdout, stderr, code = run_command(t, "--schema-root", schema_root, "--explain-file", file)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr, "VOR valid")
+	assert.Equal(t, "", stdout)
+}
+
+func Test_configure_validator__dynamic_ref(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		// 'list' is a generic list whose items are whatever the outermost '$dynamicAnchor: item' says, strings here.
+		schema := `{
+			"allOf": [
+				{"properties": {"authors": {"$ref": "#/$defs/list"}}},
+				{"properties": {"status": {"enum": ["` + status + `"]}}},
+				{}
+			],
+			"$defs": {
+				"list": {
+					"$id": "list",
+					"type": "array",
+					"items": {"$dynamicRef": "#item"},
+					"$defs": {"any-item": {"$dynamicAnchor": "item"}}
+				},
+				"string-item": {"$dynamicAnchor": "item", "type": "string"}
+			}
+		}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	valid := []byte(`{"article": {"status": "vor", "authors": ["a", "b"]}}`)
+	invalid := []byte(`{"article": {"status": "vor", "authors": ["a", 1]}}`)
+
+	schema_map, err := configure_validator(schema_root, SchemaOptions{Draft: jsonschema.Draft2020})
+	assert.Nil(t, err)
+	article, err := parse_article_data("", valid, "")
+	assert.Nil(t, err)
+	assert.True(t, validate_article(schema_map, article, true).Success)
+	article, err = parse_article_data("", invalid, "")
+	assert.Nil(t, err)
+	assert.False(t, validate_article(schema_map, article, true).Success)
+
+	// draft 4 doesn't know '$dynamicRef' so any item is allowed.
+	schema_map, err = configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	assert.True(t, validate_article(schema_map, article, true).Success)
+}
+
+func Test_process_files__parallel_capture(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	tmp := t.TempDir()
+	file_list := []string{}
+	for i := 0; i < 100; i++ {
+		file := path.Join(tmp, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+		// a different combination of problems per article
+		article_json := fmt.Sprintf(`{"article": {"id": %d, "status": "vor"}}`, i)
+		if i%2 == 0 {
+			article_json = `{"article": {"status": "vor", "title": []}}`
+		}
+		os.WriteFile(file, []byte(article_json), 0644)
+		file_list = append(file_list, file)
+	}
+
+	golden_map := func(result_list []Result) map[string]GoldenResult {
+		golden_map := map[string]GoldenResult{}
+		for _, result := range result_list {
+			assert.NotNil(t, result.Error)
+			golden_map[result.FileName] = golden_result(result, "")
+		}
+		return golden_map
+	}
+
+	_, _, serial_list := process_files_with_feeder(file_list, schema_map, Options{BufferSize: 10, NumWorkers: 1, CaptureError: true})
+	_, _, parallel_list := process_files_with_feeder(file_list, schema_map, Options{BufferSize: 10, NumWorkers: 8, CaptureError: true})
+	assert.Equal(t, len(file_list), len(parallel_list))
+	assert.Equal(t, golden_map(serial_list), golden_map(parallel_list))
+
+	ordered_list := order_results(parallel_list, serial_list)
+	for i := range serial_list {
+		assert.Equal(t, serial_list[i].FileName, ordered_list[i].FileName)
+	}
+}
+
+func Test_detailed_failures(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	tmp := t.TempDir()
+	file_list := []string{}
+	for i := 0; i < 3; i++ {
+		file := path.Join(tmp, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+		os.WriteFile(file, []byte(`{"article": {"status": "vor", "title": []}}`), 0644)
+		file_list = append(file_list, file)
+	}
+
+	// errors not captured are re-validated.
+	_, _, failure_list := process_files_with_feeder(file_list, schema_map, Options{BufferSize: 10, NumWorkers: 2})
+	for _, result := range failure_list {
+		assert.Nil(t, result.Error)
+	}
+	detailed_list := detailed_failures(failure_list, schema_map, Options{BufferSize: 10, NumWorkers: 2})
+	assert.Equal(t, len(failure_list), len(detailed_list))
+	for i, result := range detailed_list {
+		assert.Equal(t, failure_list[i].FileName, result.FileName)
+		assert.NotNil(t, result.Error)
+	}
+
+	// captured errors are used as they are, the files are never read or validated a second time.
+	opts := Options{BufferSize: 10, NumWorkers: 2, CaptureError: true}
+	_, _, failure_list = process_files_with_feeder(file_list, schema_map, opts)
+	for _, file := range file_list {
+		os.Remove(file)
+	}
+	detailed_list = detailed_failures(failure_list, schema_map, opts)
+	assert.Equal(t, failure_list, detailed_list)
+	for _, result := range detailed_list {
+		assert.NotNil(t, result.Error)
+	}
+}
+
+func Test_parse_article_version(t *testing.T) {
+	cases := []struct {
+		file     string
+		id       string
+		version  int
+		expected bool
+	}{
+		{"elife-12345-v1.xml.json", "12345", 1, true},
+		{"/path/to/elife-00666-v12.xml.json.gz", "00666", 12, true},
+		{"elife-12345.xml.json", "", 0, false},
+		{"foo-12345-v1.json", "", 0, false},
+	}
+	for _, c := range cases {
+		id, version, ok := parse_article_version(c.file)
+		assert.Equal(t, c.expected, ok, c.file)
+		assert.Equal(t, c.id, id, c.file)
+		assert.Equal(t, c.version, version, c.file)
+	}
+}
+
+func Test_latest_article_versions(t *testing.T) {
+	file_list := []string{
+		"/a/elife-00003-v1.xml.json",
+		"/a/elife-00002-v3.xml.json",
+		"/a/elife-00002-v10.xml.json",
+		"/a/elife-00002-v1.xml.json",
+		"/a/elife-00001-v1.xml.json",
+		"/a/not-an-article.json",
+	}
+	expected_version_map := map[string][]int{"00002": {1, 3, 10}}
+	assert.Equal(t, expected_version_map, multiple_article_versions(file_list))
+
+	expected := []string{
+		"/a/elife-00003-v1.xml.json",
+		"/a/elife-00002-v10.xml.json",
+		"/a/elife-00001-v1.xml.json",
+		"/a/not-an-article.json",
+	}
+	assert.Equal(t, expected, latest_article_versions(file_list))
+}
+
+func Test_select_schema(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dist/model/article-poa.v9.json":  {Data: []byte("{}")},
+		"dist/model/article-poa.v10.json": {Data: []byte("{}")},
+		"dist/model/article-vor.v2.json":  {Data: []byte("{}")},
+		"dist/model/article-vor.v02.json": {Data: []byte("{}")},
+		"dist/model/article-vor.v1.json":  {Data: []byte("{}")},
+	}
+
+	selection, err := select_schema(fsys, "dist/model/article-poa.v*.json")
+	assert.Nil(t, err)
+	// "v10" sorts before "v9" by filename but is the higher version.
+	expected_candidates := []SchemaCandidate{
+		{Path: "dist/model/article-poa.v9.json", Version: 9},
+		{Path: "dist/model/article-poa.v10.json", Version: 10},
+	}
+	assert.Equal(t, expected_candidates, selection.Candidates)
+	assert.Equal(t, "dist/model/article-poa.v10.json", selection.Selected)
+	assert.Equal(t, "highest version of 2 matches", selection.Reason)
+
+	selection, err = select_schema(fsys, "dist/model/article-vor.v*.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "dist/model/article-vor.v2.json", selection.Selected)
+	assert.Equal(t, "highest version of 3 matches, version 2 is shared by: dist/model/article-vor.v02.json, dist/model/article-vor.v2.json, the last sorted by filename is selected", selection.Reason)
+
+	fsys["dist/model/article-vor.json"] = &fstest.MapFile{Data: []byte("{}")}
+	selection, err = select_schema(fsys, "dist/model/article-vor*.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "dist/model/article-vor.json", selection.Candidates[0].Path)
+	assert.Equal(t, "dist/model/article-vor.v2.json", selection.Selected)
+
+	selection, err = select_schema(fsys, "dist/model/article-vor.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "only match", selection.Reason)
+
+	_, err = select_schema(fsys, "dist/model/article-foo.v*.json")
+	assert.NotNil(t, err)
+}
+
+func Test_process_ndjson_stream(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	stream := strings.Join([]string{
+		`{"article": {"id": "09560", "status": "vor"}}`,
+		``,
+		`{"article": {"id": 9560, "status": "poa"}}`,
+		`not json`,
+		`{"article": {"id": "09561", "status": "poa"}}`, // no trailing newline
+	}, "\n")
+	output := bytes.Buffer{}
+	summary, err := process_ndjson_stream(strings.NewReader(stream), &output, schema_map, Options{BufferSize: 2, NumWorkers: 2, CaptureError: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, summary.Articles)
+	assert.Equal(t, 2, summary.Failures)
+
+	success_map := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := ReportEntry{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		success_map[entry.FileName] = entry.Success
+	}
+	expected := map[string]bool{"stdin:1": true, "stdin:2": false, "stdin:3": false, "stdin:4": true}
+	assert.Equal(t, expected, success_map)
+}
+
+func Test_process_ndjson_stream__base64_field(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	envelope := func(article_json string) string {
+		return fmt.Sprintf(`{"msg": %q}`, base64.StdEncoding.EncodeToString([]byte(article_json)))
+	}
+	stream := strings.Join([]string{
+		envelope(`{"article": {"id": "09560", "status": "vor"}}`),
+		envelope(`{"article": {"id": 9560, "status": "poa"}}`),
+		envelope(`{"article": {"id": "09561"}}`),
+		`{"other": "field"}`,
+	}, "\n")
+	output := bytes.Buffer{}
+	opts := Options{BufferSize: 2, NumWorkers: 2, CaptureError: true, Base64Field: "msg"}
+	summary, err := process_ndjson_stream(strings.NewReader(stream), &output, schema_map, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, summary.Articles)
+	assert.Equal(t, 3, summary.Failures)
+
+	success_map := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := ReportEntry{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		success_map[entry.FileName] = entry.Success
+	}
+	expected := map[string]bool{"stdin:1": true, "stdin:2": false, "stdin:3": false, "stdin:4": false}
+	assert.Equal(t, expected, success_map)
+
+	assert.EqualError(t, check_ndjson_line([]byte(envelope(`{"article": {"id": "09561"}}`)), opts), "'article.status' field in article data not found")
+	assert.EqualError(t, check_ndjson_line([]byte(`{"other": "field"}`), opts), "base64 field not found: msg")
+}
+
+// starts a server speaking just enough of the Redis protocol for --jobs-from-queue.
+// lists are kept in `list_map`, each from left to right, so a path pushed with LPUSH is at the start.
+// BLMOVE and LMOVE move an item between lists, BLMOVE times out when there is nothing to move.
+// RPUSH and LREM add and remove items.
+// returns its address and a function returning the items in a list so far.
+func fake_redis_server(t *testing.T, list_map map[string][]string) (string, func(name string) []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	mu := sync.Mutex{}
+	// moves the item at the `from` end of the list `source` to the `to` end of `destination`.
+	move := func(source, destination, from, to string) (string, bool) {
+		item_list := list_map[source]
+		if len(item_list) == 0 {
+			return "", false
+		}
+		var item string
+		if from == "LEFT" {
+			item, list_map[source] = item_list[0], item_list[1:]
+		} else {
+			item, list_map[source] = item_list[len(item_list)-1], item_list[:len(item_list)-1]
+		}
+		if to == "LEFT" {
+			list_map[destination] = append([]string{item}, list_map[destination]...)
+		} else {
+			list_map[destination] = append(list_map[destination], item)
+		}
+		return item, true
+	}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			num_args, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			arg_list := []string{}
+			for i := 0; i < num_args; i++ {
+				reader.ReadString('\n') // $length
+				arg, _ := reader.ReadString('\n')
+				arg_list = append(arg_list, strings.TrimSuffix(arg, "\r\n"))
+			}
+			mu.Lock()
+			switch arg_list[0] {
+			case "BLMOVE", "LMOVE":
+				item, moved := move(arg_list[1], arg_list[2], arg_list[3], arg_list[4])
+				if !moved {
+					mu.Unlock()
+					if arg_list[0] == "BLMOVE" {
+						time.Sleep(10 * time.Millisecond)
+					}
+					conn.Write([]byte("$-1\r\n"))
+					continue
+				}
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(item), item)
+			case "RPUSH":
+				list_map[arg_list[1]] = append(list_map[arg_list[1]], arg_list[2])
+				fmt.Fprintf(conn, ":%d\r\n", len(list_map[arg_list[1]]))
+			case "LREM":
+				index := slices.Index(list_map[arg_list[1]], arg_list[3])
+				if index == -1 {
+					conn.Write([]byte(":0\r\n"))
+				} else {
+					list_map[arg_list[1]] = slices.Delete(list_map[arg_list[1]], index, index+1)
+					conn.Write([]byte(":1\r\n"))
+				}
+			default:
+				conn.Write([]byte("-ERR unknown command\r\n"))
+			}
+			mu.Unlock()
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String(), func(name string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(list_map[name])
+	}
+}
+
+func Test_redis_client(t *testing.T) {
+	addr, list := fake_redis_server(t, map[string][]string{"queue": {"path/to/article.json"}})
+	client, err := dial_redis(addr)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply, err := client.command("BLMOVE", "queue", "queue:processing", "RIGHT", "LEFT", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "path/to/article.json", reply)
+	assert.Equal(t, []string{"path/to/article.json"}, list("queue:processing"))
+
+	reply, err = client.command("BLMOVE", "queue", "queue:processing", "RIGHT", "LEFT", "1")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	reply, err = client.command("RPUSH", "results", "{}")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), reply)
+
+	_, err = client.command("FLUSHALL")
+	assert.EqualError(t, err, "redis error: ERR unknown command")
+}
+
+func Test_process_redis_queue(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	article_dir := t.TempDir()
+	valid_path := path.Join(article_dir, "elife-09560-v1.xml.json")
+	invalid_path := path.Join(article_dir, "elife-09561-v1.xml.json")
+	os.WriteFile(valid_path, []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+	os.WriteFile(invalid_path, []byte(`{"article": {"id": 9561, "status": "poa"}}`), 0644)
+	missing_path := path.Join(article_dir, "elife-09562-v1.xml.json")
+	malformed_path := path.Join(article_dir, "elife-09563-v1.xml.json")
+	os.WriteFile(malformed_path, []byte(`{"article": {"id": "09563", "status": `), 0644)
+	// the malformed path was popped by a worker that stopped before its result was pushed.
+	addr, list := fake_redis_server(t, map[string][]string{
+		"queue":            {missing_path, invalid_path, valid_path},
+		"queue:processing": {malformed_path},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := Options{BufferSize: 2, NumWorkers: 2, CaptureError: true, Context: ctx}
+	done := make(chan Summary)
+	go func() {
+		summary, err := process_redis_queue(addr, "queue", "queue:results", schema_map, opts)
+		assert.Nil(t, err)
+		done <- summary
+	}()
+	// the worker keeps waiting for paths after the queue is empty, until it's stopped.
+	assert.Eventually(t, func() bool { return len(list("queue:results")) == 4 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	summary := <-done
+	assert.Equal(t, 4, summary.Articles)
+	assert.Equal(t, 3, summary.Failures)
+
+	// results are kept until they're read and finished paths are no longer processing.
+	success_map := map[string]bool{}
+	for _, message := range list("queue:results") {
+		entry := ReportEntry{}
+		assert.Nil(t, json.Unmarshal([]byte(message), &entry))
+		success_map[entry.FileName] = entry.Success
+	}
+	expected := map[string]bool{valid_path: true, invalid_path: false, missing_path: false, malformed_path: false}
+	assert.Equal(t, expected, success_map)
+	assert.Empty(t, list("queue"))
+	assert.Empty(t, list("queue:processing"))
+
+	_, err = process_redis_queue("127.0.0.1:1", "queue", "queue:results", schema_map, opts)
+	assert.ErrorContains(t, err, "failed connecting to redis")
+}
+
+func Test_compiled_schema_info(t *testing.T) {
+	schema_root := write_schema_root(t)
+	os.WriteFile(path.Join(schema_root, "dist", "model", "article-poa.v1.json"), []byte(`{"$schema": "http://json-schema.org/draft-04/schema#"}`), 0644)
+
+	schema_map, err := configure_validator(schema_root, SchemaOptions{Draft: jsonschema.Draft7})
+	assert.Nil(t, err)
+	isbn_patch := SchemaPatch{
+		Label:   "VOR",
+		Path:    "allOf.2.properties.references.items.definitions.book.properties.isbn.pattern",
+		New:     `"^.+$"`,
+		Reason:  "ISBN pattern can't be compiled in Go, but it wasn't found",
+		Default: true,
+	}
+	expected := []CompiledSchemaInfo{
+		{Label: "POA", Path: path.Join(schema_root, "dist/model/article-poa.v1.json"), Declared: "http://json-schema.org/draft-04/schema#", Draft: "4", Patches: []SchemaPatch{}, AssertFormat: true},
+		{Label: "VOR", Path: path.Join(schema_root, "dist/model/article-vor.v1.json"), Declared: "", Draft: "7", Patches: []SchemaPatch{isbn_patch}, AssertFormat: true, AssertContent: true},
+	}
+	assert.Equal(t, expected, compiled_schema_info(schema_map))
+	assert.Equal(t, []SchemaPatch{}, requested_patches(schema_map))
+
+	schema_map, err = configure_validator(schema_root, SchemaOptions{Draft: jsonschema.Draft7, ForceDraft: true, Overlay: []byte(`{}`)})
+	assert.Nil(t, err)
+	info_list := compiled_schema_info(schema_map)
+	assert.Equal(t, "7", info_list[0].Draft)
+	expected_patches := []SchemaPatch{
+		{Label: "POA", Path: "$schema", Old: `"http://json-schema.org/draft-04/schema#"`, Reason: "compiled as the given draft"},
+		{Label: "POA", Path: "allOf.-1", New: "{}", Reason: "schema overlay"},
+	}
+	assert.Equal(t, expected_patches, info_list[0].Patches)
+	assert.Len(t, requested_patches(schema_map), 3)
+	assert.Equal(t, `POA $schema: "http://json-schema.org/draft-04/schema#" => (removed) (compiled as the given draft)`, info_list[0].Patches[0].String())
+}
+
+func Test_assert_format(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		schema := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "allOf": [{"properties": {"published": {"format": "date-time"}}}, {}, {}]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	article := Article{Type: "POA", Data: map[string]interface{}{"published": "yesterday"}}
+
+	// 'format' is an annotation in newer drafts unless it's asserted.
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	assert.True(t, validate_article(schema_map, article, false).Success)
+	assert.Equal(t, "format assertion:off, content assertion:off", assertion_summary(schema_map))
+
+	schema_map, err = configure_validator(schema_root, SchemaOptions{AssertFormat: true})
+	assert.Nil(t, err)
+	assert.False(t, validate_article(schema_map, article, false).Success)
+	assert.Equal(t, "format assertion:on, content assertion:off", assertion_summary(schema_map))
+
+	// older drafts always assert it.
+	schema_map, err = configure_validator(schema_root, SchemaOptions{Draft: jsonschema.Draft4, ForceDraft: true})
+	assert.Nil(t, err)
+	assert.False(t, validate_article(schema_map, article, false).Success)
+
+	schema_map["VOR"] = Schema{AssertContent: true}
+	assert.Equal(t, "format assertion:partial (POA), content assertion:partial (VOR)", assertion_summary(schema_map))
+}
+
+func Test_summarise_by_dir(t *testing.T) {
+	result_list := []Result{
+		{FileName: "issue-2/elife-09560-v1.xml.json", Success: true, Elapsed: 100},
+		{FileName: "issue-1/elife-09561-v1.xml.json", Success: false, Elapsed: 300},
+		{FileName: "issue-1/elife-09562-v1.xml.json", Success: true, Elapsed: 100, Warnings: []string{"foo"}},
+		{FileName: "issue-1/dump.json[0]", Success: true, Elapsed: 200},
+		{FileName: "elife-09563-v1.xml.json", Success: true, Elapsed: 50},
+	}
+	expected := []DirSummary{
+		{Dir: ".", Articles: 1, Failures: 0, Warnings: 0, AvgTimeMs: 50},
+		{Dir: "issue-1", Articles: 3, Failures: 1, Warnings: 1, AvgTimeMs: 200},
+		{Dir: "issue-2", Articles: 1, Failures: 0, Warnings: 0, AvgTimeMs: 100},
+	}
+	dir_list := summarise_by_dir(result_list)
+	assert.Equal(t, expected, dir_list)
+	assert.Equal(t, "issue-1: articles:3, failures:1, warnings:1, avg-time:200ms", dir_list[1].String())
+	assert.Equal(t, []DirSummary{}, summarise_by_dir(nil))
+}
+
+func Test_phase_timer(t *testing.T) {
+	var nil_timer *phase_timer
+	nil_timer.start("schema discovery")()
+	assert.Nil(t, nil_timer.lines())
+
+	timer := new_phase_timer()
+	timer.add("schema compilation", 2*time.Millisecond)
+	timer.add("validation", 5*time.Millisecond)
+	timer.add("schema compilation", 1*time.Millisecond)
+	assert.Equal(t, []string{"schema compilation: 3ms", "validation: 5ms"}, timer.lines())
+
+	schema_root := write_schema_root(t)
+	timer = new_phase_timer()
+	_, err := configure_validator(schema_root, SchemaOptions{Timer: timer})
+	assert.Nil(t, err)
+	line_list := timer.lines()
+	assert.Len(t, line_list, 2)
+	assert.True(t, strings.HasPrefix(line_list[0], "schema discovery: "))
+	assert.True(t, strings.HasPrefix(line_list[1], "schema compilation: "))
+
+	// how long workers waited on the feeder is noted after the phases, and only when timing.
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	file_list := []string{path.Join(t.TempDir(), "elife-09560-v1.xml.json")}
+	os.WriteFile(file_list[0], []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+	timer = new_phase_timer()
+	timer.add("validation", 5*time.Millisecond)
+	process_files_with_feeder(file_list, schema_map, Options{BufferSize: 1, NumWorkers: 1, Prefill: 1, Timer: timer})
+	line_list = timer.lines()
+	assert.Len(t, line_list, 2)
+	assert.True(t, strings.HasPrefix(line_list[1], "feeder: 1 articles buffered before validation started, workers waited "), line_list[1])
+
+	input_path := filepath.Dir(file_list[0])
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--timing", "--prefill", "1")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "\ntiming:\n")
+	assert.Contains(t, stderr, "\nfeeder: 1 articles buffered before validation started, workers waited ")
+
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path)
+	assert.Equal(t, 0, code, stderr)
+	assert.NotContains(t, stderr, "timing:")
+	assert.NotContains(t, stderr, "feeder:")
+}
+
+func Test_result_cache(t *testing.T) {
+	cache := new_result_cache(2)
+	a := Article{FileName: "a", cache_key: "1"}
+	b := Article{FileName: "b", cache_key: "2"}
+	c := Article{FileName: "c", cache_key: "3"}
+	cache.add(a, Result{FileName: "a", Success: true})
+	cache.add(b, Result{FileName: "b", Success: false})
+	result, present := cache.get(a, nil)
+	assert.True(t, present)
+	assert.Equal(t, Result{FileName: "a", Success: true}, result)
+
+	// 'b' is the least recently used.
+	cache.add(c, Result{FileName: "c"})
+	_, present = cache.get(b, nil)
+	assert.False(t, present)
+	_, present = cache.get(a, nil)
+	assert.True(t, present)
+
+	// articles without a key and a nil cache are never cached.
+	cache.add(Article{}, Result{})
+	_, present = cache.get(Article{}, nil)
+	assert.False(t, present)
+	var nil_cache *result_cache
+	nil_cache.add(a, Result{})
+	_, present = nil_cache.get(a, nil)
+	assert.False(t, present)
+}
+
+func Test_result_cache__filename_checks(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	opts := Options{ExtraChecks: []string{"filename-id", "date-order"}}
+	article_json := []byte(`{"article": {"id": "09560", "status": "vor"}}`)
+	cache := new_result_cache(2)
+
+	swapped := prepare_article("elife-09561-v1.xml.json", article_json, schema_map, opts)
+	swapped.cache_key = "1"
+	result := check_article(schema_map, swapped, opts)
+	expected := []string{"filename-id: '/id' is '09560' but the filename has article id '09561'"}
+	assert.Equal(t, expected, result.Warnings)
+	cache.add(swapped, result)
+
+	// the same content under its own name has none of the warnings about the other name.
+	renamed := prepare_article("elife-09560-v1.xml.json", article_json, schema_map, opts)
+	renamed.cache_key = "1"
+	result, present := cache.get(renamed, opts.ExtraChecks)
+	assert.True(t, present)
+	assert.Equal(t, []string{}, result.Warnings)
+
+	result, present = cache.get(swapped, opts.ExtraChecks)
+	assert.True(t, present)
+	assert.Equal(t, expected, result.Warnings)
+}
+
+func Test_process_ndjson_stream__result_cache(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	stream := strings.Join([]string{
+		`{"article": {"id": "09560", "status": "vor"}}`,
+		`{"article": {"id": 9560, "status": "poa"}}`,
+	}, "\n")
+	opts := Options{BufferSize: 2, NumWorkers: 2, CaptureError: true, ResultCache: new_result_cache(10)}
+	summary, err := process_ndjson_stream(strings.NewReader(stream), io.Discard, schema_map, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, summary.CacheHits)
+
+	// the same documents again, retried.
+	output := bytes.Buffer{}
+	summary, err = process_ndjson_stream(strings.NewReader(stream), &output, schema_map, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, summary.Articles)
+	assert.Equal(t, 1, summary.Failures)
+	assert.Equal(t, 2, summary.CacheHits)
+	assert.Contains(t, summary.String(), ", cache-hits:2,")
+
+	success_map := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := ReportEntry{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		success_map[entry.FileName] = entry.Success
+	}
+	expected := map[string]bool{"stdin:1": true, "stdin:2": false}
+	assert.Equal(t, expected, success_map)
+}
+
+func Test_Summary_CountString(t *testing.T) {
+	summary := summarise([]Result{{Success: true}, {Success: false}, {Success: true, Skipped: true}}, 1, time.Now(), time.Now())
+	assert.Equal(t, "2 1", summary.CountString())
+	assert.Equal(t, "0 0", Summary{}.CountString())
+}
+
+func Test_normalize_json(t *testing.T) {
+	normalized, err := normalize_json([]byte(`{"b": [1, 2.50, 12345678901234567890], "a": {"d": "<i>&</i>", "c": null}}`))
+	assert.Nil(t, err)
+	expected := `{
+  "a": {
+    "c": null,
+    "d": "<i>&</i>"
+  },
+  "b": [
+    1,
+    2.50,
+    12345678901234567890
+  ]
+}
+`
+	assert.Equal(t, expected, string(normalized))
+
+	_, err = normalize_json([]byte(`{"a": 1} {"b": 2}`))
+	assert.NotNil(t, err)
+}
+
+func Test_normalize_file(t *testing.T) {
+	tmp := t.TempDir()
+	file := path.Join(tmp, "elife-09560-v1.xml.json")
+	os.WriteFile(file, []byte(`{"b": 1, "a": 2}`), 0600)
+
+	normalized, err := normalize_file(file)
+	assert.Nil(t, err)
+	assert.True(t, normalized)
+	data, _ := os.ReadFile(file)
+	assert.Equal(t, "{\n  \"a\": 2,\n  \"b\": 1\n}\n", string(data))
+	info, _ := os.Stat(file)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// already normalized, nothing is written.
+	normalized, err = normalize_file(file)
+	assert.Nil(t, err)
+	assert.False(t, normalized)
+
+	// no temporary files are left behind.
+	entry_list, _ := os.ReadDir(tmp)
+	assert.Equal(t, 1, len(entry_list))
+}
+
+func Test_normalize_files(t *testing.T) {
+	tmp := t.TempDir()
+	good := path.Join(tmp, "elife-09560-v1.xml.json")
+	bad := path.Join(tmp, "elife-09561-v1.xml.json")
+	os.WriteFile(good, []byte(`{"b": 1, "a": 2}`), 0644)
+	os.WriteFile(bad, []byte(`{"b": 1,`), 0644)
+
+	// one file can't be normalized, no file is rewritten.
+	normalized_list, err := normalize_files([]string{good, bad})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), bad)
+	assert.Equal(t, 0, len(normalized_list))
+	data, _ := os.ReadFile(good)
+	assert.Equal(t, `{"b": 1, "a": 2}`, string(data))
+	entry_list, _ := os.ReadDir(tmp)
+	assert.Equal(t, 2, len(entry_list))
+
+	os.WriteFile(bad, []byte(`{"a": 1}`), 0644)
+	normalized_list, err = normalize_files([]string{good, bad})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{good, bad}, normalized_list)
+	data, _ = os.ReadFile(good)
+	assert.Equal(t, "{\n  \"a\": 2,\n  \"b\": 1\n}\n", string(data))
+	entry_list, _ = os.ReadDir(tmp)
+	assert.Equal(t, 2, len(entry_list))
+}
+
+func Test_json_pointer_line(t *testing.T) {
+	data := []byte(`{
+  "article": {
+    "id": "09560",
+    "a/b": [
+      "x",
+      {"c": 1}
+    ]
+  }
+}`)
+	cases := map[string]int{
+		"/article":          2,
+		"/article/id":       3,
+		"/article/a~1b":     4,
+		"/article/a~1b/1/c": 6,
+	}
+	for pointer, expected := range cases {
+		line, ok := json_pointer_line(data, pointer)
+		assert.True(t, ok, pointer)
+		assert.Equal(t, expected, line, pointer)
+	}
+	_, ok := json_pointer_line(data, "/article/missing")
+	assert.False(t, ok)
+}
+
+func Test_github_annotations(t *testing.T) {
+	validator, err := NewValidator(write_schema_root(t))
+	assert.Nil(t, err)
+
+	data := []byte("{\"article\": {\n  \"id\": 9560,\n  \"status\": \"poa\"\n}}")
+	result, err := validator.Validate(data)
+	assert.Nil(t, err)
+	expected := []string{"::error file=path/to/a%2Cb.json,line=2::/id: expected string, but got number"}
+	assert.Equal(t, expected, github_annotations(result, "path/to/a,b.json", data, false))
+
+	// without the file contents there are no line numbers
+	expected = []string{"::error file=stdin::/id: expected string, but got number"}
+	assert.Equal(t, expected, github_annotations(result, "stdin", nil, false))
+
+	result = Result{Error: fmt.Errorf("%w: FOO", ErrUnknownStatus)}
+	assert.Equal(t, []string{"::error file=a.json::" + result.Error.Error()}, github_annotations(result, "a.json", nil, false))
+}
+
+func Test_prepare_document__no_extract(t *testing.T) {
+	schema_root := t.TempDir()
+	model_dir := path.Join(schema_root, "dist", "model")
+	os.MkdirAll(model_dir, 0755)
+	for _, status := range []string{"poa", "vor"} {
+		// the schema describes the whole article-json document.
+		schema := `{"allOf": [
+			{"type": "object", "required": ["journal", "article"]},
+			{"properties": {"article": {"properties": {"status": {"enum": ["` + status + `"]}}}}},
+			{}
+		]}`
+		os.WriteFile(path.Join(model_dir, "article-"+status+".v1.json"), []byte(schema), 0644)
+	}
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+
+	opts := Options{NoExtract: true, CaptureError: true}
+	data := []byte("{\"article\": {\"status\": \"vor\"},\n  \"snippet\": {}\n}")
+	article := prepare_document("elife-09560-v1.xml.json", data, schema_map, opts)
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, map[string]interface{}{"article": map[string]interface{}{"status": "vor"}, "snippet": map[string]interface{}{}}, article.Data)
+
+	result := check_article(schema_map, article, opts)
+	assert.False(t, result.Success)
+	// a problem with the document itself has no line.
+	expected := []string{"::error file=elife-09560-v1.xml.json::/: missing properties: 'journal'"}
+	assert.Equal(t, expected, github_annotations(result, "elife-09560-v1.xml.json", data, true))
+
+	data = []byte(`{"journal": {}, "article": {"status": "vor"}}`)
+	article = prepare_document("elife-09560-v1.xml.json", data, schema_map, opts)
+	assert.True(t, check_article(schema_map, article, opts).Success)
+
+	// the 'article' section alone is missing the 'journal'.
+	opts.NoExtract = false
+	article = prepare_document("elife-09560-v1.xml.json", data, schema_map, opts)
+	assert.False(t, check_article(schema_map, article, opts).Success)
+}
+
+func Test_apply_schema_overlay(t *testing.T) {
+	overlay := []byte(`{"properties": {"id": {"pattern": "^0"}}}`)
+
+	patched, err := apply_schema_overlay([]byte(`{"allOf": [{"type": "object"}]}`), overlay)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"allOf": [{"type": "object"}, {"properties": {"id": {"pattern": "^0"}}}]}`, string(patched))
+
+	patched, err = apply_schema_overlay([]byte(`{"type": "object"}`), overlay)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type": "object", "allOf": [{"properties": {"id": {"pattern": "^0"}}}]}`, string(patched))
+
+	_, err = apply_schema_overlay([]byte(`{"allOf": {}}`), overlay)
+	assert.NotNil(t, err)
+	_, err = apply_schema_overlay([]byte(`{}`), []byte(`[]`))
+	assert.NotNil(t, err)
+
+	// the overlay tightens the schema
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{Overlay: overlay})
+	assert.Nil(t, err)
+	article := Article{Type: "VOR", Data: map[string]interface{}{"id": "09560", "status": "vor"}}
+	assert.True(t, validate_article(schema_map, article, false).Success)
+	article.Data = map[string]interface{}{"id": "9560", "status": "vor"}
+	assert.False(t, validate_article(schema_map, article, false).Success)
+}
+
+func Test_check_article__include_raw(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	// the 'article' is included as it was read, not as it was unmarshalled.
+	article_json := []byte(`{"article": {"status": "poa", "id": 9560, "pub": 1.50}}`)
+	article := prepare_article("elife-09560-v1.xml.json", article_json, schema_map, Options{})
+	result := check_article(schema_map, article, Options{IncludeRaw: true})
+	assert.False(t, result.Success)
+	assert.Equal(t, `{"status": "poa", "id": 9560, "pub": 1.50}`, string(result.Raw))
+	result_json, _ := json.Marshal(result)
+	assert.Contains(t, string(result_json), `"raw":{"status":"poa","id":9560,"pub":1.50}`)
+
+	// valid articles are never included
+	article = prepare_article("elife-09560-v1.xml.json", []byte(`{"article": {"id": "09560", "status": "poa"}}`), schema_map, Options{})
+	result = check_article(schema_map, article, Options{IncludeRaw: true})
+	assert.True(t, result.Success)
+	assert.Nil(t, result.Raw)
+
+	// not included unless asked for
+	article = prepare_article("elife-09560-v1.xml.json", article_json, schema_map, Options{})
+	assert.Nil(t, check_article(schema_map, article, Options{}).Raw)
+}
+
+func Test_sample_files(t *testing.T) {
+	file_list := []string{}
+	for i := 0; i < 200; i++ {
+		file_list = append(file_list, fmt.Sprintf("elife-%05d-v1.xml.json", i))
+	}
+	sampled := sample_files(file_list, 5, 42)
+	assert.Equal(t, 10, len(sampled))
+	assert.True(t, slices.IsSorted(sampled))
+	assert.Equal(t, sampled, sample_files(file_list, 5, 42))
+	assert.NotEqual(t, sampled, sample_files(file_list, 5, 43))
+
+	assert.Equal(t, file_list, sample_files(file_list, 100, 42))
+	assert.Equal(t, 1, len(sample_files(file_list, 0.1, 42)))
+}
+
+func Test_schema_fingerprint(t *testing.T) {
+	schema_root := write_schema_root(t)
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	fingerprint := schema_fingerprint(schema_map)
+	assert.Equal(t, 64, len(fingerprint))
+	assert.Equal(t, 64, len(schema_map["VOR"].Sha256))
+
+	// the same schemas have the same fingerprint
+	schema_map, err = configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, fingerprint, schema_fingerprint(schema_map))
+	assert.Equal(t, fingerprint, new_meta(schema_root, schema_map, 1, -1, time.Now(), time.Now()).SchemaFingerprint)
+
+	// patching a schema changes the fingerprint
+	schema_map, err = configure_validator(schema_root, SchemaOptions{Overlay: []byte(`{"required": ["title"]}`)})
+	assert.Nil(t, err)
+	assert.NotEqual(t, fingerprint, schema_fingerprint(schema_map))
+}
+
+func Test_new_meta(t *testing.T) {
+	schema_root := write_schema_root(t)
+	schema_map, err := configure_validator(schema_root, SchemaOptions{})
+	assert.Nil(t, err)
+	start_time := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	end_time := start_time.Add(time.Minute)
+
+	meta := new_meta(schema_root, schema_map, 4, 100, start_time, end_time)
+	assert.Equal(t, tool_version(), meta.Version)
+	assert.Equal(t, schema_root, meta.SchemaRoot)
+	assert.Equal(t, schema_fingerprint(schema_map), meta.SchemaFingerprint)
+	assert.Equal(t, 4, meta.NumWorkers)
+	assert.Equal(t, 100, meta.SampleSize)
+	assert.Equal(t, start_time, meta.StartTime)
+	assert.Equal(t, end_time, meta.EndTime)
+	host, _ := os.Hostname()
+	assert.Equal(t, host, meta.Host)
+
+	// one entry per article type.
+	assert.Equal(t, 2, len(meta.Schemas))
+	vor := meta.Schemas["VOR"]
+	assert.Equal(t, schema_map["VOR"].Path, vor.Path)
+	assert.True(t, strings.HasSuffix(vor.Path, "article-vor.v1.json"), vor.Path)
+	assert.Equal(t, 1, vor.Version)
+	assert.Equal(t, schema_map["VOR"].Schema.Draft.String(), vor.Draft)
+	assert.NotEqual(t, "", vor.Draft)
+	assert.Equal(t, schema_map["VOR"].Sha256, vor.Sha256)
+	assert.Equal(t, schema_map["VOR"].AssertFormat, vor.AssertFormat)
+	assert.Equal(t, schema_map["VOR"].AssertContent, vor.AssertContent)
+	assert.True(t, strings.HasSuffix(meta.Schemas["POA"].Path, "article-poa.v1.json"), meta.Schemas["POA"].Path)
+}
+
+func Test_ignore_keywords(t *testing.T) {
+	err := &jsonschema.ValidationError{
+		KeywordLocation: "",
+		Message:         "doesn't validate",
+		Causes: []*jsonschema.ValidationError{
+			{KeywordLocation: "/allOf/0/required", Message: "missing properties: 'title'"},
+			{KeywordLocation: "/allOf/1/properties/id/type", InstanceLocation: "/id", Message: "expected string, but got number"},
+			{KeywordLocation: "/allOf/2/anyOf", Message: "anyOf failed", Causes: []*jsonschema.ValidationError{
+				{KeywordLocation: "/allOf/2/anyOf/0/required", Message: "missing properties: 'doi'"},
+				{KeywordLocation: "/allOf/2/anyOf/1/minLength", Message: "length must be >= 1"},
+			}},
+		},
+	}
+	pruned := ignore_keywords(err, []string{"required"})
+	expected := []string{
+		"[I#/id] [S#/allOf/1/properties/id/type] expected string, but got number",
+	}
+	// the 'anyOf' is valid once its first alternative is ignored
+	assert.Equal(t, expected, flatten_validation_error(pruned))
+	// the original error is unchanged
+	assert.Equal(t, 4, len(flatten_validation_error(err)))
+
+	assert.Equal(t, 2, len(flatten_validation_error(ignore_keywords(err, []string{"minLength"}))))
+	assert.Nil(t, ignore_keywords(err, []string{"required", "type"}))
+
+	result := ignore_result_keywords(Result{Error: err, ErrorCount: 4}, []string{"required", "type"})
+	assert.True(t, result.Success)
+	assert.Nil(t, result.Error)
+	assert.Equal(t, 0, result.ErrorCount)
+	assert.Equal(t, 4, result.IgnoredErrors)
+
+	summary := summarise([]Result{result, {Success: true}}, 1, time.Time{}, time.Time{})
+	assert.Equal(t, 1, summary.PassedWithIgnored)
+	assert.Contains(t, summary.String(), "passed-with-ignored:1")
+}
+
+func Test_check_article__ignore_keywords(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	// ignored without capturing errors
+	article := Article{Type: "POA", Data: map[string]interface{}{"status": "poa"}}
+	result := check_article(schema_map, article, Options{IgnoreKeywords: []string{"required"}})
+	assert.True(t, result.Success)
+	assert.Equal(t, 1, result.IgnoredErrors)
+
+	article = Article{Type: "POA", Data: map[string]interface{}{"id": 9560, "status": "poa"}}
+	result = check_article(schema_map, article, Options{IgnoreKeywords: []string{"required"}})
+	assert.False(t, result.Success)
+	assert.Nil(t, result.Error)
+	assert.Equal(t, 1, result.ErrorCount)
+}
+
+func Test_prepare_articles(t *testing.T) {
+	schema_map := map[string]Schema{"POA": {}, "VOR": {}}
+
+	// a single document
+	article_list := prepare_articles("/a/elife-09560-v1.xml.json", []byte(`{"article": {"status": "vor"}}`), schema_map, Options{})
+	assert.Equal(t, 1, len(article_list))
+	assert.Equal(t, "/a/elife-09560-v1.xml.json", article_list[0].FileName)
+	assert.Equal(t, "VOR", article_list[0].Type)
+
+	// a list of documents, each with its own schema
+	dump := []byte(`[{"article": {"status": "vor"}}, {"article": {"status": "poa"}}, {"article": {"status": "foo"}}]`)
+	article_list = prepare_articles("/a/dump.json", dump, schema_map, Options{Hash: "md5"})
+	assert.Equal(t, 3, len(article_list))
+	assert.Equal(t, "/a/dump.json[0]", article_list[0].FileName)
+	assert.Equal(t, "VOR", article_list[0].Type)
+	assert.Equal(t, "/a/dump.json[1]", article_list[1].FileName)
+	assert.Equal(t, "POA", article_list[1].Type)
+	assert.Equal(t, len(`{"article": {"status": "poa"}}`), article_list[1].Bytes)
+	assert.Equal(t, content_hash("md5", []byte(`{"article": {"status": "poa"}}`)), article_list[1].Hash)
+	assert.ErrorIs(t, article_list[2].Error, ErrUnknownStatus)
+
+	article_list = prepare_articles("/a/dump.json", []byte(` [] `), schema_map, Options{})
+	assert.Equal(t, 1, len(article_list))
+	assert.ErrorIs(t, article_list[0].Error, ErrEmptyArticle)
+
+	article_list = prepare_articles("/a/dump.json", []byte(`[{"article": {"status": "vor"}}] [`), schema_map, Options{})
+	assert.Equal(t, 1, len(article_list))
+	assert.ErrorIs(t, article_list[0].Error, ErrTrailingData)
+}
+
+func Test_split_document_index(t *testing.T) {
+	file, index, ok := split_document_index("/a/dump.json[12]")
+	assert.True(t, ok)
+	assert.Equal(t, "/a/dump.json", file)
+	assert.Equal(t, 12, index)
+
+	file, _, ok = split_document_index("/a/elife-09560-v1.xml.json")
+	assert.False(t, ok)
+	assert.Equal(t, "/a/elife-09560-v1.xml.json", file)
+}
+
+func Test_list_files__sample_size(t *testing.T) {
+	input_path := t.TempDir()
+	for _, name := range []string{"README.md", "elife-00001-v1.xml.json", "elife-00002-v1.xml.json", "elife-00003-v1.xml.json.gz", "notes.txt"} {
+		os.WriteFile(path.Join(input_path, name), []byte("{}"), 0644)
+	}
+	os.Mkdir(path.Join(input_path, "a-directory"), 0755)
+
+	// other files and directories don't count towards the sample
+	expected := []string{path.Join(input_path, "elife-00002-v1.xml.json"), path.Join(input_path, "elife-00001-v1.xml.json")}
+	assert.Equal(t, expected, list_files(input_path, 2, default_ext_list))
+	assert.Equal(t, 3, len(list_files(input_path, 10, default_ext_list)))
+	assert.Equal(t, 3, len(list_files(input_path, -1, default_ext_list)))
+}
+
+func Test_parse_article_data__status_whitespace(t *testing.T) {
+	article, err := parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {"status": " vor\n"}}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Equal(t, []string{`whitespace trimmed from 'article.status': " vor\n"`}, article.Warnings)
+
+	article, err = parse_article_data("elife-09560-v1.xml.json", []byte(`{"article": {"status": "vor"}}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "VOR", article.Type)
+	assert.Empty(t, article.Warnings)
+
+	// the warning is reported with the result
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	result := check_article(schema_map, prepare_article("elife-09560-v1.xml.json", []byte(`{"article": {"id": "09560", "status": "poa"}, "x": {"status": "poa "}}`), schema_map, Options{StatusField: "x.status"}), Options{})
+	assert.True(t, result.Success)
+	assert.Equal(t, []string{`whitespace trimmed from 'x.status': "poa "`}, result.Warnings)
+}
+
+func Test_DiscoverSchemas(t *testing.T) {
+	schema_root := write_schema_root(t)
+	os.WriteFile(path.Join(schema_root, "dist", "model", "article-vor.v2.json"), []byte(`{}`), 0644)
+
+	info_map, err := DiscoverSchemas(schema_root)
+	assert.Nil(t, err)
+	expected := map[string]SchemaInfo{
+		"POA": {Label: "POA", Path: path.Join(schema_root, "dist/model/article-poa.v1.json"), Version: 1, Versions: []int{1}},
+		"VOR": {Label: "VOR", Path: path.Join(schema_root, "dist/model/article-vor.v2.json"), Version: 2, Versions: []int{1, 2}},
+	}
+	assert.Equal(t, expected, info_map)
+
+	_, err = DiscoverSchemas(t.TempDir())
+	assert.ErrorContains(t, err, "failed to find a POA schema")
+}
+
+func Test_ResolveRamlSchemas(t *testing.T) {
+	schema_root := write_schema_root(t)
+	// a newer schema that isn't served yet, globbing would select it.
+	os.WriteFile(path.Join(schema_root, "dist", "model", "article-vor.v2.json"), []byte(`{}`), 0644)
+
+	_, err := ResolveRamlSchemas(schema_root)
+	assert.ErrorContains(t, err, "no RAML found")
+
+	raml := `#%RAML 1.0
+/articles/{id}/versions/{version}:
+  get:
+    responses:
+      200:
+        body:
+          application/vnd.elife.article-poa+json; version=1:
+            type: !include model/article-poa.v1.json
+            example: !include samples/article-poa/v1/complete.json
+          application/vnd.elife.article-vor+json; version=1:
+            example: !include samples/article-vor/v1/complete.json
+            type: !include model/article-vor.v1.json
+`
+	os.WriteFile(path.Join(schema_root, "dist", "api.raml"), []byte(raml), 0644)
+	info_map, err := ResolveRamlSchemas(schema_root)
+	assert.Nil(t, err)
+	expected := map[string]SchemaInfo{
+		"POA": {Label: "POA", Path: path.Join(schema_root, "dist/model/article-poa.v1.json"), Version: 1, Versions: []int{1}},
+		"VOR": {Label: "VOR", Path: path.Join(schema_root, "dist/model/article-vor.v1.json"), Version: 1, Versions: []int{1}},
+	}
+	assert.Equal(t, expected, info_map)
+
+	schema_map, err := configure_validator(schema_root, SchemaOptions{Raml: true})
+	assert.Nil(t, err)
+	assert.Equal(t, path.Join(schema_root, "dist/model/article-vor.v1.json"), schema_map["VOR"].Path)
+
+	// the uncompiled RAML includes yaml, which resolves to the compiled json.
+	os.Remove(path.Join(schema_root, "dist", "api.raml"))
+	os.MkdirAll(path.Join(schema_root, "src"), 0755)
+	raml = strings.ReplaceAll(raml, ".v1.json", ".v1.yaml")
+	os.WriteFile(path.Join(schema_root, "src", "api.raml"), []byte(raml), 0644)
+	info_map, err = ResolveRamlSchemas(schema_root)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, info_map)
+
+	raml = strings.ReplaceAll(raml, "version=1:\n            example", "version=3:\n            example")
+	raml = strings.ReplaceAll(raml, "article-vor.v1.yaml", "article-vor.v3.yaml")
+	os.WriteFile(path.Join(schema_root, "src", "api.raml"), []byte(raml), 0644)
+	_, err = ResolveRamlSchemas(schema_root)
+	assert.ErrorContains(t, err, "failed to find a VOR schema: src/api.raml declares dist/model/article-vor.v3.json")
+}
+
+func Test_filter_article_ids(t *testing.T) {
+	id_list, err := parse_article_id_list("09560, 12345,")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"9560", "12345"}, id_list)
+
+	_, err = parse_article_id_list("09560,elife-12345")
+	assert.EqualError(t, err, "article id is not a number: elife-12345")
+	_, err = parse_article_id_list(",")
+	assert.NotNil(t, err)
+
+	file_list := []string{
+		"path/to/elife-09560-v1.xml.json",
+		"path/to/elife-09561-v1.xml.json",
+		"path/to/elife-12345-v2.xml.json.gz",
+		"path/to/list.json",
+	}
+	expected := []string{"path/to/elife-09560-v1.xml.json", "path/to/elife-12345-v2.xml.json.gz"}
+	assert.Equal(t, expected, filter_article_ids(file_list, id_list))
+
+	assert.Equal(t, []string{}, missing_article_ids(file_list, id_list))
+	assert.Equal(t, []string{"11111"}, missing_article_ids(file_list, []string{"9560", "11111"}))
+}
+
+func Test_do__only_ids(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	for _, id := range []string{"00001", "00002", "00003"} {
+		os.WriteFile(path.Join(input_path, "elife-"+id+"-v1.xml.json"), []byte(`{"article": {"id": "`+id+`", "status": "vor"}}`), 0644)
+	}
+
+	// the ids are found before the sample is taken.
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--only-ids", "3", "--sample-size", "1")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "elife-00003-v1.xml.json")
+
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--only-ids", "3,12345")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "no article-json files found for 1 of the --only-ids: 12345")
+
+	stdout, _, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--only-ids", "12345,09560")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout, "no article-json files found for --only-ids: 12345, 9560")
+}
+
+func Test_do__no_fail(t *testing.T) {
+	schema_root := write_schema_root(t)
+	input_path := t.TempDir()
+	os.WriteFile(path.Join(input_path, "elife-00001-v1.xml.json"), []byte(`{"article": {"id": "00001", "status": "vor"}}`), 0644)
+	os.WriteFile(path.Join(input_path, "elife-00002-v1.xml.json"), []byte(`{"article": {"status": "vor"}}`), 0644)
+
+	_, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", input_path)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "articles:2, failures:1, ")
+	assert.NotContains(t, stderr, "--no-fail")
+
+	// the failure is still counted, only the exit code changes.
+	_, stderr, code = run_command(t, "--schema-root", schema_root, "--article-json", input_path, "--no-fail")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "articles:2, failures:1, ")
+	assert.Contains(t, stderr, "exiting with 0 instead of 1 (--no-fail)")
+
+	// and for a single file.
+	stdout, stderr, code := run_command(t, "--schema-root", schema_root, "--article-json", path.Join(input_path, "elife-00002-v1.xml.json"), "--no-fail")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "missing properties: 'id'")
+	assert.Contains(t, stderr, "exiting with 0 instead of 1 (--no-fail)")
+}
+
+func Test_schema_coverage(t *testing.T) {
+	schema_map, err := configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+
+	coverage := new_schema_coverage()
+	opts := Options{Coverage: coverage}
+	for _, article_json := range []string{
+		`{"article": {"id": "09560", "status": "vor"}}`,
+		`{"article": {"status": "vor"}}`,
+		`{"article": {"status": "poa"}}`,
+	} {
+		article := prepare_article("elife-09560-v1.xml.json", []byte(article_json), schema_map, opts)
+		check_article(schema_map, article, opts)
+	}
+	// articles that couldn't be read aren't counted.
+	check_article(schema_map, Article{Type: "VOR", Error: ErrEmptyArticle}, opts)
+
+	coverage_map := coverage.report(schema_map)
+	vor := coverage_map["VOR"]
+	assert.Equal(t, 2, vor.Articles)
+	// the ISBN patch adds 'references' to the VOR schema, no article has any.
+	assert.Equal(t, 8, vor.Locations)
+	assert.Equal(t, 6, vor.Evaluated)
+	assert.Equal(t, 75.0, vor.Percent)
+	assert.Equal(t, 0, vor.Counts["VOR#/allOf/2/properties/references"])
+	assert.Equal(t, 1, vor.Counts["VOR#/allOf/1/properties/id"])
+	assert.Equal(t, 2, vor.Counts["VOR#/allOf/1/properties/status"])
+
+	poa := coverage_map["POA"]
+	assert.Equal(t, 1, poa.Articles)
+	assert.Equal(t, 5, poa.Evaluated)
+	assert.Equal(t, 83.3, poa.Percent)
+	assert.Equal(t, 0, poa.Counts["POA#/allOf/1/properties/id"])
+}
+
+// starts a server speaking just enough of the S3 API to list and get the objects of "bucket" from the local directory
+// `bucket_dir`, and points the shared S3 client at it. listings are paged two keys at a time.
+func fake_s3_server(t *testing.T, bucket_dir string) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		if r.URL.Path == "/bucket" || r.URL.Path == "/bucket/" {
+			query := r.URL.Query()
+			key_list := []string{}
+			filepath.WalkDir(bucket_dir, func(file_path string, entry fs.DirEntry, err error) error {
+				name, _ := filepath.Rel(bucket_dir, file_path)
+				if err == nil && !entry.IsDir() && strings.HasPrefix(name, query.Get("prefix")) {
+					rest := strings.TrimPrefix(name, query.Get("prefix"))
+					if query.Get("delimiter") == "" || !strings.Contains(rest, query.Get("delimiter")) {
+						key_list = append(key_list, name)
+					}
+				}
+				return nil
+			})
+			start, _ := strconv.Atoi(query.Get("continuation-token"))
+			end := min(start+2, len(key_list))
+			body := `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name>`
+			for _, key := range key_list[start:end] {
+				body += fmt.Sprintf("<Contents><Key>%s</Key><Size>1234</Size></Contents>", key)
+			}
+			body += fmt.Sprintf("<KeyCount>%d</KeyCount><IsTruncated>%t</IsTruncated>", end-start, end < len(key_list))
+			if end < len(key_list) {
+				body += fmt.Sprintf("<NextContinuationToken>%d</NextContinuationToken>", end)
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, body+"</ListBucketResult>")
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(bucket_dir, key))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Write(data)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", path.Join(bucket_dir, "missing"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(bucket_dir, "missing"))
+	s3_client_cache = nil
+	t.Cleanup(func() { s3_client_cache = nil })
+}
+
+func Test_list_files__s3(t *testing.T) {
+	bucket_dir := t.TempDir()
+	os.WriteFile(path.Join(bucket_dir, "elife-09560-v1.xml.json"), []byte(`{"article": {"id": "09560", "status": "vor"}}`), 0644)
+	os.WriteFile(path.Join(bucket_dir, "elife-09561-v1.xml.json"), []byte(`{"article": {"id": "09561", "status": "poa"}}`), 0644)
+	os.WriteFile(path.Join(bucket_dir, "elife-09562-v1.xml.json"), []byte(`{"article": {"status": `), 0644)
+	os.WriteFile(path.Join(bucket_dir, "readme.txt"), []byte(``), 0644)
+	os.Mkdir(path.Join(bucket_dir, "sub"), 0755)
+	os.WriteFile(path.Join(bucket_dir, "sub", "elife-09563-v1.xml.json"), []byte(`{"article": {"status": "vor"}}`), 0644)
+	fake_s3_server(t, bucket_dir)
+
+	assert.True(t, path_is_dir("s3://bucket/"))
+	assert.False(t, path_is_dir("s3://bucket/elife-09560-v1.xml.json"))
+
+	// listings are paged and sub-prefixes aren't listed.
+	expected := []string{"s3://bucket/elife-09562-v1.xml.json", "s3://bucket/elife-09561-v1.xml.json", "s3://bucket/elife-09560-v1.xml.json"}
+	assert.Equal(t, expected, list_files("s3://bucket/", -1, default_ext_list))
+	assert.Equal(t, []string{"s3://bucket/sub/elife-09563-v1.xml.json"}, list_files("s3://bucket/sub/", -1, default_ext_list))
+
+	schema_map := map[string]Schema{"VOR": {}, "POA": {}}
+	article := read_article_data("s3://bucket/elife-09561-v1.xml.json", schema_map, Options{})
+	assert.Nil(t, article.Error)
+	assert.Equal(t, "POA", article.Type)
+	assert.Equal(t, "s3://bucket/elife-09561-v1.xml.json", relative_file_name("s3://bucket/", article.FileName))
+	assert.Equal(t, "s3://bucket/elife-09561-v1.xml.json", absolute_file_name("", article.FileName))
+
+	_, err := read_s3_object("s3://bucket/missing.json")
+	assert.ErrorContains(t, err, "NoSuchKey")
+
+	// an object that can't be read fails rather than stopping the batch.
+	schema_map, err = configure_validator(write_schema_root(t), SchemaOptions{})
+	assert.Nil(t, err)
+	file_list := []string{"s3://bucket/elife-09560-v1.xml.json", "s3://bucket/missing.json", "s3://bucket/elife-09562-v1.xml.json"}
+	_, _, result_list := process_files_with_feeder(file_list, schema_map, Options{BufferSize: 1, NumWorkers: 1, CaptureError: true})
+	error_map := map[string]string{}
+	for _, result := range result_list {
+		if !result.Success {
+			error_map[result.FileName] = result.Error.Error()
+		}
+	}
+	assert.Len(t, result_list, 3)
+	assert.Contains(t, error_map["s3://bucket/missing.json"], "failed reading file: failed to read S3 object: ")
+	assert.Equal(t, "failed parsing article-json: not valid json", error_map["s3://bucket/elife-09562-v1.xml.json"])
+	assert.NotContains(t, error_map, "s3://bucket/elife-09560-v1.xml.json")
+}
+
+func Test_fetch_s3_schema_root(t *testing.T) {
+	bucket_dir := t.TempDir()
+	schema_root := write_schema_root(t)
+	filepath.WalkDir(schema_root, func(file_path string, entry fs.DirEntry, err error) error {
+		name, _ := filepath.Rel(schema_root, file_path)
+		if !entry.IsDir() {
+			os.MkdirAll(filepath.Join(bucket_dir, "api-raml", filepath.Dir(name)), 0755)
+			data, _ := os.ReadFile(file_path)
+			os.WriteFile(filepath.Join(bucket_dir, "api-raml", name), data, 0644)
+		}
+		return nil
+	})
+	fake_s3_server(t, bucket_dir)
+
+	local_path, err := fetch_s3_schema_root("s3://bucket/api-raml/")
+	assert.Nil(t, err)
+	_, err = configure_validator(local_path, SchemaOptions{})
+	assert.Nil(t, err)
+
+	_, err = fetch_s3_schema_root("s3://bucket/missing.zip")
+	assert.ErrorContains(t, err, "failed to copy schema root from S3: ")
+}
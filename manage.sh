@@ -10,6 +10,7 @@ if test ! "$cmd"; then
     echo "  build           build project"
     echo "  clean           deletes all generated files"
     echo "  cpu-graph       launches Go's profile visualiser"
+    echo "  mem-graph       launches Go's profile visualiser for the heap profile"
     echo "  release         build project for distribution"
     echo "  test            run tests"
     echo "  test.coverage   run tests, then show coverage report"
@@ -31,13 +32,20 @@ elif test "$cmd" = "clean"; then
     # validate-article-json - generated by Go because of go.mod.
     # linux-amd64* linux-arm64* - generated by the 'release' command.
     # coverage.out coverage.html - generated by the 'test.coverage' command.
-    rm -fv validate-article-json linux-amd64* linux-arm64* coverage.*
+    # mem.prof - generated by VAJ_MEMPROFILE.
+    # trace.out - generated by --trace.
+    rm -fv validate-article-json linux-amd64* linux-arm64* coverage.* mem.prof trace.out
     exit 0
 
 elif test "$cmd" = "cpu-graph"; then
     go tool pprof -http 127.0.0.1:1236 cpu.prof
     exit 0
 
+elif test "$cmd" = "mem-graph"; then
+    # -sample_index 'alloc_space' shows everything allocated during the run rather than what was still in use at the end
+    go tool pprof -http 127.0.0.1:1237 -sample_index alloc_space mem.prof
+    exit 0
+
 elif test "$cmd" = "release"; then
     # GOOS is 'Go OS' and is being explicit in which OS to build for.
     # CGO_ENABLED=0 skips CGO and linking against glibc to build static binaries.